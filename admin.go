@@ -0,0 +1,224 @@
+// admin.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+
+	"maxcool.com/weatherapp/internal/audit"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// cliActor names the operating system user running a command as the actor of the changes it makes
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// runUser executes `user create|list|delete|export|erase`
+func runUser(cfg *config.Config, args []string) error {
+	const usage = "usage: weatherapp user create --name NAME --email EMAIL | list | delete ID | export ID | erase ID"
+	if len(args) == 0 {
+		return fmt.Errorf("missing user command\n%s", usage)
+	}
+
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	ctx := audit.WithActor(context.Background(), cliActor())
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+		name := fs.String("name", "", "name of the user")
+		email := fs.String("email", "", "email address of the user")
+		timezone := fs.String("timezone", "", "IANA time zone of the user, UTC by default")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" || *email == "" {
+			return fmt.Errorf("--name and --email are required\n%s", usage)
+		}
+
+		user := &models.User{Name: *name, Email: *email, Timezone: *timezone}
+		if err := a.UserService.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return printJSON(user)
+	case "list":
+		users, err := a.UserService.GetUsers(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tTIMEZONE\tQUIET HOURS\tDIGEST")
+		for _, u := range users {
+			quietHours := "-"
+			if u.QuietHoursStart != "" {
+				quietHours = fmt.Sprintf("%s-%s (%s)", u.QuietHoursStart, u.QuietHoursEnd, u.QuietHoursPolicy)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%t\n", u.Id, u.Name, u.Email, u.Timezone, quietHours, u.DigestMode)
+		}
+		return w.Flush()
+	case "delete":
+		id, err := userIDArg(args, usage)
+		if err != nil {
+			return err
+		}
+		deleted, err := a.UserService.DeleteUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("user %d not found", id)
+		}
+		fmt.Printf("User %d deleted\n", id)
+		return nil
+	case "export":
+		id, err := userIDArg(args, usage)
+		if err != nil {
+			return err
+		}
+		export, err := a.UserService.ExportUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if export == nil {
+			return fmt.Errorf("user %d not found", id)
+		}
+		return printJSON(export)
+	case "erase":
+		id, err := userIDArg(args, usage)
+		if err != nil {
+			return err
+		}
+		erased, err := a.UserService.EraseUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !erased {
+			return fmt.Errorf("user %d not found", id)
+		}
+		fmt.Printf("User %d erased\n", id)
+		return nil
+	default:
+		return fmt.Errorf("unknown user command %q\n%s", args[0], usage)
+	}
+}
+
+// userIDArg parses the user ID following a user command
+func userIDArg(args []string, usage string) (int, error) {
+	if len(args) < 2 {
+		return 0, fmt.Errorf("missing user ID\n%s", usage)
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid user ID: %s", args[1])
+	}
+	return id, nil
+}
+
+// runSubscription executes `subscription list|test`
+func runSubscription(cfg *config.Config, args []string) error {
+	const usage = "usage: weatherapp subscription list [--user ID] | test ID"
+	if len(args) == 0 {
+		return fmt.Errorf("missing subscription command\n%s", usage)
+	}
+
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	ctx := audit.WithActor(context.Background(), cliActor())
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("subscription list", flag.ContinueOnError)
+		userID := fs.Int("user", 0, "only list the subscriptions of this user")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		var subscriptions []models.Subscription
+		if *userID != 0 {
+			byUser, err := a.SubscriptionService.GetSubscriptionsByUserID(ctx, *userID)
+			if err != nil {
+				return err
+			}
+			for _, sub := range byUser {
+				subscriptions = append(subscriptions, *sub)
+			}
+		} else {
+			if subscriptions, err = a.SubscriptionService.GetSubscriptions(ctx); err != nil {
+				return err
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tCITY\tCONDITION\tACTIVE")
+		for _, sub := range subscriptions {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%t\n", sub.Id, sub.UserId, sub.City, sub.Condition, sub.Active)
+		}
+		return w.Flush()
+	case "test":
+		if len(args) < 2 {
+			return fmt.Errorf("missing subscription ID\n%s", usage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid subscription ID: %s", args[1])
+		}
+
+		subscription, err := a.SubscriptionService.GetSubscriptionByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if subscription == nil {
+			return services.ErrSubscriptionNotFound
+		}
+
+		evaluation, err := a.SubscriptionService.EvaluateCondition(ctx, subscription.Condition, subscription.City)
+		if err != nil {
+			return err
+		}
+		return printJSON(evaluation)
+	default:
+		return fmt.Errorf("unknown subscription command %q\n%s", args[0], usage)
+	}
+}
+
+// runWeather executes `weather get <city>`
+// It only talks to the weather provider and does not need the database
+func runWeather(cfg *config.Config, args []string) error {
+	const usage = "usage: weatherapp weather get CITY"
+	if len(args) < 2 || args[0] != "get" {
+		return fmt.Errorf("%s", usage)
+	}
+
+	subscriptionService := services.NewSubscriptionService(nil, cfg)
+	weather, err := subscriptionService.GetWeather(context.Background(), args[1])
+	if err != nil {
+		return err
+	}
+	return printJSON(weather)
+}
@@ -0,0 +1,65 @@
+// app.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/metrics"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// app bundles the database connection and the services shared by all commands
+type app struct {
+	Config              *config.Config
+	DB                  *database.DB
+	UserService         *services.UserService
+	SubscriptionService *services.SubscriptionService
+	NotificationService *services.NotificationService
+	AuditService        *services.AuditService
+}
+
+// newApp connects to the database and constructs the services
+// With migrate set it first makes sure the database exists and applies pending migrations
+func newApp(cfg *config.Config, migrate bool) (*app, error) {
+	if migrate {
+		// An SQLite database file is created on first connection
+		if !cfg.UsesSQLite() {
+			if err := database.EnsureDatabaseExists("weatherapp", cfg.PostgresConnectionString); err != nil {
+				return nil, fmt.Errorf("failed to ensure database existence: %w", err)
+			}
+			slog.Info("Correct database existance ensured.")
+		}
+
+		if err := database.MigrateUpAll(cfg); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+
+	// Initialize Database Connection
+	db, err := database.NewDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	slog.Info("Database connection established.")
+
+	if err := metrics.RegisterDB(db.SQL, "weatherapp"); err != nil {
+		slog.Warn("Failed to register database metrics", "error", err)
+	}
+
+	return &app{
+		Config:              cfg,
+		DB:                  db,
+		UserService:         services.NewUserService(db),
+		SubscriptionService: services.NewSubscriptionService(db, cfg),
+		NotificationService: services.NewNotificationService(db),
+		AuditService:        services.NewAuditService(db),
+	}, nil
+}
+
+// Close releases the database connection
+func (a *app) Close() {
+	a.DB.Close()
+}
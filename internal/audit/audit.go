@@ -0,0 +1,92 @@
+// internal/audit/audit.go
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/ratelimit"
+)
+
+// SystemActor is recorded for changes made without an actor in the context, e.g. by scheduled jobs
+const SystemActor = "system"
+
+// AdminActor is recorded for requests authenticated with the ADMIN_TOKEN
+const AdminActor = "admin"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying the actor recorded with the changes made on its behalf
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor carried by ctx, or SystemActor if there is none
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// Middleware makes the client of each request the actor of the changes it causes
+// Clients are identified like the rate limiter does: "api:key:<hash of the API key>" for a configured API key,
+// otherwise "anonymous:ip:<address>"; admin endpoints replace it with AdminActor once the token is checked
+func Middleware(clients *ratelimit.Clients) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, authenticated := clients.Identify(r)
+			actor := "anonymous:" + key
+			if authenticated {
+				actor = "api:" + key
+			}
+			ctx := WithActor(r.Context(), actor)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Record appends an event with the actor and request ID of ctx to the audit log
+// before and after are encoded as JSON; pass nil for the missing side of a create or delete
+// Record is meant to be called with the transaction that makes the change, so the change
+// and its event are stored together or not at all
+func Record(ctx context.Context, db database.IDB, entityType string, entityID int, action string, before, after any) error {
+	event := &models.AuditEvent{
+		EntityType: entityType,
+		EntityId:   entityID,
+		Action:     action,
+		Actor:      Actor(ctx),
+		RequestId:  logging.RequestID(ctx),
+	}
+
+	var err error
+	if event.Before, err = encode(before); err != nil {
+		return err
+	}
+	if event.After, err = encode(after); err != nil {
+		return err
+	}
+
+	if _, err := db.CreateAuditEvent(ctx, event); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// encode returns the JSON encoding of v, or nil for nil and nil pointers
+func encode(v any) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit state: %w", err)
+	}
+	if bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	return data, nil
+}
@@ -3,40 +3,610 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	OpenWeatherMapAPIKey     string
+	OpenWeatherMapBaseURL    string
+	WeatherRequestTimeout    time.Duration
 	PostgresConnectionString string
+	DBMaxOpenConns           int
+	DBMaxIdleConns           int
+	DBConnMaxLifetime        time.Duration
+	DBDriver                 string
+	DBMinConns               int
+	DBConnMaxIdleTime        time.Duration
+	DBHealthCheckPeriod      time.Duration
+	DBQueryExecMode          string
+	DBStatementCacheCapacity int
 	ServerPort               string
+	ServerReadTimeout        time.Duration
+	ServerWriteTimeout       time.Duration
+	ServerIdleTimeout        time.Duration
+	ShutdownTimeout          time.Duration
 	ResendApiKey             string
+	ResendBaseURL            string
+	EmailFrom                string
+	EmailRequestTimeout      time.Duration
+	NotificationTime         string
+	DeferredFlushInterval    time.Duration
+	NotificationBatchSize    int
+	PurgeInterval            time.Duration
+	PurgeRetention           time.Duration
+	LogLevel                 string
+	LogFormat                string
+	LogRedactEmails          bool
+	TracingExporter          string
+	TracingOTLPEndpoint      string
+	TracingServiceName       string
+	TracingSampleRatio       float64
+	RateLimitEnabled         bool
+	RateLimitDefault         string
+	RateLimitRoutes          string
+	RateLimitTrustProxy      bool
+	APIKeys                  string
+	AdminToken               string
+
+	// flagArgs and configFile remember where the configuration came from, so it can be reloaded
+	flagArgs   []string
+	configFile string
+}
+
+// reloadable lists the fields that can change while the application runs;
+// changes to any other field only take effect after a restart
+var reloadable = map[string]bool{
+	"OpenWeatherMapAPIKey":  true,
+	"OpenWeatherMapBaseURL": true,
+	"WeatherRequestTimeout": true,
+	"ResendApiKey":          true,
+	"ResendBaseURL":         true,
+	"EmailFrom":             true,
+	"EmailRequestTimeout":   true,
+	"NotificationTime":      true,
+	"DeferredFlushInterval": true,
+	"PurgeInterval":         true,
+	"PurgeRetention":        true,
+	"LogLevel":              true,
+	"LogRedactEmails":       true,
+}
+
+// setting describes one configuration key
+// The same key is used for the environment variable, in lower case for the config file,
+// and in lower case with dashes for the command line flag (e.g. PORT, port, --port)
+type setting struct {
+	key          string
+	defaultValue string
+	usage        string
+	apply        func(c *Config, value string) error
+}
+
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
 }
 
+func intSetting(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("not an integer: %q", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolSetting(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("not a boolean (true or false): %q", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func floatSetting(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("not a number: %q", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func durationSetting(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("not a duration (e.g. 10s, 5m): %q", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"OPENWEATHERMAP_API_KEY", "", "OpenWeatherMap API key",
+		stringSetting(func(c *Config) *string { return &c.OpenWeatherMapAPIKey })},
+	{"OPENWEATHERMAP_BASE_URL", "https://api.openweathermap.org/data/2.5/weather", "OpenWeatherMap current weather endpoint",
+		stringSetting(func(c *Config) *string { return &c.OpenWeatherMapBaseURL })},
+	{"WEATHER_REQUEST_TIMEOUT", "10s", "timeout of a single weather API request",
+		durationSetting(func(c *Config) *time.Duration { return &c.WeatherRequestTimeout })},
+	{"POSTGRES_CONNECTION_STRING", "", "PostgreSQL connection URL, or sqlite://path/to/file.db for SQLite",
+		stringSetting(func(c *Config) *string { return &c.PostgresConnectionString })},
+	{"DB_MAX_OPEN_CONNS", "25", "maximum number of open database connections",
+		intSetting(func(c *Config) *int { return &c.DBMaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "25", "maximum number of idle database connections",
+		intSetting(func(c *Config) *int { return &c.DBMaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "5m", "maximum lifetime of a database connection",
+		durationSetting(func(c *Config) *time.Duration { return &c.DBConnMaxLifetime })},
+	{"DB_DRIVER", "stdlib", "database driver: stdlib (database/sql connection pool) or pgxpool (native pgx pool)",
+		stringSetting(func(c *Config) *string { return &c.DBDriver })},
+	{"DB_MIN_CONNS", "0", "minimum number of open connections kept by the pgxpool driver",
+		intSetting(func(c *Config) *int { return &c.DBMinConns })},
+	{"DB_CONN_MAX_IDLE_TIME", "30m", "how long an idle database connection is kept",
+		durationSetting(func(c *Config) *time.Duration { return &c.DBConnMaxIdleTime })},
+	{"DB_HEALTH_CHECK_PERIOD", "1m", "how often the pgxpool driver checks idle connections",
+		durationSetting(func(c *Config) *time.Duration { return &c.DBHealthCheckPeriod })},
+	{"DB_QUERY_EXEC_MODE", "cache_statement", "how queries are sent: cache_statement, cache_describe, describe_exec, exec or simple_protocol",
+		stringSetting(func(c *Config) *string { return &c.DBQueryExecMode })},
+	{"DB_STATEMENT_CACHE_CAPACITY", "512", "number of prepared statements cached per connection",
+		intSetting(func(c *Config) *int { return &c.DBStatementCacheCapacity })},
+	{"PORT", "8080", "port the HTTP server listens on",
+		stringSetting(func(c *Config) *string { return &c.ServerPort })},
+	{"SERVER_READ_TIMEOUT", "10s", "HTTP server read timeout",
+		durationSetting(func(c *Config) *time.Duration { return &c.ServerReadTimeout })},
+	{"SERVER_WRITE_TIMEOUT", "10s", "HTTP server write timeout",
+		durationSetting(func(c *Config) *time.Duration { return &c.ServerWriteTimeout })},
+	{"SERVER_IDLE_TIMEOUT", "120s", "HTTP server idle connection timeout",
+		durationSetting(func(c *Config) *time.Duration { return &c.ServerIdleTimeout })},
+	{"SHUTDOWN_TIMEOUT", "5s", "time to wait for active requests on shutdown",
+		durationSetting(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"RESEND_API_KEY", "", "Resend API key for email notifications",
+		stringSetting(func(c *Config) *string { return &c.ResendApiKey })},
+	{"RESEND_BASE_URL", "https://api.resend.com/", "Resend API base URL",
+		stringSetting(func(c *Config) *string { return &c.ResendBaseURL })},
+	{"EMAIL_FROM", "weatherapp@resend.dev", "sender address of notification emails",
+		stringSetting(func(c *Config) *string { return &c.EmailFrom })},
+	{"EMAIL_REQUEST_TIMEOUT", "30s", "timeout of a single email API request",
+		durationSetting(func(c *Config) *time.Duration { return &c.EmailRequestTimeout })},
+	{"NOTIFICATION_TIME", "12:00", "daily time (HH:MM, server time zone) of the notification run",
+		stringSetting(func(c *Config) *string { return &c.NotificationTime })},
+	{"DEFERRED_FLUSH_INTERVAL", "15m", "how often notifications deferred by quiet hours are checked",
+		durationSetting(func(c *Config) *time.Duration { return &c.DeferredFlushInterval })},
+	{"NOTIFICATION_BATCH_SIZE", "500", "number of subscriptions the notification run loads from the database at a time",
+		intSetting(func(c *Config) *int { return &c.NotificationBatchSize })},
+	{"PURGE_INTERVAL", "1h", "how often deleted users and subscriptions past their retention are purged",
+		durationSetting(func(c *Config) *time.Duration { return &c.PurgeInterval })},
+	{"PURGE_RETENTION", "720h", "how long deleted users and subscriptions are kept before they are purged",
+		durationSetting(func(c *Config) *time.Duration { return &c.PurgeRetention })},
+	{"LOG_LEVEL", "info", "minimum level of log messages: debug, info, warn or error",
+		stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	{"LOG_FORMAT", "text", "format of log messages: text or json",
+		stringSetting(func(c *Config) *string { return &c.LogFormat })},
+	{"LOG_REDACT_EMAILS", "false", "mask email addresses in log messages",
+		boolSetting(func(c *Config) *bool { return &c.LogRedactEmails })},
+	{"TRACING_EXPORTER", "none", "where traces are sent: none, stdout or otlp",
+		stringSetting(func(c *Config) *string { return &c.TracingExporter })},
+	{"TRACING_OTLP_ENDPOINT", "localhost:4318", "host:port of the OTLP/HTTP trace collector",
+		stringSetting(func(c *Config) *string { return &c.TracingOTLPEndpoint })},
+	{"TRACING_SERVICE_NAME", "weatherapp", "service name attached to traces",
+		stringSetting(func(c *Config) *string { return &c.TracingServiceName })},
+	{"TRACING_SAMPLE_RATIO", "1", "fraction of traces to record, between 0 and 1",
+		floatSetting(func(c *Config) *float64 { return &c.TracingSampleRatio })},
+	{"RATE_LIMIT_ENABLED", "true", "throttle requests per client IP or API key",
+		boolSetting(func(c *Config) *bool { return &c.RateLimitEnabled })},
+	{"RATE_LIMIT_DEFAULT", "off", "limit for routes not listed in RATE_LIMIT_ROUTES, e.g. 60/m, or off",
+		stringSetting(func(c *Config) *string { return &c.RateLimitDefault })},
+	{"RATE_LIMIT_ROUTES", "POST /user=5/m,POST /subscribe=10/m,POST /conditions/evaluate=20/m,GET /weather=60/m", "comma separated per-route limits, e.g. POST /user=5/m",
+		stringSetting(func(c *Config) *string { return &c.RateLimitRoutes })},
+	{"RATE_LIMIT_TRUST_PROXY", "false", "identify clients by the first X-Forwarded-For address",
+		boolSetting(func(c *Config) *bool { return &c.RateLimitTrustProxy })},
+	{"API_KEYS", "", "comma separated API keys accepted in the X-API-Key header; other keys are ignored",
+		stringSetting(func(c *Config) *string { return &c.APIKeys })},
+	{"ADMIN_TOKEN", "", "bearer token of the /admin, user export and erase endpoints, which are disabled when empty",
+		stringSetting(func(c *Config) *string { return &c.AdminToken })},
+}
+
+// flagName turns a setting key into its command line flag name
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// LoadConfig loads the configuration without command line flags
 func LoadConfig() (*Config, error) {
-	// Load environment variables
-	if err := godotenv.Load(".env"); err != nil {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
+	cfg, _, err := Load(nil)
+	return cfg, err
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// defaults, an optional YAML or TOML config file (--config or CONFIG_FILE), an optional .env file,
+// environment variables and command line flags
+// It returns the configuration and the arguments that follow the flags
+// All problems are reported together in a single error
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("weatherapp", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.key] = fs.String(flagName(s.key), "", fmt.Sprintf("%s (env %s, default %q)", s.usage, s.key, s.defaultValue))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// .env is optional: deployments usually inject real environment variables,
+	// and variables that are already set are never overridden by it
+	// It is read on every Load rather than copied into the environment, so a reload picks up its edits
+	dotenv, err := godotenv.Read(".env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("error loading .env file: %w", err)
+	}
+	lookupEnv := func(key string) string {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+		return dotenv[key]
+	}
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.key] = s.defaultValue
+	}
+
+	if *configFile == "" {
+		*configFile = lookupEnv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		fileValues, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
 	}
 
-	cfg := &Config{
-		PostgresConnectionString: os.Getenv("POSTGRES_CONNECTION_STRING"),
-		OpenWeatherMapAPIKey:     os.Getenv("OPENWEATHERMAP_API_KEY"),
-		ServerPort:               os.Getenv("PORT"),
-		ResendApiKey:             os.Getenv("RESEND_API_KEY"),
+	for _, s := range settings {
+		if value := lookupEnv(s.key); value != "" {
+			values[s.key] = value
+		}
 	}
 
-	// Add basic validation
-	if cfg.PostgresConnectionString == "" {
-		return nil, fmt.Errorf("DATABASE_URL not set")
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if flagName(s.key) == f.Name {
+				values[s.key] = *flagValues[s.key]
+			}
+		}
+	})
+
+	// Defaults are applied first, so a value that fails to parse is reported
+	// once here and does not trigger a second, misleading validation error
+	cfg := &Config{}
+	var errs []error
+	for _, s := range settings {
+		_ = s.apply(cfg, s.defaultValue)
+		if err := s.apply(cfg, values[s.key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.key, err))
+		}
 	}
+	errs = append(errs, cfg.validate()...)
+
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	cfg.flagArgs = args[:len(args)-len(fs.Args())]
+	cfg.configFile = *configFile
+	return cfg, fs.Args(), nil
+}
+
+// Reload loads the configuration again from the same config file and flags
+// The current configuration is left untouched
+func (c *Config) Reload() (*Config, error) {
+	cfg, _, err := Load(c.flagArgs)
+	return cfg, err
+}
+
+// ConfigFile returns the path of the config file the configuration was read from, if any
+func (c *Config) ConfigFile() string {
+	return c.configFile
+}
 
-	if cfg.ServerPort == "" {
-		cfg.ServerPort = "8080" // Default port if not set
+// Diff compares two configurations and returns the names of the changed fields,
+// split into those that can be applied at runtime and those that need a restart
+func (c *Config) Diff(other *Config) (reloadableChanges, restartChanges []string) {
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() || reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+		if reloadable[field.Name] {
+			reloadableChanges = append(reloadableChanges, field.Name)
+		} else {
+			restartChanges = append(restartChanges, field.Name)
+		}
 	}
+	return reloadableChanges, restartChanges
+}
+
+// WithReloadable returns a copy of the configuration with the settings of other that can change at runtime
+// Settings that need a restart keep their current value, so a later Diff still reports them
+func (c *Config) WithReloadable(other *Config) *Config {
+	merged := *c
+	a, b := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if reloadable[a.Type().Field(i).Name] {
+			a.Field(i).Set(b.Field(i))
+		}
+	}
+	return &merged
+}
+
+// readConfigFile reads a flat config file whose keys are the lower case setting keys
+// Files ending in .toml are read as TOML, any other file as YAML
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	raw := map[string]any{}
+	unmarshal := yaml.Unmarshal
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		unmarshal = toml.Unmarshal
+	}
+	if err := unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	var errs []error
+	for name, value := range raw {
+		key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		known := false
+		for _, s := range settings {
+			if s.key == key {
+				known = true
+				break
+			}
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, name))
+			continue
+		}
+		values[key] = fmt.Sprint(value)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return values, nil
+}
 
-	return cfg, nil
+// validate checks the values that parsed correctly and returns every problem it finds
+func (c *Config) validate() []error {
+	var errs []error
+
+	if c.PostgresConnectionString == "" {
+		errs = append(errs, fmt.Errorf("POSTGRES_CONNECTION_STRING not set"))
+	}
+
+	if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: must be a number between 1 and 65535, got %q", c.ServerPort))
+	}
+
+	for _, u := range []struct{ key, value string }{
+		{"OPENWEATHERMAP_BASE_URL", c.OpenWeatherMapBaseURL},
+		{"RESEND_BASE_URL", c.ResendBaseURL},
+	} {
+		if parsed, err := url.Parse(u.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: must be an absolute URL, got %q", u.key, u.value))
+		}
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"WEATHER_REQUEST_TIMEOUT", c.WeatherRequestTimeout},
+		{"DB_CONN_MAX_LIFETIME", c.DBConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", c.DBConnMaxIdleTime},
+		{"DB_HEALTH_CHECK_PERIOD", c.DBHealthCheckPeriod},
+		{"SERVER_READ_TIMEOUT", c.ServerReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", c.ServerWriteTimeout},
+		{"SERVER_IDLE_TIMEOUT", c.ServerIdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"EMAIL_REQUEST_TIMEOUT", c.EmailRequestTimeout},
+		{"DEFERRED_FLUSH_INTERVAL", c.DeferredFlushInterval},
+		{"PURGE_INTERVAL", c.PurgeInterval},
+		{"PURGE_RETENTION", c.PurgeRetention},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive, got %s", d.key, d.value))
+		}
+	}
+
+	if c.DBMaxOpenConns < 1 {
+		errs = append(errs, fmt.Errorf("DB_MAX_OPEN_CONNS: must be at least 1, got %d", c.DBMaxOpenConns))
+	}
+	if c.DBMaxIdleConns < 0 || c.DBMaxIdleConns > c.DBMaxOpenConns {
+		errs = append(errs, fmt.Errorf("DB_MAX_IDLE_CONNS: must be between 0 and DB_MAX_OPEN_CONNS, got %d", c.DBMaxIdleConns))
+	}
+	if c.DBMinConns < 0 || c.DBMinConns > c.DBMaxOpenConns {
+		errs = append(errs, fmt.Errorf("DB_MIN_CONNS: must be between 0 and DB_MAX_OPEN_CONNS, got %d", c.DBMinConns))
+	}
+	if c.NotificationBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("NOTIFICATION_BATCH_SIZE: must be at least 1, got %d", c.NotificationBatchSize))
+	}
+	if c.DBStatementCacheCapacity < 0 {
+		errs = append(errs, fmt.Errorf("DB_STATEMENT_CACHE_CAPACITY: must not be negative, got %d", c.DBStatementCacheCapacity))
+	}
+
+	switch strings.ToLower(c.DBDriver) {
+	case "stdlib", "pgxpool":
+	default:
+		errs = append(errs, fmt.Errorf("DB_DRIVER: must be stdlib or pgxpool, got %q", c.DBDriver))
+	}
+	if c.UsesSQLite() && strings.EqualFold(c.DBDriver, "pgxpool") {
+		errs = append(errs, fmt.Errorf("DB_DRIVER: pgxpool requires a PostgreSQL connection string"))
+	}
+
+	switch strings.ToLower(c.DBQueryExecMode) {
+	case "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol":
+	default:
+		errs = append(errs, fmt.Errorf("DB_QUERY_EXEC_MODE: must be cache_statement, cache_describe, describe_exec, exec or simple_protocol, got %q", c.DBQueryExecMode))
+	}
+
+	if !strings.Contains(c.EmailFrom, "@") {
+		errs = append(errs, fmt.Errorf("EMAIL_FROM: must be an email address, got %q", c.EmailFrom))
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	switch strings.ToLower(c.LogFormat) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: must be text or json, got %q", c.LogFormat))
+	}
+
+	switch strings.ToLower(c.TracingExporter) {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER: must be none, stdout or otlp, got %q", c.TracingExporter))
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO: must be between 0 and 1, got %v", c.TracingSampleRatio))
+	}
+
+	if _, err := c.NotificationClock(); err != nil {
+		errs = append(errs, fmt.Errorf("NOTIFICATION_TIME: %w", err))
+	}
+
+	if _, err := ParseRateLimit(c.RateLimitDefault); err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_DEFAULT: %w", err))
+	}
+	if _, err := c.RouteRateLimits(); err != nil {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_ROUTES: %w", err))
+	}
+
+	return errs
+}
+
+// SQLiteScheme is the scheme of connection strings that select the SQLite backend
+const SQLiteScheme = "sqlite://"
+
+// UsesSQLite reports whether the connection string points to an SQLite database file instead of PostgreSQL
+func (c *Config) UsesSQLite() bool {
+	return strings.HasPrefix(strings.ToLower(c.PostgresConnectionString), SQLiteScheme)
+}
+
+// NotificationClock returns the hour and minute of the daily notification run
+func (c *Config) NotificationClock() (time.Time, error) {
+	t, err := time.Parse("15:04", c.NotificationTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a time of day in HH:MM format, got %q", c.NotificationTime)
+	}
+	return t, nil
+}
+
+// RateLimit allows Requests requests per Period, and bursts of up to Requests requests
+// The zero value means no limit
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Unlimited reports whether the limit allows any number of requests
+func (l RateLimit) Unlimited() bool {
+	return l.Requests == 0
+}
+
+// ParseRateLimit parses a limit such as "10/m", "100/h" or "5/30s"; "off" means no limit
+func ParseRateLimit(value string) (RateLimit, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "off") {
+		return RateLimit{}, nil
+	}
+
+	count, per, ok := strings.Cut(value, "/")
+	requests, err := strconv.Atoi(count)
+	if !ok || err != nil || requests < 1 {
+		return RateLimit{}, fmt.Errorf("must be off or a number of requests per period (e.g. 10/m), got %q", value)
+	}
+
+	var period time.Duration
+	switch per {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(per)
+		if err != nil || period <= 0 {
+			return RateLimit{}, fmt.Errorf("period must be s, m, h or a positive duration, got %q", per)
+		}
+	}
+	return RateLimit{Requests: requests, Period: period}, nil
+}
+
+// RouteRateLimits returns the limits from RATE_LIMIT_ROUTES keyed by "METHOD /route/template"
+func (c *Config) RouteRateLimits() (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(c.RateLimitRoutes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(entry, "=")
+		method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+		if !ok || !hasPath || !strings.HasPrefix(strings.TrimSpace(path), "/") {
+			return nil, fmt.Errorf("entries must look like \"POST /user=5/m\", got %q", entry)
+		}
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", route, err)
+		}
+		limits[strings.ToUpper(method)+" "+strings.TrimSpace(path)] = limit
+	}
+	return limits, nil
+}
+
+// APIKeyList returns the API keys from API_KEYS
+func (c *Config) APIKeyList() []string {
+	keys := []string{}
+	for _, key := range strings.Split(c.APIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
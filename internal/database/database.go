@@ -2,72 +2,335 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 
+	"maxcool.com/weatherapp/internal/config"
 	"maxcool.com/weatherapp/internal/models"
 )
 
 type IDB interface {
 	// User methods
-	GetUserByID(userID int) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	CreateUser(user *models.User) (int, error)
-	UpdateUser(user *models.User) error
-	DeleteUser(userID int) error
+	GetUserByID(ctx context.Context, userID int) (*models.User, error)
+	// GetUserIncludingDeleted is GetUserByID that also returns soft-deleted users, e.g. for exporting their data
+	GetUserIncludingDeleted(ctx context.Context, userID int) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUsers(ctx context.Context) ([]models.User, error)
+	CreateUser(ctx context.Context, user *models.User) (int, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, userID int) error
 
 	// Subscription methods
-	CreateSubscription(sub *models.Subscription) (int, error)
-	GetSubscriptionByID(subID int) (*models.Subscription, error)
-	UpdateSubscription(sub *models.Subscription) error
-	DeleteSubscription(subID int) error
-	GetSubscriptions() ([]models.Subscription, error)
-	GetSubscriptionsByUserID(userID int) ([]models.Subscription, error)
+	CreateSubscription(ctx context.Context, sub *models.Subscription) (int, error)
+	GetSubscriptionByID(ctx context.Context, subID int) (*models.Subscription, error)
+	UpdateSubscription(ctx context.Context, sub *models.Subscription) error
+	DeleteSubscription(ctx context.Context, subID int) error
+	GetSubscriptions(ctx context.Context) ([]models.Subscription, error)
+	GetSubscriptionsByUserID(ctx context.Context, userID int) ([]models.Subscription, error)
+	ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) ([]models.Subscription, error)
+	ExpireSubscriptions(ctx context.Context, now time.Time) ([]int, error)
+
+	// PurgeDeleted permanently removes users and subscriptions soft-deleted at or before the given time
+	PurgeDeleted(ctx context.Context, before time.Time) (userIDs, subscriptionIDs []int, err error)
+
+	// EraseUser permanently removes a user, deleted or not, together with its subscriptions and notifications,
+	// and clears the states and API client actors the audit log recorded for the user and its subscriptions; it reports whether the user existed
+	EraseUser(ctx context.Context, userID int) (bool, error)
 
 	// Notification methods
-	CreateNotification(notification *models.Notification) (int, error)
+	CreateNotification(ctx context.Context, notification *models.Notification) (int, error)
+	CreateNotifications(ctx context.Context, notifications []models.Notification) error
+	GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) ([]models.Notification, error)
+	GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) ([]models.Notification, error)
+
+	// Deferred notification methods
+	CreateDeferredNotification(ctx context.Context, deferred *models.DeferredNotification) (int, error)
+	GetDueDeferredNotifications(ctx context.Context, now time.Time) ([]models.DeferredNotification, error)
+	DeleteDeferredNotification(ctx context.Context, id int) error
+
+	// Audit log methods
+	CreateAuditEvent(ctx context.Context, event *models.AuditEvent) (int, error)
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+
+	// WithTx runs fn in a transaction; the IDB passed to fn runs its statements in that transaction
+	// The transaction is committed if fn returns nil and rolled back otherwise
+	WithTx(ctx context.Context, fn func(tx IDB) error) error
 
 	Close()
 }
 
+// querier runs statements; sqlQuerier runs them through database/sql, pgxQuerier natively on the pgx pool
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) row
+}
+
+// rows is the result set of a query; it is implemented by *sql.Rows and pgxRows
+type rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close() error
+}
+
+// row is the result of a query for a single row; it is implemented by *sql.Row and pgxRow
+type row interface {
+	Scan(dest ...any) error
+}
+
+// sqlConn runs statements through database/sql; it is implemented by both *sql.DB and *sql.Tx
+type sqlConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqlQuerier runs the statements of the repository through database/sql
+type sqlQuerier struct {
+	conn sqlConn
+}
+
+func (q sqlQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return q.conn.ExecContext(ctx, query, args...)
+}
+
+func (q sqlQuerier) QueryContext(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := q.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (q sqlQuerier) QueryRowContext(ctx context.Context, query string, args ...any) row {
+	return q.conn.QueryRowContext(ctx, query, args...)
+}
+
 // DB struct holds the database connection pool
 type DB struct {
-	SQL *sql.DB
+	SQL    *sql.DB       // with DB_DRIVER=pgxpool it sits on top of pool and only serves migrations and metrics
+	pool   *pgxpool.Pool // native pool, only with DB_DRIVER=pgxpool; the IDB methods then run on it
+	tx     *sql.Tx       // set while the DB is bound to a transaction by WithTx
+	pgxTx  pgx.Tx        // set instead of tx while the DB is bound to a transaction on the native pool
+	sqlite bool          // the database is an SQLite file, opened with a sqlite:// connection string
+}
+
+// q returns the transaction the DB is bound to, or the connection pool
+func (d *DB) q() querier {
+	if d.pool != nil {
+		return pgxQuerier{d.native()}
+	}
+	var conn sqlConn = d.SQL
+	if d.tx != nil {
+		conn = d.tx
+	}
+	if d.sqlite {
+		return utcQuerier{sqlQuerier{conn}}
+	}
+	return sqlQuerier{conn}
+}
+
+// native returns the pgx transaction the DB is bound to, or the pgx pool
+// It must only be called with DB_DRIVER=pgxpool
+func (d *DB) native() pgxConn {
+	if d.pgxTx != nil {
+		return d.pgxTx
+	}
+	return d.pool
+}
+
+// inTx reports whether the DB is bound to a transaction
+func (d *DB) inTx() bool {
+	return d.tx != nil || d.pgxTx != nil
+}
+
+// WithTx runs fn in a transaction and commits it if fn returns nil, otherwise rolls it back
+// Calling WithTx on the IDB passed to fn runs the nested function in the same transaction
+func (d *DB) WithTx(ctx context.Context, fn func(tx IDB) error) (err error) {
+	if d.inTx() {
+		return fn(d)
+	}
+	if d.pool != nil {
+		return d.withPgxTx(ctx, fn)
+	}
+
+	tx, err := d.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&DB{SQL: d.SQL, pool: d.pool, tx: tx, sqlite: d.sqlite}); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			slog.ErrorContext(ctx, "Failed to roll back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// withPgxTx is WithTx on the native pool
+// The rollback does not use ctx, so a cancelled request still ends its transaction cleanly
+func (d *DB) withPgxTx(ctx context.Context, fn func(tx IDB) error) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+	}()
+
+	if err := fn(&DB{SQL: d.SQL, pool: d.pool, pgxTx: tx}); err != nil {
+		if rollbackErr := tx.Rollback(context.WithoutCancel(ctx)); rollbackErr != nil {
+			slog.ErrorContext(ctx, "Failed to roll back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
 // NewDB initializes and returns a new DB instance with the connection pool
-func NewDB(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("pgx", dataSourceName)
+// The pool is sized according to the configuration. With DB_DRIVER=pgxpool the IDB methods run natively
+// on a pgx pool, otherwise through database/sql; both return the same results
+// A sqlite:// connection string opens an SQLite database file instead
+func NewDB(cfg *config.Config) (*DB, error) {
+	if cfg.UsesSQLite() {
+		return newSQLiteDB(cfg)
+	}
+
+	connConfig, err := pgx.ParseConfig(cfg.PostgresConnectionString)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open database connection: %w", err)
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	connConfig.DefaultQueryExecMode = queryExecMode(cfg.DBQueryExecMode)
+	connConfig.StatementCacheCapacity = cfg.DBStatementCacheCapacity
+	connConfig.DescriptionCacheCapacity = cfg.DBStatementCacheCapacity
+
+	var pool *pgxpool.Pool
+	var connector driver.Connector
+	if strings.EqualFold(cfg.DBDriver, "pgxpool") {
+		poolConfig, err := pgxpool.ParseConfig(cfg.PostgresConnectionString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connection string: %w", err)
+		}
+		// Native queries are not seen by otelsql, so pgx traces them itself
+		connConfig.Tracer = queryTracer{}
+		poolConfig.ConnConfig = connConfig
+		poolConfig.MaxConns = int32(cfg.DBMaxOpenConns)
+		poolConfig.MinConns = int32(cfg.DBMinConns)
+		poolConfig.MaxConnLifetime = cfg.DBConnMaxLifetime
+		poolConfig.MaxConnIdleTime = cfg.DBConnMaxIdleTime
+		poolConfig.HealthCheckPeriod = cfg.DBHealthCheckPeriod
+
+		pool, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create connection pool: %w", err)
+		}
+		connector = stdlib.GetPoolConnector(pool)
+	} else {
+		connector = stdlib.GetConnector(*connConfig)
+	}
+
+	// Every query gets a span carrying the SQL statement, as a child of the caller's span;
+	// on the pgx pool queryTracer already creates it
+	var db *sql.DB
+	if pool != nil {
+		db = sql.OpenDB(connector)
+	} else {
+		db = otelsql.OpenDB(connector,
+			otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+			otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+		)
 	}
 
 	// Ping the database to verify the connection is established
 	err = db.Ping()
 	if err != nil {
 		db.Close()
+		if pool != nil {
+			pool.Close()
+		}
 		return nil, fmt.Errorf("database ping failed: %w", err)
 	}
 
 	// Configure connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	// On top of pgxpool database/sql must not keep idle connections, they belong to the pgx pool
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+	if pool != nil {
+		db.SetMaxIdleConns(0)
+	}
+
+	return &DB{SQL: db, pool: pool}, nil
+}
+
+// queryExecMode converts a DB_QUERY_EXEC_MODE value to the pgx mode, defaulting to statement caching
+func queryExecMode(mode string) pgx.QueryExecMode {
+	switch strings.ToLower(mode) {
+	case "cache_describe":
+		return pgx.QueryExecModeCacheDescribe
+	case "describe_exec":
+		return pgx.QueryExecModeDescribeExec
+	case "exec":
+		return pgx.QueryExecModeExec
+	case "simple_protocol":
+		return pgx.QueryExecModeSimpleProtocol
+	default:
+		return pgx.QueryExecModeCacheStatement
+	}
+}
 
-	return &DB{SQL: db}, nil
+// Ping verifies that the database is reachable
+func (d *DB) Ping(ctx context.Context) error {
+	if d.pool != nil {
+		return d.pool.Ping(ctx)
+	}
+	return d.SQL.PingContext(ctx)
 }
 
 // Close closes the database connection pool
+// It does nothing on a DB bound to a transaction, the pool belongs to the DB WithTx was called on
 func (d *DB) Close() {
+	if d.inTx() {
+		return
+	}
 	if d.SQL != nil {
 		d.SQL.Close()
 	}
+	if d.pool != nil {
+		d.pool.Close()
+	}
 }
 
 // EnsureDatabaseExists connects to a default database (like 'postgres')
@@ -86,12 +349,12 @@ func EnsureDatabaseExists(dbName string, connectionString string) error {
 
 	// Reconstruct the connection string for the default database
 	connectURL := u.String()
-	log.Printf("Attempting to connect to default DB with the `postgres` account")
+	slog.Info("Attempting to connect to default DB with the `postgres` account")
 
 	// Connect to the default database
 	db, err := sql.Open("pgx", connectURL)
 	if err != nil {
-		return fmt.Errorf("unable to connect to default database (%s): %w", connectURL, err)
+		return fmt.Errorf("unable to connect to default database (%s): %w", u.Redacted(), err)
 	}
 	defer db.Close()
 
@@ -99,21 +362,21 @@ func EnsureDatabaseExists(dbName string, connectionString string) error {
 	if err = db.Ping(); err != nil {
 		return fmt.Errorf("ping to default database failed: %w", err)
 	}
-	log.Println("Successfully connected to default database.")
+	slog.Info("Successfully connected to default database.")
 
 	createDBSQL := fmt.Sprintf("CREATE DATABASE %s", dbName)
 
-	log.Printf("Executing: %s", createDBSQL)
+	slog.Info("Executing statement", "sql", createDBSQL)
 	_, err = db.Exec(createDBSQL)
 	if err != nil {
 		if strings.Contains(err.Error(), "already exists") {
-			log.Printf("Database '%s' already exists, skipping creation.", dbName)
+			slog.Info("Database already exists, skipping creation.", "database", dbName)
 			return nil // Database already exists, no error
 		}
 		return fmt.Errorf("failed to execute CREATE DATABASE: %w", err)
 	}
 
-	log.Printf("Database '%s' ensured to exist (created or already present).", dbName)
+	slog.Info("Database ensured to exist (created or already present).", "database", dbName)
 
 	return nil // Success
 }
@@ -121,29 +384,31 @@ func EnsureDatabaseExists(dbName string, connectionString string) error {
 // --- Database Operation Methods (repository layer) ---
 
 // CreateUser inserts a new user into the database
-// Returns the ID of the newly created user
-func (d *DB) CreateUser(user *models.User) (int, error) {
+// Returns the ID of the newly created user and sets its ID and timestamps
+func (d *DB) CreateUser(ctx context.Context, user *models.User) (int, error) {
+	now := timestamp()
 	var userID int
-	err := d.SQL.QueryRow(
-		"INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id",
-		user.Name, user.Email,
+	err := d.q().QueryRowContext(ctx,
+		"INSERT INTO users (name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8) RETURNING id",
+		user.Name, user.Email, user.Timezone, user.QuietHoursStart, user.QuietHoursEnd, user.QuietHoursPolicy, user.DigestMode, now,
 	).Scan(&userID)
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to create user: %w", err)
 	}
 	user.Id = userID
+	user.CreatedAt, user.UpdatedAt = now, now
 	return userID, nil
 }
 
 // GetUserByEmail retrieves a user by their email address
-// Returns the user if found, or nil if not found
-func (d *DB) GetUserByEmail(email string) (*models.User, error) {
+// Returns the user if found, or nil if not found or deleted
+func (d *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{} // Create an empty user struct
-	err := d.SQL.QueryRow(
-		"SELECT id, name, email FROM users WHERE email = $1",
+	err := d.q().QueryRowContext(ctx,
+		"SELECT id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode, created_at, updated_at FROM users WHERE email = $1 AND deleted_at IS NULL",
 		email,
-	).Scan(&user.Id, &user.Name, &user.Email)
+	).Scan(&user.Id, &user.Name, &user.Email, &user.Timezone, &user.QuietHoursStart, &user.QuietHoursEnd, &user.QuietHoursPolicy, &user.DigestMode, utcTime{&user.CreatedAt}, utcTime{&user.UpdatedAt})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -155,25 +420,50 @@ func (d *DB) GetUserByEmail(email string) (*models.User, error) {
 }
 
 // CreateSubscription inserts a new subscription into the database
-// Returns the ID of the newly created subscription
-func (d *DB) CreateSubscription(sub *models.Subscription) (int, error) {
+// Returns the ID of the newly created subscription and sets its ID and timestamps
+// The user must exist and not be deleted, otherwise ErrForeignKey is returned
+// The user's email is copied from the users table, whatever sub.UserEmail holds
+func (d *DB) CreateSubscription(ctx context.Context, sub *models.Subscription) (int, error) {
+	now := timestamp()
 	var subID int
-	err := d.SQL.QueryRow(
-		"INSERT INTO subscriptions (user_id, city, condition, user_email) VALUES ($1, $2, $3, $4) RETURNING id",
-		sub.UserId, sub.City, sub.Condition, sub.UserEmail,
-	).Scan(&subID)
+	var email string
+	err := d.WithTx(ctx, func(tx IDB) error {
+		q := tx.(*DB).q()
+		err := q.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL", sub.UserId).Scan(&email)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user %d: %w", sub.UserId, ErrForeignKey)
+		}
+		if err != nil {
+			return err
+		}
+		return q.QueryRowContext(ctx,
+			"INSERT INTO subscriptions (user_id, city, condition, user_email, active, paused_until, expires_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8) RETURNING id",
+			sub.UserId, sub.City, sub.Condition, email, sub.Active, sub.PausedUntil, sub.ExpiresAt, now,
+		).Scan(&subID)
+	})
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to create subscription: %w", err)
 	}
 	sub.Id = subID
+	sub.UserEmail = email
+	sub.CreatedAt, sub.UpdatedAt = now, now
 	return subID, nil
 }
 
-// GetSubscriptionsByUserID retrieves all subscriptions for a given user ID
+// subscriptionColumns selects subscriptions with the current email of their user, scanned by subscriptionFields
+// The email is read through the join rather than from subscriptions.user_email, which only the foreign key keeps in sync
+const subscriptionColumns = "SELECT s.id, s.user_id, s.city, s.condition, u.email, s.active, s.paused_until, s.expires_at, s.created_at, s.updated_at FROM subscriptions s JOIN users u ON u.id = s.user_id"
+
+// subscriptionFields returns the scan destinations for a row selected by subscriptionColumns
+func subscriptionFields(sub *models.Subscription) []any {
+	return []any{&sub.Id, &sub.UserId, &sub.City, &sub.Condition, &sub.UserEmail, &sub.Active, &sub.PausedUntil, &sub.ExpiresAt, utcTime{&sub.CreatedAt}, utcTime{&sub.UpdatedAt}}
+}
+
+// GetSubscriptionsByUserID retrieves all subscriptions for a given user ID that are not deleted
 // Returns a slice of subscriptions or an error if the query fails
-func (d *DB) GetSubscriptionsByUserID(userID int) ([]models.Subscription, error) {
-	rows, err := d.SQL.Query("SELECT id, user_id, city, condition FROM subscriptions WHERE user_id = $1", userID)
+func (d *DB) GetSubscriptionsByUserID(ctx context.Context, userID int) ([]models.Subscription, error) {
+	rows, err := d.q().QueryContext(ctx, subscriptionColumns+" WHERE s.user_id = $1 AND s.deleted_at IS NULL", userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscriptions for user %d: %w", userID, err)
 	}
@@ -184,9 +474,9 @@ func (d *DB) GetSubscriptionsByUserID(userID int) ([]models.Subscription, error)
 	for rows.Next() {
 		var sub models.Subscription
 
-		if err := rows.Scan(&sub.Id, &sub.UserId, &sub.City, &sub.Condition); err != nil {
-			log.Printf("Error scanning subscription row for user %d: %v", userID, err) // Log the error but try to continue
-			continue                                                                   // Skip this row
+		if err := rows.Scan(subscriptionFields(&sub)...); err != nil {
+			slog.ErrorContext(ctx, "Error scanning subscription row", "user_id", userID, "error", err) // Log the error but try to continue
+			continue                                                                                   // Skip this row
 		}
 		subscriptions = append(subscriptions, sub)
 	}
@@ -200,13 +490,24 @@ func (d *DB) GetSubscriptionsByUserID(userID int) ([]models.Subscription, error)
 }
 
 // GetUserByID retrieves a user by their ID
-// Returns the user if found, or nil if not found
-func (d *DB) GetUserByID(userID int) (*models.User, error) {
+// Returns the user if found, or nil if not found or deleted
+func (d *DB) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	return d.getUser(ctx, userID, false)
+}
+
+// GetUserIncludingDeleted retrieves a user by their ID, even one that is soft-deleted
+// Returns the user if found, or nil if not found or already purged
+func (d *DB) GetUserIncludingDeleted(ctx context.Context, userID int) (*models.User, error) {
+	return d.getUser(ctx, userID, true)
+}
+
+func (d *DB) getUser(ctx context.Context, userID int, includeDeleted bool) (*models.User, error) {
+	query := "SELECT id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode, created_at, updated_at FROM users WHERE id = $1"
+	if !includeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
 	user := &models.User{}
-	err := d.SQL.QueryRow(
-		"SELECT id, name, email FROM users WHERE id = $1",
-		userID,
-	).Scan(&user.Id, &user.Name, &user.Email)
+	err := d.q().QueryRowContext(ctx, query, userID).Scan(&user.Id, &user.Name, &user.Email, &user.Timezone, &user.QuietHoursStart, &user.QuietHoursEnd, &user.QuietHoursPolicy, &user.DigestMode, utcTime{&user.CreatedAt}, utcTime{&user.UpdatedAt})
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -217,27 +518,68 @@ func (d *DB) GetUserByID(userID int) (*models.User, error) {
 	return user, nil
 }
 
-// UpdateUser updates an existing user in the database
+// GetUsers retrieves all users that are not deleted, ordered by ID
+// Returns a slice of users or an error if the query fails
+func (d *DB) GetUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := d.q().QueryContext(ctx, "SELECT id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode, created_at, updated_at FROM users WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+
+	for rows.Next() {
+		var user models.User
+
+		if err := rows.Scan(&user.Id, &user.Name, &user.Email, &user.Timezone, &user.QuietHoursStart, &user.QuietHoursEnd, &user.QuietHoursPolicy, &user.DigestMode, utcTime{&user.CreatedAt}, utcTime{&user.UpdatedAt}); err != nil {
+			slog.ErrorContext(ctx, "Error scanning user row", "error", err) // Log the error but try to continue
+			continue                                                        // Skip this row
+		}
+		users = append(users, user)
+	}
+
+	// Check for errors encountered during row iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during users iteration: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateUser updates an existing user in the database and sets its update time
+// Deleted users are not updated
 // Returns an error if the update fails
-func (d *DB) UpdateUser(user *models.User) error {
-	_, err := d.SQL.Exec(
-		"UPDATE users SET name = $1, email = $2 WHERE id = $3",
-		user.Name, user.Email, user.Id,
+func (d *DB) UpdateUser(ctx context.Context, user *models.User) error {
+	now := timestamp()
+	_, err := d.q().ExecContext(ctx,
+		"UPDATE users SET name = $1, email = $2, timezone = $3, quiet_hours_start = $4, quiet_hours_end = $5, quiet_hours_policy = $6, digest_mode = $7, updated_at = $8 WHERE id = $9 AND deleted_at IS NULL",
+		user.Name, user.Email, user.Timezone, user.QuietHoursStart, user.QuietHoursEnd, user.QuietHoursPolicy, user.DigestMode, now, user.Id,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	user.UpdatedAt = now
 	return nil
 }
 
-// DeleteUser deletes a user from the database
+// DeleteUser soft-deletes a user together with its subscriptions
+// Their notification history is kept until PurgeDeleted removes them, pending deferred notifications are dropped
 // Returns an error if the deletion fails
-func (d *DB) DeleteUser(userID int) error {
-	_, err := d.SQL.Exec(
-		"DELETE FROM users WHERE id = $1",
-		userID,
-	)
+func (d *DB) DeleteUser(ctx context.Context, userID int) error {
+	now := timestamp()
+	err := d.WithTx(ctx, func(tx IDB) error {
+		q := tx.(*DB).q()
+		if _, err := q.ExecContext(ctx, "UPDATE users SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL", now, userID); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE subscriptions SET deleted_at = $1 WHERE user_id = $2 AND deleted_at IS NULL", now, userID); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, "DELETE FROM deferred_notifications WHERE user_id = $1", userID)
+		return err
+	})
 
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
@@ -246,14 +588,14 @@ func (d *DB) DeleteUser(userID int) error {
 }
 
 // GetSubscriptionByID retrieves a subscription by its ID
-// Returns the subscription if found, or nil if not found
+// Returns the subscription if found, or nil if not found or deleted
 // Returns an error if the query fails
-func (d *DB) GetSubscriptionByID(subID int) (*models.Subscription, error) {
+func (d *DB) GetSubscriptionByID(ctx context.Context, subID int) (*models.Subscription, error) {
 	sub := &models.Subscription{}
-	err := d.SQL.QueryRow(
-		"SELECT id, user_id, city, condition FROM subscriptions WHERE id = $1",
+	err := d.q().QueryRowContext(ctx,
+		subscriptionColumns+" WHERE s.id = $1 AND s.deleted_at IS NULL",
 		subID,
-	).Scan(&sub.Id, &sub.UserId, &sub.City, &sub.Condition)
+	).Scan(subscriptionFields(sub)...)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -264,27 +606,37 @@ func (d *DB) GetSubscriptionByID(subID int) (*models.Subscription, error) {
 	return sub, nil
 }
 
-// UpdateSubscription updates an existing subscription in the database
+// UpdateSubscription updates an existing subscription in the database and sets its update time
+// The user's email is taken from the users table, whatever sub.UserEmail holds
+// Deleted subscriptions are not updated
 // Returns an error if the update fails
-func (d *DB) UpdateSubscription(sub *models.Subscription) error {
-	_, err := d.SQL.Exec(
-		"UPDATE subscriptions SET user_id = $1, city = $2, condition = $3 WHERE id = $4",
-		sub.UserId, sub.City, sub.Condition, sub.Id,
+func (d *DB) UpdateSubscription(ctx context.Context, sub *models.Subscription) error {
+	now := timestamp()
+	_, err := d.q().ExecContext(ctx,
+		"UPDATE subscriptions SET user_id = $1, user_email = (SELECT email FROM users WHERE id = $1), city = $2, condition = $3, active = $4, paused_until = $5, expires_at = $6, updated_at = $7 WHERE id = $8 AND deleted_at IS NULL",
+		sub.UserId, sub.City, sub.Condition, sub.Active, sub.PausedUntil, sub.ExpiresAt, now, sub.Id,
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to update subscription: %w", err)
 	}
+	sub.UpdatedAt = now
 	return nil
 }
 
-// DeleteSubscription deletes a subscription from the database
+// DeleteSubscription soft-deletes a subscription
+// Its notification history is kept until PurgeDeleted removes it, pending deferred notifications are dropped
 // Returns an error if the deletion fails
-func (d *DB) DeleteSubscription(subID int) error {
-	_, err := d.SQL.Exec(
-		"DELETE FROM subscriptions WHERE id = $1",
-		subID,
-	)
+func (d *DB) DeleteSubscription(ctx context.Context, subID int) error {
+	now := timestamp()
+	err := d.WithTx(ctx, func(tx IDB) error {
+		q := tx.(*DB).q()
+		if _, err := q.ExecContext(ctx, "UPDATE subscriptions SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL", now, subID); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, "DELETE FROM deferred_notifications WHERE subscription_id = $1", subID)
+		return err
+	})
 
 	if err != nil {
 		return fmt.Errorf("failed to delete subscription: %w", err)
@@ -292,10 +644,76 @@ func (d *DB) DeleteSubscription(subID int) error {
 	return nil
 }
 
-// GetSubscriptions retrieves all subscriptions from the database
+// PurgeDeleted permanently removes users and subscriptions that were soft-deleted at or before the given time,
+// together with their notification history
+// Returns the IDs of the users and subscriptions removed, in ascending order
+func (d *DB) PurgeDeleted(ctx context.Context, before time.Time) (userIDs, subscriptionIDs []int, err error) {
+	err = d.WithTx(ctx, func(tx IDB) error {
+		q := tx.(*DB).q()
+		if subscriptionIDs, err = queryIDs(ctx, q, "DELETE FROM subscriptions WHERE deleted_at <= $1 RETURNING id", before); err != nil {
+			return err
+		}
+		userIDs, err = queryIDs(ctx, q, "DELETE FROM users WHERE deleted_at <= $1 RETURNING id", before)
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to purge deleted rows: %w", err)
+	}
+	return userIDs, subscriptionIDs, nil
+}
+
+// EraseUser permanently removes a user, deleted or not, and the rows referencing it
+// The audit log keeps its events, but the states recorded for the user and its subscriptions and the API clients that made them are cleared;
+// subscription events are matched by the user_id in their states, so events of already purged subscriptions are cleared too
+// Returns whether the user existed
+func (d *DB) EraseUser(ctx context.Context, userID int) (bool, error) {
+	userIDOf := func(column string) string {
+		if d.sqlite {
+			return "json_extract(" + column + ", '$.user_id')"
+		}
+		return "(" + column + "->>'user_id')::int"
+	}
+
+	var erased bool
+	err := d.WithTx(ctx, func(tx IDB) error {
+		q := tx.(*DB).q()
+		// API clients are identified by their IP address or API key, so they are anonymized too;
+		// the admin, CLI users and the system stay on record
+		_, err := q.ExecContext(ctx,
+			"UPDATE audit_log SET before_state = NULL, after_state = NULL, "+
+				"actor = CASE WHEN actor LIKE 'api:%' OR actor LIKE 'anonymous:%' THEN '"+models.AuditActorErased+"' ELSE actor END "+
+				"WHERE (entity_type = 'user' AND entity_id = $1) OR "+
+				"(entity_type = 'subscription' AND ("+userIDOf("before_state")+" = $1 OR "+userIDOf("after_state")+" = $1))",
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Subscriptions, notifications and deferred notifications go with the user through ON DELETE CASCADE
+		result, err := q.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		erased = rows > 0
+		return nil
+	})
+
+	if err != nil {
+		return false, fmt.Errorf("failed to erase user: %w", err)
+	}
+	return erased, nil
+}
+
+// GetSubscriptions retrieves all subscriptions that are not deleted from the database
 // Returns a slice of subscriptions or an error if the query fails
-func (d *DB) GetSubscriptions() ([]models.Subscription, error) {
-	rows, err := d.SQL.Query("SELECT id, user_id, city, condition, user_email condition FROM subscriptions")
+func (d *DB) GetSubscriptions(ctx context.Context) ([]models.Subscription, error) {
+	rows, err := d.q().QueryContext(ctx, subscriptionColumns+" WHERE s.deleted_at IS NULL")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
 	}
@@ -306,9 +724,9 @@ func (d *DB) GetSubscriptions() ([]models.Subscription, error) {
 	for rows.Next() {
 		var sub models.Subscription
 
-		if err := rows.Scan(&sub.Id, &sub.UserId, &sub.City, &sub.Condition, &sub.UserEmail); err != nil {
-			log.Printf("Error scanning subscription row: %v", err) // Log the error but try to continue
-			continue                                               // Skip this row
+		if err := rows.Scan(subscriptionFields(&sub)...); err != nil {
+			slog.ErrorContext(ctx, "Error scanning subscription row", "error", err) // Log the error but try to continue
+			continue                                                                // Skip this row
 		}
 		subscriptions = append(subscriptions, sub)
 	}
@@ -321,13 +739,79 @@ func (d *DB) GetSubscriptions() ([]models.Subscription, error) {
 	return subscriptions, nil
 }
 
+// ListSubscriptions retrieves the subscriptions matching the filter that are not deleted, in ID order
+// It returns at most filter.Limit subscriptions, or all of them if the limit is not positive
+// SQLite only folds the case of ASCII letters when matching the city
+func (d *DB) ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) ([]models.Subscription, error) {
+	query := subscriptionColumns + " WHERE s.id > $1"
+	args := []any{filter.AfterId}
+	if !filter.IncludeDeleted {
+		query += " AND s.deleted_at IS NULL"
+	}
+	if filter.UserId != 0 {
+		args = append(args, filter.UserId)
+		query += fmt.Sprintf(" AND s.user_id = $%d", len(args))
+	}
+	if filter.City != "" {
+		args = append(args, filter.City)
+		query += fmt.Sprintf(" AND LOWER(s.city) = LOWER($%d)", len(args))
+	}
+	if filter.Active != nil {
+		args = append(args, *filter.Active)
+		query += fmt.Sprintf(" AND s.active = $%d", len(args))
+	}
+	query += " ORDER BY s.id"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := d.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var sub models.Subscription
+		// A skipped row would end a page early and could end the keyset walk, so scan errors are not ignored here
+		if err := rows.Scan(subscriptionFields(&sub)...); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription: %w", err)
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during subscriptions iteration: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// ExpireSubscriptions deactivates all active subscriptions whose expiry time is not after now
+// Returns the IDs of the subscriptions that were deactivated, in ascending order
+func (d *DB) ExpireSubscriptions(ctx context.Context, now time.Time) ([]int, error) {
+	expired, err := queryIDs(ctx, d.q(),
+		"UPDATE subscriptions SET active = FALSE, updated_at = $1 WHERE active AND expires_at IS NOT NULL AND expires_at <= $1 AND deleted_at IS NULL RETURNING id",
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire subscriptions: %w", err)
+	}
+	return expired, nil
+}
+
 // CreateNotification inserts a new notification into the database
 // Returns the ID of the newly created notification
-func (d *DB) CreateNotification(notification *models.Notification) (int, error) {
+func (d *DB) CreateNotification(ctx context.Context, notification *models.Notification) (int, error) {
+	snapshot, err := marshalSnapshot(notification.Weather)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create notification: %w", err)
+	}
+
 	var notificationID int
-	err := d.SQL.QueryRow(
-		"INSERT INTO notifications (user_id, subscription_id, sent_at) VALUES ($1, $2, $3) RETURNING id",
-		notification.UserId, notification.SubscriptionId, notification.SentAt,
+	err = d.q().QueryRowContext(ctx,
+		"INSERT INTO notifications (user_id, subscription_id, sent_at, channel, provider_message_id, weather_snapshot) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6) RETURNING id",
+		notification.UserId, notification.SubscriptionId, notification.SentAt, notification.Channel, notification.ProviderMessageId, snapshot,
 	).Scan(&notificationID)
 
 	if err != nil {
@@ -336,3 +820,316 @@ func (d *DB) CreateNotification(notification *models.Notification) (int, error)
 	notification.Id = notificationID
 	return notificationID, nil
 }
+
+// CreateNotifications records several notifications at once; either all of them are stored or none
+// With the pgxpool driver they are sent in a single COPY, in the transaction the DB is bound to if any,
+// otherwise inserted one by one in a transaction
+// The IDs of the stored notifications are not returned
+func (d *DB) CreateNotifications(ctx context.Context, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	if d.pool == nil {
+		return d.WithTx(ctx, func(tx IDB) error {
+			for i := range notifications {
+				if _, err := tx.CreateNotification(ctx, &notifications[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	rows := make([][]any, len(notifications))
+	for i, n := range notifications {
+		snapshot, err := marshalSnapshot(n.Weather)
+		if err != nil {
+			return fmt.Errorf("failed to create notifications: %w", err)
+		}
+		var providerMessageID *string
+		if n.ProviderMessageId != "" {
+			providerMessageID = &n.ProviderMessageId
+		}
+		rows[i] = []any{n.UserId, n.SubscriptionId, n.SentAt, n.Channel, providerMessageID, snapshot}
+	}
+
+	_, err := d.native().CopyFrom(ctx, pgx.Identifier{"notifications"},
+		[]string{"user_id", "subscription_id", "sent_at", "channel", "provider_message_id", "weather_snapshot"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("failed to create notifications: %w", err)
+	}
+	return nil
+}
+
+// GetNotificationsByUserID retrieves the notification history of a user, newest first
+// Returns a page of notifications matching the filter or an error if the query fails
+func (d *DB) GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	notifications, err := d.getNotifications(ctx, "user_id", userID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications for user %d: %w", userID, err)
+	}
+	return notifications, nil
+}
+
+// GetNotificationsBySubscriptionID retrieves the notification history of a subscription, newest first
+// Returns a page of notifications matching the filter or an error if the query fails
+func (d *DB) GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	notifications, err := d.getNotifications(ctx, "subscription_id", subID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications for subscription %d: %w", subID, err)
+	}
+	return notifications, nil
+}
+
+// getNotifications runs the notification history query for the given owner column
+// column is always a constant chosen by the caller, never user input
+func (d *DB) getNotifications(ctx context.Context, column string, id int, filter models.NotificationFilter) ([]models.Notification, error) {
+	query := "SELECT id, user_id, subscription_id, sent_at, channel, COALESCE(provider_message_id, ''), weather_snapshot FROM notifications WHERE " + column + " = $1"
+	args := []any{id}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		query += fmt.Sprintf(" AND sent_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		query += fmt.Sprintf(" AND sent_at <= $%d", len(args))
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY sent_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := d.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+
+	for rows.Next() {
+		var n models.Notification
+		var snapshot []byte
+
+		if err := rows.Scan(&n.Id, &n.UserId, &n.SubscriptionId, &n.SentAt, &n.Channel, &n.ProviderMessageId, &snapshot); err != nil {
+			slog.ErrorContext(ctx, "Error scanning notification row", "error", err) // Log the error but try to continue
+			continue                                                                // Skip this row
+		}
+		if n.Weather, err = unmarshalSnapshot(snapshot); err != nil {
+			slog.ErrorContext(ctx, "Error decoding weather snapshot", "notification_id", n.Id, "error", err)
+		}
+		notifications = append(notifications, n)
+	}
+
+	// Check for errors encountered during row iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during notifications iteration: %w", err)
+	}
+
+	return notifications, nil
+}
+
+// marshalSnapshot encodes a weather snapshot for a JSONB column, nil becomes NULL
+func marshalSnapshot(snapshot *models.WeatherSnapshot) ([]byte, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode weather snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// nullJSON passes an empty JSON document to the database as NULL
+func nullJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
+// queryIDs runs a statement returning a single id column and collects the IDs in ascending order
+func queryIDs(ctx context.Context, q querier, query string, args ...any) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// timestamp returns the current time as created_at, updated_at and deleted_at store it:
+// in UTC and with the microsecond precision of PostgreSQL
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// utcTime scans a timestamp column into a time in UTC, the zone timestamp() writes in
+type utcTime struct {
+	t *time.Time
+}
+
+func (u utcTime) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	*u.t = t.UTC()
+	return nil
+}
+
+// unmarshalSnapshot decodes a weather snapshot read from a JSONB column, NULL becomes nil
+func unmarshalSnapshot(data []byte) (*models.WeatherSnapshot, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	snapshot := &models.WeatherSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// CreateDeferredNotification stores a notification that has to wait for the end of the user's quiet hours
+// Returns the ID of the newly created deferred notification
+func (d *DB) CreateDeferredNotification(ctx context.Context, deferred *models.DeferredNotification) (int, error) {
+	snapshot, err := marshalSnapshot(deferred.Weather)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create deferred notification: %w", err)
+	}
+
+	var deferredID int
+	err = d.q().QueryRowContext(ctx,
+		"INSERT INTO deferred_notifications (user_id, subscription_id, subject, body, deliver_after, weather_snapshot) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		deferred.UserId, deferred.SubscriptionId, deferred.Subject, deferred.Body, deferred.DeliverAfter, snapshot,
+	).Scan(&deferredID)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create deferred notification: %w", err)
+	}
+	deferred.Id = deferredID
+	return deferredID, nil
+}
+
+// GetDueDeferredNotifications retrieves all deferred notifications whose delivery time is not after now,
+// except those of deleted subscriptions
+// Returns a slice of deferred notifications ordered by delivery time or an error if the query fails
+func (d *DB) GetDueDeferredNotifications(ctx context.Context, now time.Time) ([]models.DeferredNotification, error) {
+	rows, err := d.q().QueryContext(ctx,
+		"SELECT id, user_id, subscription_id, subject, body, deliver_after, weather_snapshot FROM deferred_notifications WHERE deliver_after <= $1 AND subscription_id IN (SELECT id FROM subscriptions WHERE deleted_at IS NULL) ORDER BY deliver_after, id",
+		now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due deferred notifications: %w", err)
+	}
+	defer rows.Close()
+
+	deferred := []models.DeferredNotification{}
+
+	for rows.Next() {
+		var n models.DeferredNotification
+		var snapshot []byte
+
+		if err := rows.Scan(&n.Id, &n.UserId, &n.SubscriptionId, &n.Subject, &n.Body, &n.DeliverAfter, &snapshot); err != nil {
+			slog.ErrorContext(ctx, "Error scanning deferred notification row", "error", err) // Log the error but try to continue
+			continue                                                                         // Skip this row
+		}
+		if n.Weather, err = unmarshalSnapshot(snapshot); err != nil {
+			slog.ErrorContext(ctx, "Error decoding weather snapshot", "deferred_notification_id", n.Id, "error", err)
+		}
+		deferred = append(deferred, n)
+	}
+
+	// Check for errors encountered during row iteration
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during deferred notifications iteration: %w", err)
+	}
+
+	return deferred, nil
+}
+
+// DeleteDeferredNotification deletes a deferred notification once it has been handled
+// Returns an error if the deletion fails
+func (d *DB) DeleteDeferredNotification(ctx context.Context, id int) error {
+	_, err := d.q().ExecContext(ctx,
+		"DELETE FROM deferred_notifications WHERE id = $1",
+		id,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to delete deferred notification: %w", err)
+	}
+	return nil
+}
+
+// CreateAuditEvent appends an event to the audit log and sets its ID and creation time
+func (d *DB) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) (int, error) {
+	now := timestamp()
+	var eventID int
+	err := d.q().QueryRowContext(ctx,
+		"INSERT INTO audit_log (entity_type, entity_id, action, actor, before_state, after_state, request_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		event.EntityType, event.EntityId, event.Action, event.Actor, nullJSON(event.Before), nullJSON(event.After), event.RequestId, now,
+	).Scan(&eventID)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create audit event: %w", err)
+	}
+	event.Id = eventID
+	event.CreatedAt = now
+	return eventID, nil
+}
+
+// GetAuditEvents retrieves a page of the audit log matching the filter, newest first
+func (d *DB) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	query := "SELECT id, entity_type, entity_id, action, actor, before_state, after_state, request_id, created_at FROM audit_log WHERE 1 = 1"
+	args := []any{}
+	if filter.EntityType != "" {
+		args = append(args, filter.EntityType)
+		query += fmt.Sprintf(" AND entity_type = $%d", len(args))
+		if filter.EntityId != 0 {
+			args = append(args, filter.EntityId)
+			query += fmt.Sprintf(" AND entity_id = $%d", len(args))
+		}
+	}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		query += fmt.Sprintf(" AND actor = $%d", len(args))
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := d.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		if err := rows.Scan(&e.Id, &e.EntityType, &e.EntityId, &e.Action, &e.Actor, &before, &after, &e.RequestId, utcTime{&e.CreatedAt}); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		e.Before, e.After = before, after
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during audit events iteration: %w", err)
+	}
+	return events, nil
+}
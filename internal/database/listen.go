@@ -0,0 +1,84 @@
+// internal/database/listen.go
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrListenNotSupported is returned by Listen when the database does not use the pgxpool driver,
+// and by Notify on SQLite
+var ErrListenNotSupported = errors.New("LISTEN requires PostgreSQL with DB_DRIVER=pgxpool")
+
+// listenRetryDelay is how long Listen waits before reconnecting after a connection error
+const listenRetryDelay = time.Second
+
+// Notify sends payload to the listeners of channel
+// Inside WithTx the notification is delivered when the transaction commits
+func (d *DB) Notify(ctx context.Context, channel, payload string) error {
+	if d.sqlite {
+		return ErrListenNotSupported
+	}
+	if _, err := d.q().ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", channel, err)
+	}
+	return nil
+}
+
+// Listen calls handle with the payload of every notification sent to channel until ctx is done
+// It keeps one pool connection for itself and reconnects after connection errors,
+// notifications sent while it is reconnecting are lost
+func (d *DB) Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	if d.pool == nil {
+		return ErrListenNotSupported
+	}
+
+	for {
+		err := d.listen(ctx, channel, handle)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "Listening for notifications failed, reconnecting", "channel", channel, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// listen waits for notifications on a single connection until ctx is done or the connection fails
+func (d *DB) listen(ctx context.Context, channel string, handle func(payload string)) error {
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer func() {
+		// The connection goes back to the pool, so it must stop listening first
+		if !conn.Conn().IsClosed() {
+			if _, err := conn.Exec(context.Background(), "UNLISTEN *"); err != nil {
+				conn.Conn().Close(context.Background())
+			}
+		}
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+	slog.DebugContext(ctx, "Listening for notifications", "channel", channel)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
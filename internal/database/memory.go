@@ -0,0 +1,719 @@
+// internal/database/memory.go
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"maxcool.com/weatherapp/internal/models"
+)
+
+// Errors returned by MemoryDB where Postgres would report a constraint violation
+// ErrForeignKey is also returned by DB when a subscription is created for a deleted user
+var (
+	ErrDuplicateEmail   = errors.New("a user with this email already exists")
+	ErrForeignKey       = errors.New("referenced row does not exist")
+	ErrNegativeArgument = errors.New("LIMIT and OFFSET must not be negative")
+)
+
+// MemoryDB is an IDB that keeps everything in memory, for tests and demos
+// It follows the SQL schema: emails of users that are not deleted are unique, rows must reference existing
+// users and subscriptions, deletes are soft and purging a user or a subscription removes everything referencing it
+type MemoryDB struct {
+	mu    *sync.Mutex
+	state *memoryState
+	inTx  bool // set on the IDB passed to the function of WithTx, which already holds mu
+}
+
+// memoryState holds the tables; seq is shared with the copies made for transactions,
+// so like Postgres sequences IDs are never reused after a rollback
+type memoryState struct {
+	users                map[int]models.User
+	subscriptions        map[int]models.Subscription
+	notifications        map[int]models.Notification
+	deferred             map[int]models.DeferredNotification
+	deletedUsers         map[int]time.Time // deleted_at of soft-deleted users
+	deletedSubscriptions map[int]time.Time // deleted_at of soft-deleted subscriptions
+	audit                map[int]models.AuditEvent
+	seq                  *memorySequences
+}
+
+type memorySequences struct {
+	users, subscriptions, notifications, deferred, audit int
+}
+
+// NewMemoryDB returns an empty MemoryDB
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		mu: &sync.Mutex{},
+		state: &memoryState{
+			users:                map[int]models.User{},
+			subscriptions:        map[int]models.Subscription{},
+			notifications:        map[int]models.Notification{},
+			deferred:             map[int]models.DeferredNotification{},
+			deletedUsers:         map[int]time.Time{},
+			deletedSubscriptions: map[int]time.Time{},
+			audit:                map[int]models.AuditEvent{},
+			seq:                  &memorySequences{},
+		},
+	}
+}
+
+// lock locks the database unless the caller runs inside WithTx, which holds the lock already
+func (m *MemoryDB) lock() func() {
+	if m.inTx {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+// clone copies the tables so a transaction can be discarded; the rows are values and are copied with the maps
+func (s *memoryState) clone() *memoryState {
+	c := &memoryState{
+		users:                make(map[int]models.User, len(s.users)),
+		subscriptions:        make(map[int]models.Subscription, len(s.subscriptions)),
+		notifications:        make(map[int]models.Notification, len(s.notifications)),
+		deferred:             make(map[int]models.DeferredNotification, len(s.deferred)),
+		deletedUsers:         make(map[int]time.Time, len(s.deletedUsers)),
+		deletedSubscriptions: make(map[int]time.Time, len(s.deletedSubscriptions)),
+		audit:                make(map[int]models.AuditEvent, len(s.audit)),
+		seq:                  s.seq,
+	}
+	for id, row := range s.users {
+		c.users[id] = row
+	}
+	for id, row := range s.subscriptions {
+		c.subscriptions[id] = row
+	}
+	for id, row := range s.notifications {
+		c.notifications[id] = row
+	}
+	for id, row := range s.deferred {
+		c.deferred[id] = row
+	}
+	for id, deletedAt := range s.deletedUsers {
+		c.deletedUsers[id] = deletedAt
+	}
+	for id, deletedAt := range s.deletedSubscriptions {
+		c.deletedSubscriptions[id] = deletedAt
+	}
+	for id, row := range s.audit {
+		c.audit[id] = row
+	}
+	return c
+}
+
+// WithTx runs fn against a copy of the data and keeps the copy only if fn returns nil
+// Other callers wait until the transaction ends, so transactions are serializable
+func (m *MemoryDB) WithTx(ctx context.Context, fn func(tx IDB) error) (err error) {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryDB{mu: m.mu, state: m.state.clone(), inTx: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	*m.state = *tx.state
+	return nil
+}
+
+// Close does nothing, the data lives as long as the MemoryDB
+func (m *MemoryDB) Close() {}
+
+// --- Users ---
+
+// CreateUser stores a new user, sets its timestamps and returns its ID
+func (m *MemoryDB) CreateUser(ctx context.Context, user *models.User) (int, error) {
+	defer m.lock()()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, fmt.Errorf("failed to create user: %w", ErrDuplicateEmail)
+	}
+	m.state.seq.users++
+	user.Id = m.state.seq.users
+	user.CreatedAt = timestamp()
+	user.UpdatedAt = user.CreatedAt
+	m.state.users[user.Id] = *user
+	return user.Id, nil
+}
+
+// emailTaken reports whether a user other than exceptID that is not deleted has the email
+func (m *MemoryDB) emailTaken(email string, exceptID int) bool {
+	for id, u := range m.state.users {
+		if id != exceptID && u.Email == email && m.userVisible(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// userVisible reports whether the user exists and is not deleted
+func (m *MemoryDB) userVisible(userID int) bool {
+	_, ok := m.state.users[userID]
+	_, deleted := m.state.deletedUsers[userID]
+	return ok && !deleted
+}
+
+// subscriptionVisible reports whether the subscription exists and is not deleted
+func (m *MemoryDB) subscriptionVisible(subID int) bool {
+	_, ok := m.state.subscriptions[subID]
+	_, deleted := m.state.deletedSubscriptions[subID]
+	return ok && !deleted
+}
+
+// GetUserByID returns the user, or nil if not found or deleted
+func (m *MemoryDB) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
+	defer m.lock()()
+
+	if !m.userVisible(userID) {
+		return nil, nil
+	}
+	user := m.state.users[userID]
+	return &user, nil
+}
+
+// GetUserIncludingDeleted returns the user, deleted or not, or nil if not found
+func (m *MemoryDB) GetUserIncludingDeleted(ctx context.Context, userID int) (*models.User, error) {
+	defer m.lock()()
+
+	user, ok := m.state.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// GetUserByEmail returns the user with the email, or nil if not found or deleted
+func (m *MemoryDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	defer m.lock()()
+
+	for id, user := range m.state.users {
+		if user.Email == email && m.userVisible(id) {
+			return &user, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetUsers returns all users that are not deleted, ordered by ID
+func (m *MemoryDB) GetUsers(ctx context.Context) ([]models.User, error) {
+	defer m.lock()()
+
+	users := []models.User{}
+	for _, id := range sortedKeys(m.state.users) {
+		if m.userVisible(id) {
+			users = append(users, m.state.users[id])
+		}
+	}
+	return users, nil
+}
+
+// UpdateUser overwrites the stored user except for its creation time and sets its update time
+// Updating a missing or deleted user does nothing
+func (m *MemoryDB) UpdateUser(ctx context.Context, user *models.User) error {
+	defer m.lock()()
+
+	if !m.userVisible(user.Id) {
+		return nil
+	}
+	if m.emailTaken(user.Email, user.Id) {
+		return fmt.Errorf("failed to update user: %w", ErrDuplicateEmail)
+	}
+	user.UpdatedAt = timestamp()
+	updated := *user
+	updated.CreatedAt = m.state.users[user.Id].CreatedAt
+	m.state.users[user.Id] = updated
+	return nil
+}
+
+// DeleteUser soft-deletes the user together with its subscriptions and drops its deferred notifications
+func (m *MemoryDB) DeleteUser(ctx context.Context, userID int) error {
+	defer m.lock()()
+
+	if !m.userVisible(userID) {
+		return nil
+	}
+	now := timestamp()
+	m.state.deletedUsers[userID] = now
+	for id, sub := range m.state.subscriptions {
+		if sub.UserId == userID && m.subscriptionVisible(id) {
+			m.state.deletedSubscriptions[id] = now
+		}
+	}
+	for id, n := range m.state.deferred {
+		if n.UserId == userID {
+			delete(m.state.deferred, id)
+		}
+	}
+	return nil
+}
+
+// PurgeDeleted removes the users and subscriptions soft-deleted at or before the given time
+// and everything referencing them, and returns the IDs of the users and subscriptions removed
+func (m *MemoryDB) PurgeDeleted(ctx context.Context, before time.Time) (userIDs, subscriptionIDs []int, err error) {
+	defer m.lock()()
+
+	for id, deletedAt := range m.state.deletedSubscriptions {
+		if !deletedAt.After(before) {
+			m.purgeSubscription(id)
+			subscriptionIDs = append(subscriptionIDs, id)
+		}
+	}
+	for id, deletedAt := range m.state.deletedUsers {
+		if !deletedAt.After(before) {
+			m.purgeUser(id)
+			userIDs = append(userIDs, id)
+		}
+	}
+	sort.Ints(userIDs)
+	sort.Ints(subscriptionIDs)
+	return userIDs, subscriptionIDs, nil
+}
+
+// EraseUser removes the user, deleted or not, with the rows referencing it,
+// and clears the states and API client actors the audit log recorded for the user and its subscriptions
+func (m *MemoryDB) EraseUser(ctx context.Context, userID int) (bool, error) {
+	defer m.lock()()
+
+	if _, ok := m.state.users[userID]; !ok {
+		return false, nil
+	}
+	for id, e := range m.state.audit {
+		if e.EntityType == models.AuditEntityUser && e.EntityId == userID ||
+			e.EntityType == models.AuditEntitySubscription && (stateUserID(e.Before) == userID || stateUserID(e.After) == userID) {
+			e.Before, e.After = nil, nil
+			if strings.HasPrefix(e.Actor, "api:") || strings.HasPrefix(e.Actor, "anonymous:") {
+				e.Actor = models.AuditActorErased
+			}
+			m.state.audit[id] = e
+		}
+	}
+	m.purgeUser(userID)
+	return true, nil
+}
+
+// stateUserID returns the user_id of a state recorded in the audit log, or 0 if it has none
+func stateUserID(state json.RawMessage) int {
+	var owner struct {
+		UserId int `json:"user_id"`
+	}
+	if json.Unmarshal(state, &owner) != nil {
+		return 0
+	}
+	return owner.UserId
+}
+
+// purgeUser removes a user and the rows referencing it
+func (m *MemoryDB) purgeUser(userID int) {
+	delete(m.state.users, userID)
+	delete(m.state.deletedUsers, userID)
+	for id, sub := range m.state.subscriptions {
+		if sub.UserId == userID {
+			m.purgeSubscription(id)
+		}
+	}
+	for id, n := range m.state.notifications {
+		if n.UserId == userID {
+			delete(m.state.notifications, id)
+		}
+	}
+	for id, n := range m.state.deferred {
+		if n.UserId == userID {
+			delete(m.state.deferred, id)
+		}
+	}
+}
+
+// --- Subscriptions ---
+
+// CreateSubscription stores a new subscription of an existing user and returns its ID
+func (m *MemoryDB) CreateSubscription(ctx context.Context, sub *models.Subscription) (int, error) {
+	defer m.lock()()
+
+	if !m.userVisible(sub.UserId) {
+		return 0, fmt.Errorf("failed to create subscription: user %d: %w", sub.UserId, ErrForeignKey)
+	}
+	m.state.seq.subscriptions++
+	sub.Id = m.state.seq.subscriptions
+	sub.UserEmail = m.state.users[sub.UserId].Email
+	sub.CreatedAt = timestamp()
+	sub.UpdatedAt = sub.CreatedAt
+	m.state.subscriptions[sub.Id] = copySubscription(*sub)
+	return sub.Id, nil
+}
+
+// readSubscription returns a copy of the stored subscription with the current email of its user,
+// which the stored copy does not track
+func (m *MemoryDB) readSubscription(subID int) models.Subscription {
+	sub := copySubscription(m.state.subscriptions[subID])
+	sub.UserEmail = m.state.users[sub.UserId].Email
+	return sub
+}
+
+// GetSubscriptionByID returns the subscription, or nil if not found or deleted
+func (m *MemoryDB) GetSubscriptionByID(ctx context.Context, subID int) (*models.Subscription, error) {
+	defer m.lock()()
+
+	if !m.subscriptionVisible(subID) {
+		return nil, nil
+	}
+	sub := m.readSubscription(subID)
+	return &sub, nil
+}
+
+// UpdateSubscription overwrites the stored subscription except for its creation time, and sets its update time
+// Like CreateSubscription it ignores sub.UserEmail, subscriptions are read with the email of their user
+// Updating a missing or deleted subscription does nothing
+func (m *MemoryDB) UpdateSubscription(ctx context.Context, sub *models.Subscription) error {
+	defer m.lock()()
+
+	if !m.subscriptionVisible(sub.Id) {
+		return nil
+	}
+	if _, ok := m.state.users[sub.UserId]; !ok {
+		return fmt.Errorf("failed to update subscription: user %d: %w", sub.UserId, ErrForeignKey)
+	}
+	stored := m.state.subscriptions[sub.Id]
+	sub.UpdatedAt = timestamp()
+	updated := copySubscription(*sub)
+	updated.CreatedAt = stored.CreatedAt
+	m.state.subscriptions[sub.Id] = updated
+	return nil
+}
+
+// DeleteSubscription soft-deletes the subscription and drops its deferred notifications
+func (m *MemoryDB) DeleteSubscription(ctx context.Context, subID int) error {
+	defer m.lock()()
+
+	if !m.subscriptionVisible(subID) {
+		return nil
+	}
+	m.state.deletedSubscriptions[subID] = timestamp()
+	for id, n := range m.state.deferred {
+		if n.SubscriptionId == subID {
+			delete(m.state.deferred, id)
+		}
+	}
+	return nil
+}
+
+// purgeSubscription removes a subscription and the rows referencing it
+func (m *MemoryDB) purgeSubscription(subID int) {
+	delete(m.state.subscriptions, subID)
+	delete(m.state.deletedSubscriptions, subID)
+	for id, n := range m.state.notifications {
+		if n.SubscriptionId == subID {
+			delete(m.state.notifications, id)
+		}
+	}
+	for id, n := range m.state.deferred {
+		if n.SubscriptionId == subID {
+			delete(m.state.deferred, id)
+		}
+	}
+}
+
+// GetSubscriptions returns all subscriptions that are not deleted, ordered by ID
+func (m *MemoryDB) GetSubscriptions(ctx context.Context) ([]models.Subscription, error) {
+	defer m.lock()()
+
+	subscriptions := []models.Subscription{}
+	for _, id := range sortedKeys(m.state.subscriptions) {
+		if m.subscriptionVisible(id) {
+			subscriptions = append(subscriptions, m.readSubscription(id))
+		}
+	}
+	return subscriptions, nil
+}
+
+// GetSubscriptionsByUserID returns the subscriptions of a user that are not deleted, ordered by ID
+func (m *MemoryDB) GetSubscriptionsByUserID(ctx context.Context, userID int) ([]models.Subscription, error) {
+	defer m.lock()()
+
+	subscriptions := []models.Subscription{}
+	for _, id := range sortedKeys(m.state.subscriptions) {
+		if m.state.subscriptions[id].UserId == userID && m.subscriptionVisible(id) {
+			subscriptions = append(subscriptions, m.readSubscription(id))
+		}
+	}
+	return subscriptions, nil
+}
+
+// ListSubscriptions returns the subscriptions matching the filter that are not deleted, in ID order
+// It returns at most filter.Limit subscriptions, or all of them if the limit is not positive
+func (m *MemoryDB) ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) ([]models.Subscription, error) {
+	defer m.lock()()
+
+	subscriptions := []models.Subscription{}
+	for _, id := range sortedKeys(m.state.subscriptions) {
+		if filter.Limit > 0 && len(subscriptions) == filter.Limit {
+			break
+		}
+		sub := m.state.subscriptions[id]
+		if id <= filter.AfterId || !filter.IncludeDeleted && !m.subscriptionVisible(id) ||
+			filter.UserId != 0 && sub.UserId != filter.UserId ||
+			filter.City != "" && !strings.EqualFold(sub.City, filter.City) ||
+			filter.Active != nil && sub.Active != *filter.Active {
+			continue
+		}
+		subscriptions = append(subscriptions, m.readSubscription(id))
+	}
+	return subscriptions, nil
+}
+
+// ExpireSubscriptions deactivates all active subscriptions whose expiry time is not after now
+// Returns the IDs of the subscriptions that were deactivated, in ascending order
+func (m *MemoryDB) ExpireSubscriptions(ctx context.Context, now time.Time) ([]int, error) {
+	defer m.lock()()
+
+	var expired []int
+	for id, sub := range m.state.subscriptions {
+		if sub.Active && sub.ExpiresAt != nil && !sub.ExpiresAt.After(now) && m.subscriptionVisible(id) {
+			sub.Active = false
+			sub.UpdatedAt = now
+			m.state.subscriptions[id] = sub
+			expired = append(expired, id)
+		}
+	}
+	sort.Ints(expired)
+	return expired, nil
+}
+
+// --- Notifications ---
+
+// CreateNotification stores a sent notification and returns its ID
+func (m *MemoryDB) CreateNotification(ctx context.Context, notification *models.Notification) (int, error) {
+	defer m.lock()()
+
+	if err := m.checkReferences(notification.UserId, notification.SubscriptionId); err != nil {
+		return 0, fmt.Errorf("failed to create notification: %w", err)
+	}
+	m.state.seq.notifications++
+	notification.Id = m.state.seq.notifications
+	m.state.notifications[notification.Id] = copyNotification(*notification)
+	return notification.Id, nil
+}
+
+// CreateNotifications stores several notifications at once; either all of them are stored or none
+func (m *MemoryDB) CreateNotifications(ctx context.Context, notifications []models.Notification) error {
+	return m.WithTx(ctx, func(tx IDB) error {
+		for i := range notifications {
+			if _, err := tx.CreateNotification(ctx, &notifications[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// checkReferences returns ErrForeignKey unless both the user and the subscription exist
+func (m *MemoryDB) checkReferences(userID, subID int) error {
+	if _, ok := m.state.users[userID]; !ok {
+		return fmt.Errorf("user %d: %w", userID, ErrForeignKey)
+	}
+	if _, ok := m.state.subscriptions[subID]; !ok {
+		return fmt.Errorf("subscription %d: %w", subID, ErrForeignKey)
+	}
+	return nil
+}
+
+// GetNotificationsByUserID returns the notification history of a user, newest first
+func (m *MemoryDB) GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	defer m.lock()()
+
+	notifications, err := m.getNotifications(func(n models.Notification) bool { return n.UserId == userID }, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications for user %d: %w", userID, err)
+	}
+	return notifications, nil
+}
+
+// GetNotificationsBySubscriptionID returns the notification history of a subscription, newest first
+func (m *MemoryDB) GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	defer m.lock()()
+
+	notifications, err := m.getNotifications(func(n models.Notification) bool { return n.SubscriptionId == subID }, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications for subscription %d: %w", subID, err)
+	}
+	return notifications, nil
+}
+
+// getNotifications filters, orders and paginates the notifications like the SQL history query
+func (m *MemoryDB) getNotifications(owned func(models.Notification) bool, filter models.NotificationFilter) ([]models.Notification, error) {
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, ErrNegativeArgument
+	}
+
+	notifications := []models.Notification{}
+	for _, n := range m.state.notifications {
+		if !owned(n) || (filter.From != nil && n.SentAt.Before(*filter.From)) || (filter.To != nil && n.SentAt.After(*filter.To)) {
+			continue
+		}
+		notifications = append(notifications, copyNotification(n))
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		if !notifications[i].SentAt.Equal(notifications[j].SentAt) {
+			return notifications[i].SentAt.After(notifications[j].SentAt)
+		}
+		return notifications[i].Id > notifications[j].Id
+	})
+
+	start := min(filter.Offset, len(notifications))
+	end := min(start+filter.Limit, len(notifications))
+	return notifications[start:end], nil
+}
+
+// --- Deferred notifications ---
+
+// CreateDeferredNotification stores a notification that has to wait for the end of the user's quiet hours
+func (m *MemoryDB) CreateDeferredNotification(ctx context.Context, deferred *models.DeferredNotification) (int, error) {
+	defer m.lock()()
+
+	if err := m.checkReferences(deferred.UserId, deferred.SubscriptionId); err != nil {
+		return 0, fmt.Errorf("failed to create deferred notification: %w", err)
+	}
+	m.state.seq.deferred++
+	deferred.Id = m.state.seq.deferred
+	stored := *deferred
+	stored.Weather = copySnapshot(deferred.Weather)
+	m.state.deferred[deferred.Id] = stored
+	return deferred.Id, nil
+}
+
+// GetDueDeferredNotifications returns the deferred notifications whose delivery time is not after now,
+// except those of deleted subscriptions, ordered by delivery time
+func (m *MemoryDB) GetDueDeferredNotifications(ctx context.Context, now time.Time) ([]models.DeferredNotification, error) {
+	defer m.lock()()
+
+	due := []models.DeferredNotification{}
+	for _, n := range m.state.deferred {
+		if !n.DeliverAfter.After(now) && m.subscriptionVisible(n.SubscriptionId) {
+			n.Weather = copySnapshot(n.Weather)
+			due = append(due, n)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].DeliverAfter.Equal(due[j].DeliverAfter) {
+			return due[i].DeliverAfter.Before(due[j].DeliverAfter)
+		}
+		return due[i].Id < due[j].Id
+	})
+	return due, nil
+}
+
+// DeleteDeferredNotification deletes a deferred notification once it has been handled
+func (m *MemoryDB) DeleteDeferredNotification(ctx context.Context, id int) error {
+	defer m.lock()()
+
+	delete(m.state.deferred, id)
+	return nil
+}
+
+// --- Audit log ---
+
+// CreateAuditEvent appends an event to the audit log and sets its ID and creation time
+func (m *MemoryDB) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) (int, error) {
+	defer m.lock()()
+
+	m.state.seq.audit++
+	event.Id = m.state.seq.audit
+	event.CreatedAt = timestamp()
+	m.state.audit[event.Id] = copyAuditEvent(*event)
+	return event.Id, nil
+}
+
+// GetAuditEvents filters, orders and paginates the audit log like the SQL query
+func (m *MemoryDB) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	defer m.lock()()
+
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, ErrNegativeArgument
+	}
+
+	events := []models.AuditEvent{}
+	for _, e := range m.state.audit {
+		if filter.EntityType != "" && (e.EntityType != filter.EntityType || (filter.EntityId != 0 && e.EntityId != filter.EntityId)) {
+			continue
+		}
+		if filter.Actor != "" && e.Actor != filter.Actor {
+			continue
+		}
+		events = append(events, copyAuditEvent(e))
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].CreatedAt.After(events[j].CreatedAt)
+		}
+		return events[i].Id > events[j].Id
+	})
+
+	start := min(filter.Offset, len(events))
+	end := min(start+filter.Limit, len(events))
+	return events[start:end], nil
+}
+
+// --- Helpers ---
+
+// sortedKeys returns the IDs of a table in ascending order
+func sortedKeys[V any](rows map[int]V) []int {
+	ids := make([]int, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// copySubscription copies the time pointers so callers cannot change the stored row
+func copySubscription(sub models.Subscription) models.Subscription {
+	sub.PausedUntil = copyTime(sub.PausedUntil)
+	sub.ExpiresAt = copyTime(sub.ExpiresAt)
+	return sub
+}
+
+func copyNotification(n models.Notification) models.Notification {
+	n.Weather = copySnapshot(n.Weather)
+	return n
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func copySnapshot(s *models.WeatherSnapshot) *models.WeatherSnapshot {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// copyAuditEvent copies the JSON documents; like a NULL column an empty document is stored as nil
+func copyAuditEvent(e models.AuditEvent) models.AuditEvent {
+	e.Before = copyJSON(e.Before)
+	e.After = copyJSON(e.After)
+	return e
+}
+
+func copyJSON(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	return append(json.RawMessage{}, data...)
+}
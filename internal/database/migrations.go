@@ -1,63 +1,177 @@
-// internal/models/models.go
+// internal/database/migrations.go
 package database
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
 
 	"maxcool.com/weatherapp/internal/config"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 )
 
-func MigrateUpAll(config *config.Config) {
-	migrationsPath := "file://internal/database/migrations"
+// migrationsFS holds the SQL migrations compiled into the binary,
+// so migrating does not depend on the working directory
+// The SQLite migrations in migrations/sqlite have the same versions as the PostgreSQL ones
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationsFS embed.FS
 
-	m, err := migrate.New(
-		migrationsPath,
-		config.PostgresConnectionString,
-	)
+// newMigrate creates a migrate instance that reads the embedded migrations for the configured database
+func newMigrate(config *config.Config) (*migrate.Migrate, error) {
+	dir, connectionString := "migrations", config.PostgresConnectionString
+	if config.UsesSQLite() {
+		// Some SQLite migrations rebuild tables, which must not cascade to the rows referencing them
+		dir, connectionString = "migrations/sqlite", withQueryParam(connectionString, "_pragma=foreign_keys(0)")
+	}
+
+	source, err := iofs.New(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open embedded migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", source, connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	return m, nil
+}
+
+// runMigration creates a migrate instance, runs the given step and closes the instance
+// ErrNoChange is not treated as an error
+func runMigration(config *config.Config, step func(m *migrate.Migrate) error) error {
+	m, err := newMigrate(config)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	err = step(m)
+	if errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("No migrations to apply.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Migrations applied successfully.")
+	return nil
+}
+
+// MigrateUpAll applies all pending migrations
+func MigrateUpAll(config *config.Config) error {
+	slog.Info("Attempting to run migrations...")
+	if err := runMigration(config, (*migrate.Migrate).Up); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return nil
+}
+
+// MigrateDownAll rolls back all applied migrations
+func MigrateDownAll(config *config.Config) error {
+	slog.Info("Attempting to roll back all migrations...")
+	if err := runMigration(config, (*migrate.Migrate).Down); err != nil {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+	return nil
+}
+
+// MigrateSteps applies n migrations, or rolls back -n migrations if n is negative
+func MigrateSteps(config *config.Config, n int) error {
+	slog.Info("Attempting to migrate", "steps", n)
+	err := runMigration(config, func(m *migrate.Migrate) error { return m.Steps(n) })
+	if err != nil {
+		return fmt.Errorf("failed to migrate %d step(s): %w", n, err)
+	}
+	return nil
+}
 
+// MigrateGoto migrates up or down to the given version
+func MigrateGoto(config *config.Config, version uint) error {
+	slog.Info("Attempting to migrate to version", "version", version)
+	err := runMigration(config, func(m *migrate.Migrate) error { return m.Migrate(version) })
 	if err != nil {
-		log.Fatalf("Failed to create migrate instance: %v", err)
+		return fmt.Errorf("failed to migrate to version %d: %w", version, err)
 	}
+	return nil
+}
 
-	log.Println("Attempting to run migrations...")
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		// ErrNoChange means there were no pending migrations
-		log.Fatalf("Failed to apply migrations: %v", err)
+// MigrateForce sets the migration version without running any migration and clears the dirty flag
+// It is meant to recover from a migration that failed halfway
+func MigrateForce(config *config.Config, version int) error {
+	m, err := newMigrate(config)
+	if err != nil {
+		return err
 	}
+	defer m.Close()
 
-	if err == migrate.ErrNoChange {
-		log.Println("No migrations to apply.")
-	} else {
-		log.Println("Migrations applied successfully.")
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("failed to force version %d: %w", version, err)
 	}
+	return nil
 }
 
-func MigrateDownAll(config *config.Config) {
-	migrationsPath := "file://internal/database/migrations"
+// MigrationVersion returns the currently applied migration version and whether it is dirty
+// A database without any applied migration reports version 0
+func MigrationVersion(config *config.Config) (uint, bool, error) {
+	m, err := newMigrate(config)
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
 
-	m, err := migrate.New(
-		migrationsPath,
-		config.PostgresConnectionString,
-	)
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read migration version: %w", err)
+	}
+	return version, dirty, nil
+}
 
+// LatestMigrationVersion returns the version of the newest embedded migration
+func LatestMigrationVersion() (uint, error) {
+	entries, err := fs.ReadDir(migrationsFS, "migrations")
 	if err != nil {
-		log.Fatalf("Failed to create migrate instance: %v", err)
+		return 0, fmt.Errorf("failed to read embedded migrations: %w", err)
 	}
 
-	log.Println("Attempting to run migrations...")
-	if err := m.Down(); err != nil && err != migrate.ErrNoChange {
-		// ErrNoChange means there were no pending migrations
-		log.Fatalf("Failed to apply migrations: %v", err)
+	var latest uint64
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, version)
 	}
+	return uint(latest), nil
+}
 
-	if err == migrate.ErrNoChange {
-		log.Println("No migrations to apply.")
-	} else {
-		log.Println("Migrations applied successfully.")
+// SchemaVersion reads the applied migration version and dirty flag through the existing connection pool
+// Unlike MigrationVersion it does not open a new connection, so it is cheap enough for health checks
+func (d *DB) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := d.SQL.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read schema version: %w", err)
 	}
+	return uint(version), dirty, nil
 }
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS digest_mode BOOLEAN NOT NULL DEFAULT FALSE;
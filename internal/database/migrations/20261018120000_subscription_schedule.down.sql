@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions
+    DROP COLUMN IF EXISTS active,
+    DROP COLUMN IF EXISTS paused_until,
+    DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_notifications_subscription_sent_at;
+DROP INDEX IF EXISTS idx_notifications_user_sent_at;
+
+ALTER TABLE deferred_notifications DROP COLUMN IF EXISTS weather_snapshot;
+
+ALTER TABLE notifications
+    DROP COLUMN IF EXISTS channel,
+    DROP COLUMN IF EXISTS provider_message_id,
+    DROP COLUMN IF EXISTS weather_snapshot;
@@ -0,0 +1,9 @@
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS channel VARCHAR(32) NOT NULL DEFAULT 'email',
+    ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(255),
+    ADD COLUMN IF NOT EXISTS weather_snapshot JSONB;
+
+ALTER TABLE deferred_notifications ADD COLUMN IF NOT EXISTS weather_snapshot JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_sent_at ON notifications (user_id, sent_at);
+CREATE INDEX IF NOT EXISTS idx_notifications_subscription_sent_at ON notifications (subscription_id, sent_at);
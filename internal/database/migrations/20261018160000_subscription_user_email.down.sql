@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS subscriptions_user_id_email_fkey;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_id_email_key;
@@ -0,0 +1,7 @@
+-- subscriptions.user_email is a copy of the user's email that used to go stale when the email changed
+UPDATE subscriptions s SET user_email = u.email FROM users u WHERE u.id = s.user_id AND s.user_email <> u.email;
+
+-- The copy follows the user's email through a foreign key, so it cannot diverge again
+ALTER TABLE users ADD CONSTRAINT users_id_email_key UNIQUE (id, email);
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_user_id_email_fkey
+    FOREIGN KEY (user_id, user_email) REFERENCES users (id, email) ON UPDATE CASCADE ON DELETE CASCADE;
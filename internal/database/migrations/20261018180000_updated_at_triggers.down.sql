@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS subscriptions_touch_updated_at ON subscriptions;
+DROP TRIGGER IF EXISTS users_touch_updated_at ON users;
+DROP FUNCTION IF EXISTS touch_updated_at();
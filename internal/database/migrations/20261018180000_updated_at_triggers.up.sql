@@ -0,0 +1,15 @@
+-- updated_at also moves when a row is changed outside the application, e.g. by hand or by ON UPDATE CASCADE.
+-- An UPDATE that sets updated_at itself, as the application does, keeps its value
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at THEN
+        NEW.updated_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_touch_updated_at BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
+CREATE TRIGGER subscriptions_touch_updated_at BEFORE UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
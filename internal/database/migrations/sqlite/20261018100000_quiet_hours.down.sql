@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS deferred_notifications;
+
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN quiet_hours_start;
+ALTER TABLE users DROP COLUMN quiet_hours_end;
+ALTER TABLE users DROP COLUMN quiet_hours_policy;
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN quiet_hours_start VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN quiet_hours_end VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN quiet_hours_policy VARCHAR(16) NOT NULL DEFAULT 'defer';
+
+CREATE TABLE IF NOT EXISTS deferred_notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    subscription_id INTEGER NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    deliver_after DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_deferred_notifications_deliver_after ON deferred_notifications (deliver_after);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN digest_mode BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions DROP COLUMN active;
+ALTER TABLE subscriptions DROP COLUMN paused_until;
+ALTER TABLE subscriptions DROP COLUMN expires_at;
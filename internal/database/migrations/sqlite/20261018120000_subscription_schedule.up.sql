@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE subscriptions ADD COLUMN paused_until DATETIME;
+ALTER TABLE subscriptions ADD COLUMN expires_at DATETIME;
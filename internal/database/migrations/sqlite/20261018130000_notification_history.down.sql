@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_notifications_subscription_sent_at;
+DROP INDEX IF EXISTS idx_notifications_user_sent_at;
+
+ALTER TABLE deferred_notifications DROP COLUMN weather_snapshot;
+
+ALTER TABLE notifications DROP COLUMN channel;
+ALTER TABLE notifications DROP COLUMN provider_message_id;
+ALTER TABLE notifications DROP COLUMN weather_snapshot;
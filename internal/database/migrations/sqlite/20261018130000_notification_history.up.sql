@@ -0,0 +1,8 @@
+ALTER TABLE notifications ADD COLUMN channel VARCHAR(32) NOT NULL DEFAULT 'email';
+ALTER TABLE notifications ADD COLUMN provider_message_id VARCHAR(255);
+ALTER TABLE notifications ADD COLUMN weather_snapshot TEXT;
+
+ALTER TABLE deferred_notifications ADD COLUMN weather_snapshot TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_sent_at ON notifications (user_id, sent_at);
+CREATE INDEX IF NOT EXISTS idx_notifications_subscription_sent_at ON notifications (subscription_id, sent_at);
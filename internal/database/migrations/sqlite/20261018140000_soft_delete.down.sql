@@ -0,0 +1,29 @@
+-- Soft-deleted rows are purged, the old schema has no way to hide them
+DELETE FROM notifications WHERE subscription_id IN (SELECT id FROM subscriptions WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+DELETE FROM deferred_notifications WHERE subscription_id IN (SELECT id FROM subscriptions WHERE deleted_at IS NOT NULL)
+    OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+DELETE FROM subscriptions WHERE deleted_at IS NOT NULL OR user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_subscriptions_deleted_at;
+ALTER TABLE subscriptions DROP COLUMN created_at;
+ALTER TABLE subscriptions DROP COLUMN updated_at;
+ALTER TABLE subscriptions DROP COLUMN deleted_at;
+
+CREATE TABLE users_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    quiet_hours_start VARCHAR(5) NOT NULL DEFAULT '',
+    quiet_hours_end VARCHAR(5) NOT NULL DEFAULT '',
+    quiet_hours_policy VARCHAR(16) NOT NULL DEFAULT 'defer',
+    digest_mode BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+INSERT INTO users_old (id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode)
+    SELECT id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
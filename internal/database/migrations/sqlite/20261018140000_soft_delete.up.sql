@@ -0,0 +1,35 @@
+-- SQLite cannot drop the UNIQUE constraint on users.email, so the table is rebuilt.
+-- Migrations run with foreign keys off, dropping the old table does not cascade to subscriptions
+CREATE TABLE users_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    quiet_hours_start VARCHAR(5) NOT NULL DEFAULT '',
+    quiet_hours_end VARCHAR(5) NOT NULL DEFAULT '',
+    quiet_hours_policy VARCHAR(16) NOT NULL DEFAULT 'defer',
+    digest_mode BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    deleted_at DATETIME
+);
+
+INSERT INTO users_new (id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode)
+    SELECT id, name, email, timezone, quiet_hours_start, quiet_hours_end, quiet_hours_policy, digest_mode FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+-- A deleted user keeps its row until it is purged, so only users that are not deleted need unique emails
+CREATE UNIQUE INDEX idx_users_email_active ON users (email) WHERE deleted_at IS NULL;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- ADD COLUMN only accepts constant defaults, existing rows get the migration time instead
+ALTER TABLE subscriptions ADD COLUMN created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+ALTER TABLE subscriptions ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00+00:00';
+ALTER TABLE subscriptions ADD COLUMN deleted_at DATETIME;
+UPDATE subscriptions SET
+    created_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'),
+    updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now');
+
+CREATE INDEX idx_subscriptions_deleted_at ON subscriptions (deleted_at) WHERE deleted_at IS NOT NULL;
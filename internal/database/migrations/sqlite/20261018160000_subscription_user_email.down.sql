@@ -0,0 +1,22 @@
+CREATE TABLE subscriptions_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    city VARCHAR(255) NOT NULL,
+    condition VARCHAR(255) NOT NULL,
+    user_email VARCHAR(255) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    paused_until DATETIME,
+    expires_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    deleted_at DATETIME
+);
+
+INSERT INTO subscriptions_old (id, user_id, city, condition, user_email, active, paused_until, expires_at, created_at, updated_at, deleted_at)
+    SELECT id, user_id, city, condition, user_email, active, paused_until, expires_at, created_at, updated_at, deleted_at FROM subscriptions;
+
+DROP TABLE subscriptions;
+ALTER TABLE subscriptions_old RENAME TO subscriptions;
+
+CREATE INDEX idx_subscriptions_deleted_at ON subscriptions (deleted_at) WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_users_id_email;
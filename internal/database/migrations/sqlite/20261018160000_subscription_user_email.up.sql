@@ -0,0 +1,31 @@
+-- subscriptions.user_email is a copy of the user's email that used to go stale when the email changed
+UPDATE subscriptions SET user_email = (SELECT email FROM users WHERE users.id = subscriptions.user_id)
+    WHERE user_email <> (SELECT email FROM users WHERE users.id = subscriptions.user_id);
+
+-- The copy follows the user's email through a foreign key, so it cannot diverge again.
+-- SQLite cannot add a foreign key to an existing table, so the table is rebuilt.
+-- Migrations run with foreign keys off, dropping the old table does not cascade to notifications
+CREATE UNIQUE INDEX idx_users_id_email ON users (id, email);
+
+CREATE TABLE subscriptions_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    city VARCHAR(255) NOT NULL,
+    condition VARCHAR(255) NOT NULL,
+    user_email VARCHAR(255) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    paused_until DATETIME,
+    expires_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    deleted_at DATETIME,
+    FOREIGN KEY (user_id, user_email) REFERENCES users (id, email) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO subscriptions_new (id, user_id, city, condition, user_email, active, paused_until, expires_at, created_at, updated_at, deleted_at)
+    SELECT id, user_id, city, condition, user_email, active, paused_until, expires_at, created_at, updated_at, deleted_at FROM subscriptions;
+
+DROP TABLE subscriptions;
+ALTER TABLE subscriptions_new RENAME TO subscriptions;
+
+CREATE INDEX idx_subscriptions_deleted_at ON subscriptions (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_subscriptions_city;
+DROP INDEX IF EXISTS idx_subscriptions_user_id;
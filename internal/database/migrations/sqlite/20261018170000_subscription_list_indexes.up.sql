@@ -0,0 +1,3 @@
+-- Subscription lists are paged through in ID order, within a user or a city when filtered by them
+CREATE INDEX IF NOT EXISTS idx_subscriptions_user_id ON subscriptions (user_id, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_city ON subscriptions (LOWER(city), id) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS subscriptions_touch_updated_at;
+DROP TRIGGER IF EXISTS users_touch_updated_at;
@@ -0,0 +1,14 @@
+-- updated_at also moves when a row is changed outside the application, e.g. by hand or by ON UPDATE CASCADE.
+-- An UPDATE that sets updated_at itself, as the application does, keeps its value.
+-- SQLite has no BEFORE UPDATE assignment, so the row is updated again; that update changes updated_at and does not recurse
+CREATE TRIGGER users_touch_updated_at AFTER UPDATE ON users
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER subscriptions_touch_updated_at AFTER UPDATE ON subscriptions
+    FOR EACH ROW WHEN NEW.updated_at IS OLD.updated_at
+BEGIN
+    UPDATE subscriptions SET updated_at = strftime('%Y-%m-%d %H:%M:%f+00:00', 'now') WHERE id = NEW.id;
+END;
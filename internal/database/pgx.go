@@ -0,0 +1,102 @@
+// internal/database/pgx.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"maxcool.com/weatherapp/internal/tracing"
+)
+
+// errLastInsertID is returned by the result of a native statement, PostgreSQL returns IDs with RETURNING instead
+var errLastInsertID = errors.New("LastInsertId is not supported by PostgreSQL")
+
+// pgxConn runs statements natively; it is implemented by both *pgxpool.Pool and pgx.Tx
+type pgxConn interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// pgxQuerier runs the statements of the repository on the pgx pool or a pgx transaction,
+// with the results shaped like those of database/sql so every IDB method works the same with both drivers
+type pgxQuerier struct {
+	conn pgxConn
+}
+
+func (q pgxQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	tag, err := q.conn.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxResult{tag}, nil
+}
+
+func (q pgxQuerier) QueryContext(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := q.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxRows{r}, nil
+}
+
+func (q pgxQuerier) QueryRowContext(ctx context.Context, query string, args ...any) row {
+	return pgxRow{q.conn.QueryRow(ctx, query, args...)}
+}
+
+// pgxResult reports the rows affected by a native statement
+type pgxResult struct {
+	tag pgconn.CommandTag
+}
+
+func (r pgxResult) LastInsertId() (int64, error) {
+	return 0, errLastInsertID
+}
+
+func (r pgxResult) RowsAffected() (int64, error) {
+	return r.tag.RowsAffected(), nil
+}
+
+// pgxRows is a native result set with the Close of *sql.Rows
+type pgxRows struct {
+	pgx.Rows
+}
+
+func (r pgxRows) Close() error {
+	r.Rows.Close()
+	return nil
+}
+
+// pgxRow is a native single row result that reports a missing row with sql.ErrNoRows, as *sql.Row does
+type pgxRow struct {
+	pgx.Row
+}
+
+func (r pgxRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// queryTracer gives every native query a span carrying the SQL statement, as otelsql does for database/sql
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracing.Start(ctx, "pgx.query", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBQueryText(data.SQL)))
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	err := data.Err
+	tracing.End(trace.SpanFromContext(ctx), &err)
+}
@@ -0,0 +1,94 @@
+// internal/database/sqlite.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	_ "modernc.org/sqlite"
+
+	"maxcool.com/weatherapp/internal/config"
+)
+
+// sqliteOptions are added to every SQLite connection string:
+// foreign keys enforce the same cascades as PostgreSQL, WAL lets readers work while a transaction writes,
+// immediate transactions take the write lock up front so concurrent transactions wait instead of failing,
+// and times are written in a format SQLite itself understands
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate&_time_format=sqlite"
+
+// newSQLiteDB opens the SQLite database file named by a sqlite:// connection string
+// The file is created if it does not exist yet
+func newSQLiteDB(cfg *config.Config) (*DB, error) {
+	dsn := withQueryParam(cfg.PostgresConnectionString[len(config.SQLiteScheme):], sqliteOptions)
+
+	db, err := otelsql.Open("sqlite", dsn,
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open SQLite database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database ping failed: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+
+	return &DB{SQL: db, sqlite: true}, nil
+}
+
+// withQueryParam appends a query parameter to a connection string
+func withQueryParam(connectionString, param string) string {
+	if strings.Contains(connectionString, "?") {
+		return connectionString + "&" + param
+	}
+	return connectionString + "?" + param
+}
+
+// utcQuerier converts time arguments to UTC before passing them to SQLite
+// SQLite stores times as text, so only times in the same zone compare and sort correctly
+type utcQuerier struct {
+	querier
+}
+
+func (q utcQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return q.querier.ExecContext(ctx, query, utcArgs(args)...)
+}
+
+func (q utcQuerier) QueryContext(ctx context.Context, query string, args ...any) (rows, error) {
+	return q.querier.QueryContext(ctx, query, utcArgs(args)...)
+}
+
+func (q utcQuerier) QueryRowContext(ctx context.Context, query string, args ...any) row {
+	return q.querier.QueryRowContext(ctx, query, utcArgs(args)...)
+}
+
+// utcArgs returns args with every time.Time and non-nil *time.Time converted to UTC
+func utcArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				converted[i] = v.UTC()
+			} else {
+				converted[i] = v
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
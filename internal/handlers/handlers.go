@@ -1,32 +1,88 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
+	"github.com/gorilla/mux"
+	"maxcool.com/weatherapp/internal/audit"
 	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/health"
 	"maxcool.com/weatherapp/internal/models"
 	"maxcool.com/weatherapp/internal/services"
 )
 
-var Validator *validator.Validate = validator.New()
+var Validator *validator.Validate = newValidator()
+
+// newValidator creates the validator used for request payloads
+// Errors name fields by their JSON name, and the "condition" tag checks the condition syntax
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	if err := v.RegisterValidation("condition", func(fl validator.FieldLevel) bool {
+		return services.ValidateCondition(fl.Field().String()) == nil
+	}); err != nil {
+		panic(err)
+	}
+	return v
+}
 
 // Validate validates the given struct using the validator
-// It returns an error if validation fails
+// It returns an error if validation fails, describing every invalid field
 func Validate(i any) error {
 	err := Validator.Struct(i)
 	if err != nil {
-		for _, err := range err.(validator.ValidationErrors) {
-			log.Printf("Validation error: %s", err)
+		var fieldErrors validator.ValidationErrors
+		if !errors.As(err, &fieldErrors) {
+			return fmt.Errorf("validation failed: %w", err)
+		}
+		problems := make([]string, 0, len(fieldErrors))
+		for _, fieldError := range fieldErrors {
+			slog.Debug("Validation error", "error", fieldError)
+			problems = append(problems, fieldProblem(fieldError))
 		}
-		return fmt.Errorf("validation failed: %w", err)
+		return fmt.Errorf("validation failed: %s", strings.Join(problems, "; "))
 	}
 	return nil
 }
 
+// fieldProblem describes a failed validation rule in terms a client can act on
+func fieldProblem(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "email":
+		return fe.Field() + " must be a valid email address"
+	case "max":
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "condition":
+		if err := services.ValidateCondition(fmt.Sprint(fe.Value())); err != nil {
+			return fmt.Sprintf("%s is invalid: %v", fe.Field(), err)
+		}
+	}
+	return fmt.Sprintf("%s is invalid (%s)", fe.Field(), fe.Tag())
+}
+
 // SendJsonResponse sends a JSON response with the given status code and data
 // It sets the Content-Type header to application/json and encodes the data into JSON format
 // If encoding fails, it logs the error and sends an internal server error response
@@ -34,7 +90,7 @@ func SendJsonResponse(w http.ResponseWriter, statusCode int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+		slog.Error("Error encoding JSON response", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
@@ -43,12 +99,149 @@ func SendJsonResponse(w http.ResponseWriter, statusCode int, data any) {
 type Handler struct {
 	UserService         services.IUserService
 	SubscriptionService services.ISubscriptionService
+	NotificationService services.INotificationService
 	Config              *config.Config
+	Health              *health.Checker // dependency checks of the readiness probe, none if nil
+	AuditService        services.IAuditService
 }
 
 // NewHandler Creates a new Handler instance
-func NewHandler(userServicer services.IUserService, subscriptionService services.ISubscriptionService, config *config.Config) *Handler {
-	return &Handler{UserService: userServicer, SubscriptionService: subscriptionService, Config: config}
+func NewHandler(userServicer services.IUserService, subscriptionService services.ISubscriptionService, notificationService services.INotificationService, config *config.Config) *Handler {
+	return &Handler{UserService: userServicer, SubscriptionService: subscriptionService, NotificationService: notificationService, Config: config}
+}
+
+// parseNotificationFilter reads the limit, offset, from and to query parameters
+// from and to must be RFC 3339 timestamps
+func parseNotificationFilter(r *http.Request) (models.NotificationFilter, error) {
+	query := r.URL.Query()
+	var filter models.NotificationFilter
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", v)
+		}
+		filter.Offset = offset
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %s", v)
+		}
+		filter.From = &from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %s", v)
+		}
+		filter.To = &to
+	}
+
+	return filter, nil
+}
+
+// parseAuditFilter reads the entity_type, entity_id, actor, limit and offset query parameters
+// entity_id is only accepted together with entity_type
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{EntityType: query.Get("entity_type"), Actor: query.Get("actor")}
+
+	switch filter.EntityType {
+	case "", models.AuditEntityUser, models.AuditEntitySubscription:
+	default:
+		return filter, fmt.Errorf("invalid entity_type: %s", filter.EntityType)
+	}
+	if v := query.Get("entity_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return filter, fmt.Errorf("invalid entity_id: %s", v)
+		}
+		if filter.EntityType == "" {
+			return filter, errors.New("entity_id requires entity_type")
+		}
+		filter.EntityId = id
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", v)
+		}
+		filter.Offset = offset
+	}
+
+	return filter, nil
+}
+
+// parseSubscriptionFilter reads the city, active, user_id, limit and cursor query parameters
+// cursor is the next_cursor of the previous page
+func parseSubscriptionFilter(r *http.Request) (models.SubscriptionFilter, error) {
+	query := r.URL.Query()
+	filter := models.SubscriptionFilter{City: query.Get("city")}
+
+	if v := query.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid active: %s", v)
+		}
+		filter.Active = &active
+	}
+	if v := query.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return filter, fmt.Errorf("invalid user_id: %s", v)
+		}
+		filter.UserId = id
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		afterID, err := services.DecodeCursor(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid cursor: %s", v)
+		}
+		filter.AfterId = afterID
+	}
+
+	return filter, nil
+}
+
+// RequireAdmin only lets requests with the ADMIN_TOKEN bearer token through to next
+// Without a configured token the admin endpoints answer 404 Not Found, as if they did not exist
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.Config == nil || h.Config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.Config.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			slog.WarnContext(r.Context(), "Admin request rejected", "path", r.URL.Path)
+			return
+		}
+		next(w, r.WithContext(audit.WithActor(r.Context(), audit.AdminActor)))
+	}
 }
 
 // --- Endpoints ---
@@ -60,24 +253,24 @@ func (h *Handler) GetWeatherHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the city parameter
 	if city == "" || len(city) == 0 {
 		http.Error(w, "City parameter is required", http.StatusBadRequest)
-		log.Println("City parameter is required")
+		slog.WarnContext(r.Context(), "City parameter is required")
 		return
 	}
 
-	if f, err := h.SubscriptionService.CheckWhetherCityExists(city); err == nil && !f {
+	if f, err := h.SubscriptionService.CheckWhetherCityExists(r.Context(), city); err == nil && !f {
 		http.Error(w, "City not found", http.StatusNotFound)
-		log.Println("City not found: ", city)
+		slog.WarnContext(r.Context(), "City not found", "city", city)
 		return
 	} else {
 		if err != nil {
-			log.Println("Failed to check city existence: ", err)
+			slog.ErrorContext(r.Context(), "Failed to check city existence", "error", err)
 		}
 	}
 
-	weatherResponse, err := h.SubscriptionService.GetWeather(city)
+	weatherResponse, err := h.SubscriptionService.GetWeather(r.Context(), city)
 	if err != nil {
 		http.Error(w, "Failed to fetch weather data", http.StatusInternalServerError)
-		log.Println("Failed to fetch weather data: ", err)
+		slog.ErrorContext(r.Context(), "Failed to fetch weather data", "error", err)
 		return
 	}
 
@@ -94,57 +287,543 @@ func (h *Handler) GetWeatherHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) PostSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
-	var subscription models.Subscription
-	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+	var request models.SubscriptionDto
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("Invalid request payload: ", err)
+		slog.WarnContext(r.Context(), "Invalid request payload", "error", err)
 		return
 	}
 
-	if err := Validate(subscription); err != nil {
-		http.Error(w, "Validation failed", http.StatusBadRequest)
-		log.Println("Validation failed: ", err)
+	if err := Validate(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Validation failed", "error", err)
 		return
 	}
 
-	if f, err := h.SubscriptionService.CheckWhetherCityExists(subscription.City); err == nil && !f {
+	if f, err := h.SubscriptionService.CheckWhetherCityExists(r.Context(), request.City); err == nil && !f {
 		http.Error(w, "City not found", http.StatusNotFound)
-		log.Println("City not found: ", subscription.City)
+		slog.WarnContext(r.Context(), "City not found", "city", request.City)
 		return
 	} else {
 		if err != nil {
-			log.Println("Failed to check city existence: ", err)
+			slog.ErrorContext(r.Context(), "Failed to check city existence", "error", err)
 		}
 	}
 
-	if err := h.SubscriptionService.CreateSubscription(&subscription); err != nil {
+	subscription := request.ToSubscription()
+	if err := h.SubscriptionService.CreateSubscription(r.Context(), &subscription); err != nil {
+		if errors.Is(err, services.ErrInvalidSubscriptionSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid subscription schedule", "error", err)
+			return
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			slog.WarnContext(r.Context(), "User not found", "email", request.Email)
+			return
+		}
 		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
-		log.Println("Failed to create subscription: ", err)
+		slog.ErrorContext(r.Context(), "Failed to create subscription", "error", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/subscriptions/%d", subscription.Id))
+	SendJsonResponse(w, http.StatusCreated, models.NewSubscriptionResponse(&subscription))
+}
+
+func (h *Handler) GetSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription ID", "error", err)
+		return
+	}
+
+	subscription, err := h.SubscriptionService.GetSubscriptionByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get subscription", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get subscription", "error", err)
+		return
+	}
+	if subscription == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "Subscription not found", "subscription_id", id)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, models.NewSubscriptionResponse(subscription))
+}
+
+// GetUserSubscriptionsHandler returns a page of the subscriptions of a user, optionally narrowed down by city and active
+func (h *Handler) GetUserSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	filter, err := parseSubscriptionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription filter", "error", err)
+		return
+	}
+
+	page, err := h.SubscriptionService.ListUserSubscriptions(r.Context(), id, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			slog.WarnContext(r.Context(), "User not found", "user_id", id)
+			return
+		}
+		http.Error(w, "Failed to get subscriptions", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get subscriptions", "error", err)
 		return
 	}
 
-	SendJsonResponse(w, http.StatusCreated, "Subscription created successfully")
+	SendJsonResponse(w, http.StatusOK, page)
 }
 
 func (h *Handler) PostUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var request models.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("Invalid request payload: ", err)
+		slog.WarnContext(r.Context(), "Invalid request payload", "error", err)
 		return
 	}
 
-	if err := Validate(user); err != nil {
-		http.Error(w, "Validation failed", http.StatusBadRequest)
-		log.Println("Validation failed: ", err)
+	if err := Validate(request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Validation failed", "error", err)
 		return
 	}
 
-	if err := h.UserService.CreateUser(&user); err != nil {
+	user := request.ToUser()
+	if err := h.UserService.CreateUser(r.Context(), &user); err != nil {
+		if errors.Is(err, services.ErrInvalidDeliveryPreferences) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid delivery preferences", "error", err)
+			return
+		}
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
-		log.Println("Failed to create user: ", err)
+		slog.ErrorContext(r.Context(), "Failed to create user", "error", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/users/%d", user.Id))
+	SendJsonResponse(w, http.StatusCreated, models.NewUserResponse(&user))
+}
+
+func (h *Handler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	user, err := h.UserService.GetUserByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get user", "error", err)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "User not found", "user_id", id)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, models.NewUserResponse(user))
+}
+
+// DeleteUserHandler deletes a user; with erase=true all personal data of the user is removed for good
+// A plain delete keeps the data until PURGE_RETENTION has passed, an erasure also applies to already deleted users
+func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	erase := false
+	if v := r.URL.Query().Get("erase"); v != "" {
+		if erase, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid erase: "+v, http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid erase parameter", "error", err)
+			return
+		}
+	}
+
+	var found bool
+	if erase {
+		found, err = h.UserService.EraseUser(r.Context(), id)
+	} else {
+		found, err = h.UserService.DeleteUser(r.Context(), id)
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to delete user", "user_id", id, "erase", erase, "error", err)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "User not found", "user_id", id)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Deleted user", "user_id", id, "erase", erase)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUserExportHandler returns the personal data held about a user as a JSON document,
+// or with format=zip as a ZIP archive of profile.json, subscriptions.json and notifications.json
+func (h *Handler) GetUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		http.Error(w, "invalid format: "+format, http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid export format", "format", format)
+		return
+	}
+
+	export, err := h.UserService.ExportUser(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to export user", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to export user", "user_id", id, "error", err)
+		return
+	}
+	if export == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "User not found", "user_id", id)
+		return
+	}
+
+	name := fmt.Sprintf("user-%d-export", id)
+	if format != "zip" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		SendJsonResponse(w, http.StatusOK, export)
+		return
+	}
+
+	archive, err := zipExport(export)
+	if err != nil {
+		http.Error(w, "Failed to export user", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to build export archive", "user_id", id, "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(archive); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write export archive", "user_id", id, "error", err)
+	}
+}
+
+// zipExport packs the parts of an export into a ZIP archive, one JSON file each
+// The archive is built in memory, so a failure can still be answered with an error status
+func zipExport(export *models.UserExport) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range []struct {
+		name string
+		data any
+	}{
+		{"profile.json", map[string]any{"exported_at": export.ExportedAt, "user": export.User}},
+		{"subscriptions.json", export.Subscriptions},
+		{"notifications.json", export.Notifications},
+	} {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", file.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *Handler) PutUserPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	var prefs models.DeliveryPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid request payload", "error", err)
+		return
+	}
+
+	user, err := h.UserService.UpdateDeliveryPreferences(r.Context(), id, &prefs)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDeliveryPreferences) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid delivery preferences", "error", err)
+			return
+		}
+		http.Error(w, "Failed to update preferences", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to update preferences", "error", err)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "User not found", "user_id", id)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, models.NewDeliveryPreferences(user))
+}
+
+func (h *Handler) PostPauseSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription ID", "error", err)
+		return
+	}
+
+	// The body is optional: an empty body pauses the subscription until it is resumed
+	var pause models.PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&pause); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid request payload", "error", err)
+		return
+	}
+
+	subscription, err := h.SubscriptionService.PauseSubscription(r.Context(), id, pause.Until)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSubscriptionSchedule) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid subscription schedule", "error", err)
+			return
+		}
+		if errors.Is(err, services.ErrSubscriptionExpired) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			slog.WarnContext(r.Context(), "Subscription has expired", "subscription_id", id)
+			return
+		}
+		http.Error(w, "Failed to pause subscription", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to pause subscription", "error", err)
+		return
+	}
+	if subscription == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "Subscription not found", "subscription_id", id)
+		return
+	}
+
+	// The email is left out, as pausing only changes the subscription state
+	response := models.NewSubscriptionResponse(subscription)
+	response.Email = ""
+	SendJsonResponse(w, http.StatusOK, response)
+}
+
+func (h *Handler) PostResumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription ID", "error", err)
+		return
+	}
+
+	subscription, err := h.SubscriptionService.ResumeSubscription(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, services.ErrSubscriptionExpired) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			slog.WarnContext(r.Context(), "Cannot resume subscription", "error", err)
+			return
+		}
+		http.Error(w, "Failed to resume subscription", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to resume subscription", "error", err)
+		return
+	}
+	if subscription == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		slog.WarnContext(r.Context(), "Subscription not found", "subscription_id", id)
+		return
+	}
+
+	// The email is left out, as resuming only changes the subscription state
+	response := models.NewSubscriptionResponse(subscription)
+	response.Email = ""
+	SendJsonResponse(w, http.StatusOK, response)
+}
+
+func (h *Handler) GetUserNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid user ID", "error", err)
+		return
+	}
+
+	filter, err := parseNotificationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid notification filter", "error", err)
+		return
+	}
+
+	page, err := h.NotificationService.GetNotificationsByUserID(r.Context(), id, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			slog.WarnContext(r.Context(), "User not found", "user_id", id)
+			return
+		}
+		http.Error(w, "Failed to get notifications", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get notifications", "error", err)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, page)
+}
+
+func (h *Handler) GetSubscriptionNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription ID", "error", err)
+		return
+	}
+
+	filter, err := parseNotificationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid notification filter", "error", err)
+		return
+	}
+
+	page, err := h.NotificationService.GetNotificationsBySubscriptionID(r.Context(), id, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrSubscriptionNotFound) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			slog.WarnContext(r.Context(), "Subscription not found", "subscription_id", id)
+			return
+		}
+		http.Error(w, "Failed to get notifications", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get notifications", "error", err)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, page)
+}
+
+func (h *Handler) PostEvaluateConditionHandler(w http.ResponseWriter, r *http.Request) {
+	var request models.ConditionEvaluationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid request payload", "error", err)
+		return
+	}
+
+	if request.City == "" {
+		http.Error(w, "City is required", http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "City is required")
+		return
+	}
+
+	// Reject malformed conditions before spending a weather API call
+	if err := services.ValidateCondition(request.Condition); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid condition", "error", err)
+		return
+	}
+
+	// A single provider call: an unknown city comes back as ErrCityNotFound
+	evaluation, err := h.SubscriptionService.EvaluateCondition(r.Context(), request.Condition, request.City)
+	if err != nil {
+		if errors.Is(err, services.ErrCityNotFound) {
+			http.Error(w, "City not found", http.StatusNotFound)
+			slog.WarnContext(r.Context(), "City not found", "city", request.City)
+			return
+		}
+		http.Error(w, "Failed to evaluate condition", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to evaluate condition", "error", err)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, evaluation)
+}
+
+// LivezHandler reports that the process is up and able to serve requests
+// It does not check any dependency, so a failing database does not get the process restarted
+func (h *Handler) LivezHandler(w http.ResponseWriter, r *http.Request) {
+	SendJsonResponse(w, http.StatusOK, health.Report{Status: health.StatusOK, Components: []health.ComponentStatus{}})
+}
+
+// ReadyzHandler checks the dependencies and reports the status of each of them
+// It answers 503 Service Unavailable if any dependency is not ready
+func (h *Handler) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := health.Report{Status: health.StatusOK, Components: []health.ComponentStatus{}}
+	if h.Health != nil {
+		report = h.Health.Run(r.Context())
+	}
+
+	if report.Status != health.StatusOK {
+		for _, component := range report.Components {
+			if component.Status != health.StatusOK {
+				slog.WarnContext(r.Context(), "Readiness check failed", "component", component.Name, "error", component.Error)
+			}
+		}
+		SendJsonResponse(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	SendJsonResponse(w, http.StatusOK, report)
+}
+
+// GetAuditEventsHandler returns a page of the audit log, optionally narrowed down to an entity or an actor
+func (h *Handler) GetAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid audit filter", "error", err)
+		return
+	}
+
+	page, err := h.AuditService.GetAuditEvents(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to get audit events", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get audit events", "error", err)
+		return
+	}
+
+	SendJsonResponse(w, http.StatusOK, page)
+}
+
+// GetSubscriptionsHandler returns a page of all subscriptions, optionally narrowed down by city, active and user_id
+func (h *Handler) GetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSubscriptionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "Invalid subscription filter", "error", err)
+		return
+	}
+
+	page, err := h.SubscriptionService.ListSubscriptions(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to get subscriptions", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to get subscriptions", "error", err)
 		return
 	}
 
-	SendJsonResponse(w, http.StatusCreated, "User created successfully")
+	SendJsonResponse(w, http.StatusOK, page)
 }
@@ -0,0 +1,96 @@
+// internal/health/health.go
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a single dependency check of the readiness probe
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// ComponentStatus is the result of one check
+type ComponentStatus struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Report is the result of all checks; Status is ok only if every component is ok
+type Report struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// Checker runs the readiness checks
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewChecker creates a Checker running the given checks, each limited to timeout
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run runs all checks concurrently and reports the status of every component in the order of the checks
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Components: make([]ComponentStatus, len(c.checks))}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Run(checkCtx)
+			status := ComponentStatus{Name: check.Name, Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = StatusFail
+				status.Error = err.Error()
+			}
+			report.Components[i] = status
+		}()
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// Cached wraps a check so its result is reused for ttl
+// It keeps probes from calling rate-limited or paid dependencies on every request
+func Cached(ttl time.Duration, run func(ctx context.Context) error) func(ctx context.Context) error {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		result  error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < ttl {
+			return result
+		}
+		result = run(ctx)
+		checked = time.Now()
+		return result
+	}
+}
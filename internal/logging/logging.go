@@ -0,0 +1,92 @@
+// internal/logging/logging.go
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+	"maxcool.com/weatherapp/internal/config"
+)
+
+var (
+	// level and redactEmails are shared by the default logger, so changing them takes effect immediately
+	level        = new(slog.LevelVar)
+	redactEmails atomic.Bool
+)
+
+// ParseLevel converts a level name (debug, info, warn, error) into a slog.Level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// Setup installs the default logger with the level, format and redaction settings from the configuration
+// Output of the standard log package goes through the same logger at info level
+func Setup(cfg *config.Config) error {
+	if err := Update(cfg); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(NewHandler(os.Stderr, cfg.LogFormat)))
+	return nil
+}
+
+// Update applies the level and redaction settings from the configuration at runtime
+func Update(cfg *config.Config) error {
+	l, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	redactEmails.Store(cfg.LogRedactEmails)
+	return nil
+}
+
+// NewHandler creates a handler writing text or JSON records to w
+// Records are filtered by the shared level, secrets are redacted
+// and the request ID and trace ID found in the context are added to every record
+func NewHandler(w io.Writer, format string) slog.Handler {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if strings.EqualFold(format, "json") {
+		return &contextHandler{slog.NewJSONHandler(w, options)}
+	}
+	return &contextHandler{slog.NewTextHandler(w, options)}
+}
+
+// contextHandler adds the request ID and trace ID carried by the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,139 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds all metrics of the application, together with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// HTTPRequests counts handled HTTP requests by method, route template and status code
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_http_requests_total",
+		Help: "Number of handled HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the time spent handling HTTP requests by method and route template
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weatherapp_http_request_duration_seconds",
+		Help:    "Time spent handling HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// RateLimited counts requests rejected by the rate limiter by method and route template
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_http_requests_rate_limited_total",
+		Help: "Number of HTTP requests rejected because the client exceeded its rate limit.",
+	}, []string{"method", "route"})
+
+	// WeatherRequests counts calls to the weather provider by HTTP status, or "error" if there was no response
+	WeatherRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_weather_api_requests_total",
+		Help: "Number of requests to the weather provider.",
+	}, []string{"status"})
+
+	// WeatherRequestDuration observes the latency of calls to the weather provider
+	WeatherRequestDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "weatherapp_weather_api_request_duration_seconds",
+		Help:    "Latency of requests to the weather provider.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// WeatherCacheLookups counts lookups of the per-run weather cache by result (hit or miss)
+	WeatherCacheLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_weather_cache_lookups_total",
+		Help: "Number of weather lookups during notification runs, by cache result.",
+	}, []string{"result"})
+
+	// NotificationRunDuration observes how long a notification run takes
+	NotificationRunDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "weatherapp_notification_run_duration_seconds",
+		Help:    "Duration of notification runs.",
+		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	// SubscriptionsEvaluated counts subscriptions whose condition was evaluated during notification runs
+	SubscriptionsEvaluated = factory.NewCounter(prometheus.CounterOpts{
+		Name: "weatherapp_subscriptions_evaluated_total",
+		Help: "Number of subscription conditions evaluated.",
+	})
+
+	// SubscriptionsMatched counts subscriptions whose condition was met during notification runs
+	SubscriptionsMatched = factory.NewCounter(prometheus.CounterOpts{
+		Name: "weatherapp_subscriptions_matched_total",
+		Help: "Number of subscription conditions that were met.",
+	})
+
+	// NotificationsSent counts notifications handed to the provider by channel
+	NotificationsSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_notifications_sent_total",
+		Help: "Number of notifications sent.",
+	}, []string{"channel"})
+
+	// NotificationsFailed counts notifications the provider did not accept by channel
+	NotificationsFailed = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherapp_notifications_failed_total",
+		Help: "Number of notifications that failed to send.",
+	}, []string{"channel"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDB exports the connection pool statistics of the database
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware records the request count and duration of every routed request
+// Requests are labelled with the route template (e.g. /users/{id}/notifications) to keep the label set small
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(recorder.status)).Inc()
+	})
+}
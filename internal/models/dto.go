@@ -0,0 +1,136 @@
+// internal/models/dto.go
+package models
+
+import "time"
+
+// UserRequest is the payload for creating a user
+type UserRequest struct {
+	Name             string `json:"name" validate:"required,max=100"`
+	Email            string `json:"email" validate:"required,email,max=254"`
+	Timezone         string `json:"timezone" validate:"max=64"`
+	QuietHoursStart  string `json:"quiet_hours_start"`
+	QuietHoursEnd    string `json:"quiet_hours_end"`
+	QuietHoursPolicy string `json:"quiet_hours_policy" validate:"omitempty,oneof=defer drop"`
+	DigestMode       bool   `json:"digest_mode"`
+}
+
+// ToUser maps the request to a new user
+func (r UserRequest) ToUser() User {
+	return User{
+		Name:             r.Name,
+		Email:            r.Email,
+		Timezone:         r.Timezone,
+		QuietHoursStart:  r.QuietHoursStart,
+		QuietHoursEnd:    r.QuietHoursEnd,
+		QuietHoursPolicy: r.QuietHoursPolicy,
+		DigestMode:       r.DigestMode,
+	}
+}
+
+// UserResponse is a user as returned by the API
+type UserResponse struct {
+	Id               int       `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Timezone         string    `json:"timezone"`
+	QuietHoursStart  string    `json:"quiet_hours_start"`
+	QuietHoursEnd    string    `json:"quiet_hours_end"`
+	QuietHoursPolicy string    `json:"quiet_hours_policy"`
+	DigestMode       bool      `json:"digest_mode"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// NewUserResponse maps a user to its API representation
+func NewUserResponse(u *User) UserResponse {
+	return UserResponse{
+		Id:               u.Id,
+		Name:             u.Name,
+		Email:            u.Email,
+		Timezone:         u.Timezone,
+		QuietHoursStart:  u.QuietHoursStart,
+		QuietHoursEnd:    u.QuietHoursEnd,
+		QuietHoursPolicy: u.QuietHoursPolicy,
+		DigestMode:       u.DigestMode,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+	}
+}
+
+// NewDeliveryPreferences returns the delivery preferences of a user
+func NewDeliveryPreferences(u *User) DeliveryPreferences {
+	return DeliveryPreferences{
+		Timezone:         u.Timezone,
+		QuietHoursStart:  u.QuietHoursStart,
+		QuietHoursEnd:    u.QuietHoursEnd,
+		QuietHoursPolicy: u.QuietHoursPolicy,
+		DigestMode:       u.DigestMode,
+	}
+}
+
+// SubscriptionDto is the payload for creating a subscription
+// The subscription belongs to the user with the given email; its ID, owner and state are set by the server
+type SubscriptionDto struct {
+	Email       string     `json:"email" validate:"required,email,max=254"`
+	City        string     `json:"city" validate:"required,max=100"`
+	Condition   string     `json:"condition" validate:"required,max=500,condition"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// ToSubscription maps the request to a new subscription
+func (d SubscriptionDto) ToSubscription() Subscription {
+	return Subscription{
+		City:        d.City,
+		Condition:   d.Condition,
+		UserEmail:   d.Email,
+		PausedUntil: d.PausedUntil,
+		ExpiresAt:   d.ExpiresAt,
+	}
+}
+
+// SubscriptionResponse is a subscription as returned by the API
+type SubscriptionResponse struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	Email       string     `json:"email,omitempty"`
+	City        string     `json:"city"`
+	Condition   string     `json:"condition"`
+	Active      bool       `json:"active"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// NewSubscriptionResponse maps a subscription to its API representation
+func NewSubscriptionResponse(s *Subscription) SubscriptionResponse {
+	return SubscriptionResponse{
+		Id:          s.Id,
+		UserId:      s.UserId,
+		Email:       s.UserEmail,
+		City:        s.City,
+		Condition:   s.Condition,
+		Active:      s.Active,
+		PausedUntil: s.PausedUntil,
+		ExpiresAt:   s.ExpiresAt,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+	}
+}
+
+// SubscriptionPage is a single page of a subscription list
+// NextCursor is passed as the cursor of the next request and is empty on the last page
+type SubscriptionPage struct {
+	Subscriptions []SubscriptionResponse `json:"subscriptions"`
+	Limit         int                    `json:"limit"`
+	NextCursor    string                 `json:"next_cursor,omitempty"`
+}
+
+// UserExport is the personal data held about a user: the profile, the subscriptions and the notification history
+type UserExport struct {
+	ExportedAt    time.Time              `json:"exported_at"`
+	User          UserResponse           `json:"user"`
+	Subscriptions []SubscriptionResponse `json:"subscriptions"`
+	Notifications []Notification         `json:"notifications"`
+}
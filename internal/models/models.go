@@ -1,27 +1,121 @@
 // internal/models/models.go
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
+// Quiet hours policies decide what happens to a notification that falls inside the user's quiet hours
+const (
+	QuietHoursPolicyDefer = "defer" // deliver at the end of the quiet hours
+	QuietHoursPolicyDrop  = "drop"  // do not deliver at all
+)
+
+// User is an account receiving notifications
+// CreatedAt and UpdatedAt are maintained by the database layer
 type User struct {
-	Id    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Id               int       `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Timezone         string    `json:"timezone"`
+	QuietHoursStart  string    `json:"quiet_hours_start"`
+	QuietHoursEnd    string    `json:"quiet_hours_end"`
+	QuietHoursPolicy string    `json:"quiet_hours_policy"`
+	DigestMode       bool      `json:"digest_mode"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// DeliveryPreferences holds the user's settings that control when notifications are delivered
+type DeliveryPreferences struct {
+	Timezone         string `json:"timezone"`
+	QuietHoursStart  string `json:"quiet_hours_start"`
+	QuietHoursEnd    string `json:"quiet_hours_end"`
+	QuietHoursPolicy string `json:"quiet_hours_policy"`
+	DigestMode       bool   `json:"digest_mode"`
 }
 
+// Subscription is notified only while Active, not paused (PausedUntil in the past or nil)
+// and not expired (ExpiresAt in the future or nil)
+// CreatedAt and UpdatedAt are maintained by the database layer
 type Subscription struct {
-	Id        int    `json:"id"`
-	UserId    int    `json:"user_id"`
-	City      string `json:"city"`
-	Condition string `json:"condition"`
-	UserEmail string `json:"user_email"`
+	Id          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	City        string     `json:"city"`
+	Condition   string     `json:"condition"`
+	UserEmail   string     `json:"user_email"`
+	Active      bool       `json:"active"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// SubscriptionFilter narrows down and pages through subscriptions in ID order, which is their creation order
+// Zero values do not filter; City is matched case-insensitively
+// AfterId is the keyset cursor: only subscriptions with a greater ID are returned
+// Deleted subscriptions are only listed with IncludeDeleted, which the API never sets
+type SubscriptionFilter struct {
+	UserId         int
+	City           string
+	Active         *bool
+	AfterId        int
+	Limit          int
+	IncludeDeleted bool
+}
+
+// PauseRequest is the payload of the pause endpoint; without Until the pause lasts until resumed
+type PauseRequest struct {
+	Until *time.Time `json:"until,omitempty"`
 }
 
+// ChannelEmail is the delivery channel of notifications sent by email
+const ChannelEmail = "email"
+
 type Notification struct {
-	Id             int       `json:"id"`
-	UserId         int       `json:"user_id"`
-	SubscriptionId int       `json:"subscription_id"`
-	SentAt         time.Time `json:"sent_at"`
+	Id                int              `json:"id"`
+	UserId            int              `json:"user_id"`
+	SubscriptionId    int              `json:"subscription_id"`
+	SentAt            time.Time        `json:"sent_at"`
+	Channel           string           `json:"channel"`
+	ProviderMessageId string           `json:"provider_message_id,omitempty"`
+	Weather           *WeatherSnapshot `json:"weather,omitempty"`
+}
+
+// WeatherSnapshot is the weather that triggered a notification
+type WeatherSnapshot struct {
+	Main        string  `json:"main"`
+	Temperature float64 `json:"temperature"`
+	FeelsLike   float64 `json:"feels_like"`
+	Humidity    int     `json:"humidity"`
+}
+
+// NotificationFilter narrows down and paginates the notification history
+// From and To are inclusive bounds on SentAt and are ignored when nil
+type NotificationFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// NotificationPage is a single page of the notification history
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	Limit         int            `json:"limit"`
+	Offset        int            `json:"offset"`
+}
+
+// DeferredNotification is a notification held back by quiet hours until DeliverAfter
+type DeferredNotification struct {
+	Id             int              `json:"id"`
+	UserId         int              `json:"user_id"`
+	SubscriptionId int              `json:"subscription_id"`
+	Subject        string           `json:"subject"`
+	Body           string           `json:"body"`
+	DeliverAfter   time.Time        `json:"deliver_after"`
+	Weather        *WeatherSnapshot `json:"weather,omitempty"`
 }
 
 type WeatherResponse struct {
@@ -40,8 +134,85 @@ type WeatherResponse struct {
 	} `json:"main"`
 }
 
-type SubscriptionDto struct {
-	Email     string `json:"email"`
-	City      string `json:"city"`
+// ParsedCondition is the structured form of a condition string
+// Numeric conditions use Value, "main" conditions compare the weather description with Main
+type ParsedCondition struct {
+	Property string   `json:"property"`
+	Operator string   `json:"operator"`
+	Value    *float64 `json:"value,omitempty"`
+	Main     string   `json:"main,omitempty"`
+}
+
+// ExpressionValue is the value a sub-expression of a condition evaluated to
+type ExpressionValue struct {
+	Expression string `json:"expression"`
+	Value      any    `json:"value"`
+}
+
+type ConditionEvaluationRequest struct {
 	Condition string `json:"condition"`
+	City      string `json:"city"`
+}
+
+// ConditionEvaluation explains how a condition evaluates against the current weather in a city
+type ConditionEvaluation struct {
+	Condition string            `json:"condition"`
+	City      string            `json:"city"`
+	Parsed    *ParsedCondition  `json:"parsed"`
+	Values    []ExpressionValue `json:"values"`
+	Result    bool              `json:"result"`
+	Weather   *WeatherSnapshot  `json:"weather"`
+}
+
+// Audited entity types
+const (
+	AuditEntityUser         = "user"
+	AuditEntitySubscription = "subscription"
+)
+
+// Audited actions
+// Expire and purge are made by the scheduled jobs; export, erase, expire and purge events record no states,
+// and erasing a user clears the states of its earlier events
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionExport = "export"
+	AuditActionErase  = "erase"
+	AuditActionExpire = "expire"
+	AuditActionPurge  = "purge"
+)
+
+// AuditActorErased replaces the API client recorded as the actor of the events of an erased user
+const AuditActorErased = "erased"
+
+// AuditEvent records a change of a user or subscription, or an export of a user's data
+// Before is null for created entities and After is null for deleted ones
+type AuditEvent struct {
+	Id         int             `json:"id"`
+	EntityType string          `json:"entity_type"`
+	EntityId   int             `json:"entity_id"`
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestId  string          `json:"request_id,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows down and paginates the audit log
+// Empty fields are ignored; EntityId is only used together with EntityType
+type AuditFilter struct {
+	EntityType string
+	EntityId   int
+	Actor      string
+	Limit      int
+	Offset     int
+}
+
+// AuditPage is a single page of the audit log
+type AuditPage struct {
+	Events []AuditEvent `json:"events"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
 }
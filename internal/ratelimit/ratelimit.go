@@ -0,0 +1,200 @@
+// internal/ratelimit/ratelimit.go
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/metrics"
+)
+
+// APIKeyHeader identifies a client independently of its IP address
+const APIKeyHeader = "X-API-Key"
+
+// idleTimeout is how long an unused bucket is kept before it is dropped
+const idleTimeout = 10 * time.Minute
+
+// bucket is the token bucket of one client on one route
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter throttles requests with a token bucket per route and client
+// Clients are identified by their API key when they send a configured one, otherwise by their IP address
+type Limiter struct {
+	defaultLimit config.RateLimit
+	routes       map[string]config.RateLimit
+	clients      *Clients
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a Limiter with the limits from the configuration
+func New(cfg *config.Config) (*Limiter, error) {
+	defaultLimit, err := config.ParseRateLimit(cfg.RateLimitDefault)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default rate limit: %w", err)
+	}
+	routes, err := cfg.RouteRateLimits()
+	if err != nil {
+		return nil, fmt.Errorf("invalid route rate limits: %w", err)
+	}
+	return &Limiter{
+		defaultLimit: defaultLimit,
+		routes:       routes,
+		clients:      NewClients(cfg),
+		buckets:      map[string]*bucket{},
+	}, nil
+}
+
+// Middleware rejects requests over the limit of their route with 429 Too Many Requests
+// Limited routes get RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers,
+// and rejected requests also get Retry-After
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		limit := l.limitFor(r.Method, route)
+		if limit.Unlimited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		key, _ := l.clients.Identify(r)
+		lim := l.bucketFor(r.Method+" "+route+" "+key, limit, now)
+		reservation := lim.ReserveN(now, 1)
+		delay := reservation.DelayFrom(now)
+		if delay > 0 {
+			reservation.CancelAt(now)
+		}
+
+		tokens := lim.TokensAt(now)
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(max(0, int(math.Floor(tokens)))))
+		header.Set("RateLimit-Reset", strconv.Itoa(seconds(time.Duration((float64(limit.Requests)-tokens)/float64(lim.Limit())*float64(time.Second)))))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Period)))
+
+		if delay > 0 {
+			header.Set("Retry-After", strconv.Itoa(seconds(delay)))
+			metrics.RateLimited.WithLabelValues(r.Method, route).Inc()
+			slog.DebugContext(r.Context(), "Rate limit exceeded", "method", r.Method, "route", route)
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitFor returns the limit configured for the route, or the default limit
+func (l *Limiter) limitFor(method, route string) config.RateLimit {
+	if limit, ok := l.routes[method+" "+route]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// bucketFor returns the limiter stored under key, creating it if needed
+// Buckets that were not used for idleTimeout are dropped, at most once per idleTimeout
+func (l *Limiter) bucketFor(key string, limit config.RateLimit, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		every := rate.Every(limit.Period / time.Duration(limit.Requests))
+		b = &bucket{limiter: rate.NewLimiter(every, limit.Requests)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// Clients identifies the client of a request by its API key or its IP address
+// Only the keys from API_KEYS count: a made-up key would otherwise give a client
+// a fresh bucket on every request, so requests with other keys are identified by IP address
+type Clients struct {
+	trustProxy bool
+	apiKeys    map[[sha256.Size]byte]bool // SHA-256 of the accepted keys
+}
+
+// NewClients creates a Clients with the API keys and proxy setting from the configuration
+// A nil configuration accepts no API keys and does not trust proxies
+func NewClients(cfg *config.Config) *Clients {
+	c := &Clients{apiKeys: map[[sha256.Size]byte]bool{}}
+	if cfg == nil {
+		return c
+	}
+	c.trustProxy = cfg.RateLimitTrustProxy
+	for _, key := range cfg.APIKeyList() {
+		c.apiKeys[sha256.Sum256([]byte(key))] = true
+	}
+	return c
+}
+
+// Identify returns "key:<hash of the API key>" for a request with an accepted API key,
+// which is then authenticated, and "ip:<address>" for any other request
+// The IP address is taken from X-Forwarded-For only when the proxy is trusted
+func (c *Clients) Identify(r *http.Request) (key string, authenticated bool) {
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		// Looking up the hash keeps the comparison from leaking how much of a key is right
+		if sum := sha256.Sum256([]byte(apiKey)); c.apiKeys[sum] {
+			return "key:" + hex.EncodeToString(sum[:8]), true
+		}
+	}
+	return "ip:" + clientIP(r, c.trustProxy), false
+}
+
+// clientIP returns the address of the client, taken from X-Forwarded-For only when the proxy is trusted
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// routeTemplate returns the path template of the matched route, or the request path
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// seconds rounds a duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
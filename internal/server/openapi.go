@@ -0,0 +1,29 @@
+// internal/server/openapi.go
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpec is the OpenAPI 3 description of the routes registered by NewRouter
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// docsPage renders OpenAPISpec in the browser without loading anything from other sites
+//
+//go:embed docs.html
+var docsPage []byte
+
+// openAPIHandler serves the OpenAPI document
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(OpenAPISpec)
+}
+
+// docsHandler serves the API documentation page
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(docsPage)
+}
@@ -3,39 +3,74 @@ package server
 
 import (
 	"net/http"
-	"time"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"maxcool.com/weatherapp/internal/audit"
+	"maxcool.com/weatherapp/internal/config"
 	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/metrics"
+	"maxcool.com/weatherapp/internal/ratelimit"
 )
 
 // NewRouter creates and configures the mux router
-func NewRouter(handler *handlers.Handler) *mux.Router {
+// Requests are throttled by limiter unless it is nil
+func NewRouter(handler *handlers.Handler, limiter *ratelimit.Limiter) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(otelmux.Middleware("weatherapp"), logging.Middleware, audit.Middleware(ratelimit.NewClients(handler.Config)), metrics.Middleware)
+	if limiter != nil {
+		r.Use(limiter.Middleware)
+	}
+	// Requests that do not match openapi.json are rejected before they reach the handlers
+	r.Use(validateRequests)
 
 	r.HandleFunc("/subscribe", handler.PostSubscriptionHandler).Methods("POST")
 
 	r.HandleFunc("/weather", handler.GetWeatherHandler).Methods("GET")
 
+	r.HandleFunc("/conditions/evaluate", handler.PostEvaluateConditionHandler).Methods("POST")
+
 	r.HandleFunc("/user", handler.PostUserHandler).Methods("POST")
 
-	// Add a simple health check endpoint
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
+	// Admin endpoints, only available with an ADMIN_TOKEN
+	// Every route addressing a user or a subscription by ID is among them, as the IDs are sequential
+	r.HandleFunc("/users/{id:[0-9]+}", handler.RequireAdmin(handler.GetUserHandler)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id:[0-9]+}", handler.RequireAdmin(handler.GetSubscriptionHandler)).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/subscriptions", handler.RequireAdmin(handler.GetUserSubscriptionsHandler)).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/preferences", handler.RequireAdmin(handler.PutUserPreferencesHandler)).Methods("PUT")
+	r.HandleFunc("/subscriptions/{id:[0-9]+}/pause", handler.RequireAdmin(handler.PostPauseSubscriptionHandler)).Methods("POST")
+	r.HandleFunc("/subscriptions/{id:[0-9]+}/resume", handler.RequireAdmin(handler.PostResumeSubscriptionHandler)).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}/notifications", handler.RequireAdmin(handler.GetUserNotificationsHandler)).Methods("GET")
+	r.HandleFunc("/subscriptions/{id:[0-9]+}/notifications", handler.RequireAdmin(handler.GetSubscriptionNotificationsHandler)).Methods("GET")
+	r.HandleFunc("/admin/audit", handler.RequireAdmin(handler.GetAuditEventsHandler)).Methods("GET")
+	r.HandleFunc("/admin/subscriptions", handler.RequireAdmin(handler.GetSubscriptionsHandler)).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}", handler.RequireAdmin(handler.DeleteUserHandler)).Methods("DELETE")
+	r.HandleFunc("/users/{id:[0-9]+}/export", handler.RequireAdmin(handler.GetUserExportHandler)).Methods("GET")
+
+	// Probes: liveness only tells that the process runs, readiness checks the dependencies
+	r.HandleFunc("/livez", handler.LivezHandler).Methods("GET")
+	r.HandleFunc("/readyz", handler.ReadyzHandler).Methods("GET")
+	r.HandleFunc("/health", handler.LivezHandler).Methods("GET") // kept for existing deployments
+
+	// Expose metrics for Prometheus
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	// API description; keep openapi.json in sync when adding or changing routes
+	r.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	r.HandleFunc("/docs", docsHandler).Methods("GET")
 
 	return r
 }
 
-// NewServer creates a custom http.Server
-func NewServer(addr string, handler http.Handler) *http.Server {
+// NewServer creates a custom http.Server with the timeouts from the configuration
+func NewServer(cfg *config.Config, handler http.Handler) *http.Server {
 	srv := &http.Server{
-		Addr:         addr,
+		Addr:         ":" + cfg.ServerPort,
 		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
 	}
 	return srv
 }
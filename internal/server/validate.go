@@ -0,0 +1,409 @@
+// internal/server/validate.go
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"maxcool.com/weatherapp/internal/handlers"
+)
+
+// specSchema is the part of an OpenAPI schema object the request validation understands
+type specSchema struct {
+	Ref        string                 `json:"$ref"`
+	Type       string                 `json:"type"`
+	Format     string                 `json:"format"`
+	Enum       []any                  `json:"enum"`
+	Required   []string               `json:"required"`
+	Properties map[string]*specSchema `json:"properties"`
+	MaxLength  *int                   `json:"maxLength"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+
+	unsupported []string // keywords of the schema the validation would ignore
+}
+
+// supportedKeywords are the schema keywords the request validation enforces, plus annotations without effect on it
+var supportedKeywords = map[string]bool{
+	"$ref": true, "type": true, "format": true, "enum": true, "required": true, "properties": true,
+	"maxLength": true, "minimum": true, "maximum": true,
+	"description": true, "example": true, "default": true,
+}
+
+// supportedTypes and supportedFormats are the values of type and format checkValue enforces
+var (
+	supportedTypes   = map[string]bool{"": true, "object": true, "string": true, "integer": true, "number": true, "boolean": true}
+	supportedFormats = map[string]bool{"": true, "email": true, "date-time": true}
+)
+
+// UnmarshalJSON decodes a schema and notes the keywords it holds that the validation does not understand
+func (s *specSchema) UnmarshalJSON(data []byte) error {
+	type plain specSchema
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return err
+	}
+	for keyword := range keywords {
+		if !supportedKeywords[keyword] {
+			s.unsupported = append(s.unsupported, keyword)
+		}
+	}
+	slices.Sort(s.unsupported)
+	return nil
+}
+
+type specParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"`
+	Required bool        `json:"required"`
+	Schema   *specSchema `json:"schema"`
+}
+
+type specOperation struct {
+	Parameters  []specParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *specSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type specDocument struct {
+	Paths      map[string]map[string]specOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*specSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// routeVariable matches a path variable with a pattern, e.g. {id:[0-9]+}
+var routeVariable = regexp.MustCompile(`\{(\w+):[^}]+\}`)
+
+// requestValidator checks the parameters and JSON bodies of requests against their operation in the OpenAPI document
+type requestValidator struct {
+	operations map[string]specOperation // keyed by "METHOD /path/{variable}"
+	schemas    map[string]*specSchema
+}
+
+// maxRequestBody is the largest request body accepted, larger ones are answered with 413 Request Entity Too Large
+const maxRequestBody = 1 << 20
+
+// specValidator validates requests against OpenAPISpec
+var specValidator = mustRequestValidator(OpenAPISpec)
+
+// mustRequestValidator creates a requestValidator for an OpenAPI document and panics if it cannot be parsed
+// or describes requests with something the validation would not enforce
+func mustRequestValidator(spec []byte) *requestValidator {
+	v, err := newRequestValidator(spec)
+	if err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI document: %v", err))
+	}
+	return v
+}
+
+// CheckOpenAPISpec returns an error if the request validation cannot enforce an OpenAPI document,
+// e.g. because a request schema uses a keyword it does not understand
+func CheckOpenAPISpec(spec []byte) error {
+	_, err := newRequestValidator(spec)
+	return err
+}
+
+func newRequestValidator(spec []byte) (*requestValidator, error) {
+	var doc specDocument
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	v := &requestValidator{operations: map[string]specOperation{}, schemas: doc.Components.Schemas}
+	var problems []string
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			key := strings.ToUpper(method) + " " + path
+			v.operations[key] = operation
+			for _, parameter := range operation.Parameters {
+				if parameter.In != "path" && parameter.In != "query" {
+					problems = append(problems, fmt.Sprintf("%s: parameter %s is in %s", key, parameter.Name, parameter.In))
+				}
+				problems = append(problems, v.unsupported(key+": parameter "+parameter.Name, parameter.Schema, map[string]bool{})...)
+			}
+			if operation.RequestBody != nil {
+				for contentType, content := range operation.RequestBody.Content {
+					if contentType != "application/json" {
+						problems = append(problems, fmt.Sprintf("%s: request body of type %s", key, contentType))
+					}
+					problems = append(problems, v.unsupported(key+": request body", content.Schema, map[string]bool{})...)
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return nil, fmt.Errorf("request validation does not support %s", strings.Join(problems, "; "))
+	}
+	return v, nil
+}
+
+// unsupported returns what a request schema uses that checkValue would silently ignore
+// seen holds the referenced schemas already walked
+func (v *requestValidator) unsupported(where string, schema *specSchema, seen map[string]bool) []string {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if _, ok := v.schemas[name]; !ok {
+			return []string{fmt.Sprintf("%s: unknown reference %s", where, schema.Ref)}
+		}
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		where, schema = name, v.schemas[name]
+	}
+
+	var problems []string
+	for _, keyword := range schema.unsupported {
+		problems = append(problems, fmt.Sprintf("%s: keyword %s", where, keyword))
+	}
+	if !supportedTypes[schema.Type] {
+		problems = append(problems, fmt.Sprintf("%s: type %s", where, schema.Type))
+	}
+	if !supportedFormats[schema.Format] {
+		problems = append(problems, fmt.Sprintf("%s: format %s", where, schema.Format))
+	}
+	for field, property := range schema.Properties {
+		problems = append(problems, v.unsupported(fieldName(where, field), property, seen)...)
+	}
+	return problems
+}
+
+// validateRequests rejects requests that do not match the OpenAPI document with 400 Bad Request,
+// listing every problem like the handlers' own validation does
+// Requests with a body that is not JSON are left to the handlers, which answer them with their own error
+// Bodies over maxRequestBody are rejected with 413 Request Entity Too Large
+func validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+		problems, err := specValidator.check(r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			slog.WarnContext(r.Context(), "Request body too large", "limit", tooLarge.Limit)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Failed to read request body", "error", err)
+			return
+		}
+		if len(problems) > 0 {
+			http.Error(w, "validation failed: "+strings.Join(problems, "; "), http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Request does not match the API description", "problems", problems)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check returns the problems of the request, or none if it matches its operation or has no documented operation
+// The body is read and put back, so the handler can still decode it
+func (v *requestValidator) check(r *http.Request) ([]string, error) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil, nil
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, nil
+	}
+	operation, ok := v.operations[r.Method+" "+routeVariable.ReplaceAllString(template, "{$1}")]
+	if !ok {
+		return nil, nil
+	}
+
+	var problems []string
+	vars, query := mux.Vars(r), r.URL.Query()
+	for _, parameter := range operation.Parameters {
+		raw, present := "", false
+		switch parameter.In {
+		case "path":
+			raw, present = vars[parameter.Name], true
+		case "query":
+			raw, present = query.Get(parameter.Name), query.Has(parameter.Name)
+		default:
+			continue
+		}
+		if !present || raw == "" {
+			if parameter.Required {
+				problems = append(problems, parameter.Name+" is required")
+			}
+			continue
+		}
+		problems = append(problems, v.checkValue(parameter.Name, parameter.Schema, parameterValue(parameter.Schema, raw))...)
+	}
+
+	if operation.RequestBody == nil {
+		return problems, nil
+	}
+	content, ok := operation.RequestBody.Content["application/json"]
+	if !ok {
+		return problems, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if len(bytes.TrimSpace(data)) == 0 {
+		if operation.RequestBody.Required {
+			problems = append(problems, "request body is required")
+		}
+		return problems, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var body any
+	if err := decoder.Decode(&body); err != nil {
+		return problems, nil
+	}
+	return append(problems, v.checkValue("", content.Schema, body)...), nil
+}
+
+// parameterValue converts the text of a parameter to the JSON value its schema expects,
+// leaving it a string if it does not convert, so checkValue reports the wrong type
+func parameterValue(schema *specSchema, raw string) any {
+	if schema == nil {
+		return raw
+	}
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	}
+	return raw
+}
+
+// resolve follows a reference to a schema in the components of the document
+func (v *requestValidator) resolve(schema *specSchema) *specSchema {
+	for schema != nil && schema.Ref != "" {
+		schema = v.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// checkValue returns the problems of a value against its schema; name is the field the value belongs to
+// null is accepted for every field, as the handlers treat it like a missing one
+func (v *requestValidator) checkValue(name string, schema *specSchema, value any) []string {
+	schema = v.resolve(schema)
+	if schema == nil || value == nil {
+		return nil
+	}
+	subject := name
+	if subject == "" {
+		subject = "request body"
+	}
+
+	var problems []string
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{subject + " must be a JSON object"}
+		}
+		for _, field := range schema.Required {
+			if object[field] == nil {
+				problems = append(problems, fieldName(name, field)+" is required")
+			}
+		}
+		fields := make([]string, 0, len(schema.Properties))
+		for field := range schema.Properties {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		for _, field := range fields {
+			problems = append(problems, v.checkValue(fieldName(name, field), schema.Properties[field], object[field])...)
+		}
+		return problems
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{subject + " must be a string"}
+		}
+		if schema.MaxLength != nil && utf8.RuneCountInString(s) > *schema.MaxLength {
+			problems = append(problems, fmt.Sprintf("%s must be at most %d characters", subject, *schema.MaxLength))
+		}
+		switch schema.Format {
+		case "email":
+			if handlers.Validator.Var(s, "email") != nil {
+				problems = append(problems, subject+" must be a valid email address")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				problems = append(problems, subject+" must be an RFC 3339 date-time")
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be %s", subject, article(schema.Type))}
+		}
+		f, err := n.Float64()
+		if err != nil || schema.Type == "integer" && strings.ContainsAny(n.String(), ".eE") {
+			return []string{fmt.Sprintf("%s must be %s", subject, article(schema.Type))}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			problems = append(problems, fmt.Sprintf("%s must be at least %v", subject, *schema.Minimum))
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			problems = append(problems, fmt.Sprintf("%s must be at most %v", subject, *schema.Maximum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{subject + " must be true or false"}
+		}
+	}
+
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(allowed any) bool { return fmt.Sprint(allowed) == fmt.Sprint(value) }) {
+		allowed := make([]string, len(schema.Enum))
+		for i, e := range schema.Enum {
+			allowed[i] = fmt.Sprint(e)
+		}
+		problems = append(problems, fmt.Sprintf("%s must be one of: %s", subject, strings.Join(allowed, ", ")))
+	}
+	return problems
+}
+
+// fieldName names a field of an object, e.g. "weather.main"
+func fieldName(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
+
+// article returns the JSON type with its indefinite article, e.g. "an integer"
+func article(jsonType string) string {
+	if jsonType == "integer" {
+		return "an integer"
+	}
+	return "a " + jsonType
+}
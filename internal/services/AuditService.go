@@ -0,0 +1,49 @@
+// internal/services/AuditService.go
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/models"
+)
+
+const (
+	DefaultAuditPageSize = 50
+	MaxAuditPageSize     = 200
+)
+
+type IAuditService interface {
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) (*models.AuditPage, error)
+}
+
+// AuditService reads the audit log; events are recorded by the services making the changes
+type AuditService struct {
+	DB database.IDB
+}
+
+// NewAuditService creates a new AuditService instance
+func NewAuditService(db database.IDB) *AuditService {
+	return &AuditService{DB: db}
+}
+
+// GetAuditEvents retrieves a page of the audit log, newest first
+func (s *AuditService) GetAuditEvents(ctx context.Context, filter models.AuditFilter) (*models.AuditPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+	if filter.Limit > MaxAuditPageSize {
+		filter.Limit = MaxAuditPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	events, err := s.DB.GetAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit events: %w", err)
+	}
+
+	return &models.AuditPage{Events: events, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
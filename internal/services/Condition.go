@@ -0,0 +1,107 @@
+// internal/services/Condition.go
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maxcool.com/weatherapp/internal/models"
+)
+
+// ErrInvalidCondition is returned when a condition string cannot be parsed
+var ErrInvalidCondition = errors.New("invalid condition")
+
+// comparisons maps the supported operators of numeric conditions to their implementation
+var comparisons = map[string]func(a, b float32) bool{
+	"==": func(a, b float32) bool { return a == b },
+	"!=": func(a, b float32) bool { return a != b },
+	"<":  func(a, b float32) bool { return a < b },
+	"<=": func(a, b float32) bool { return a <= b },
+	">":  func(a, b float32) bool { return a > b },
+	">=": func(a, b float32) bool { return a >= b },
+}
+
+// numericProperties maps the numeric condition properties to the weather value they read
+var numericProperties = map[string]func(w models.WeatherResponse) float64{
+	"temperature": func(w models.WeatherResponse) float64 { return w.Main.Temp },
+	"feels_like":  func(w models.WeatherResponse) float64 { return w.Main.Feels_like },
+	"humidity":    func(w models.WeatherResponse) float64 { return float64(w.Main.Humidity) },
+}
+
+// ParseCondition parses a condition string such as "temperature:<=:35" or "main:clear"
+// It returns an error wrapping ErrInvalidCondition if the condition is malformed
+func ParseCondition(condition string) (*models.ParsedCondition, error) {
+	splitCondition := strings.Split(condition, ":")
+
+	if len(splitCondition) < 2 {
+		return nil, fmt.Errorf("%w: expected property:operator:value or main:description, got %q", ErrInvalidCondition, condition)
+	}
+
+	// Segments after the description are ignored, as they always were, so stored conditions like main:rain:x keep working
+	if splitCondition[0] == "main" { // special case
+		if splitCondition[1] == "" {
+			return nil, fmt.Errorf("%w: expected main:description, got %q", ErrInvalidCondition, condition)
+		}
+		return &models.ParsedCondition{Property: "main", Operator: "==", Main: splitCondition[1]}, nil
+	}
+
+	if len(splitCondition) != 3 {
+		return nil, fmt.Errorf("%w: expected property:operator:value, got %q", ErrInvalidCondition, condition)
+	}
+	if _, ok := numericProperties[splitCondition[0]]; !ok {
+		return nil, fmt.Errorf("%w: unknown property %q", ErrInvalidCondition, splitCondition[0])
+	}
+	if _, ok := comparisons[splitCondition[1]]; !ok {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, splitCondition[1])
+	}
+	value, err := strconv.ParseFloat(splitCondition[2], 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid value %q", ErrInvalidCondition, splitCondition[2])
+	}
+
+	return &models.ParsedCondition{Property: splitCondition[0], Operator: splitCondition[1], Value: &value}, nil
+}
+
+// ValidateCondition reports whether a condition string is well formed
+func ValidateCondition(condition string) error {
+	_, err := ParseCondition(condition)
+	return err
+}
+
+// EvaluateParsedCondition evaluates a parsed condition against weather data
+// It returns the value of each sub-expression (the weather property, the expected value and the comparison)
+// together with the final result
+func EvaluateParsedCondition(parsed *models.ParsedCondition, weather models.WeatherResponse) ([]models.ExpressionValue, bool, error) {
+	if parsed.Property == "main" {
+		if len(weather.Weather) == 0 {
+			return nil, false, fmt.Errorf("no weather description available")
+		}
+		actual := weather.Weather[0].Main
+		result := strings.EqualFold(actual, parsed.Main)
+		return []models.ExpressionValue{
+			{Expression: "main", Value: actual},
+			{Expression: strconv.Quote(parsed.Main), Value: parsed.Main},
+			{Expression: "main == " + strconv.Quote(parsed.Main), Value: result},
+		}, result, nil
+	}
+
+	read, ok := numericProperties[parsed.Property]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: unknown property %q", ErrInvalidCondition, parsed.Property)
+	}
+	compare, ok := comparisons[parsed.Operator]
+	if !ok || parsed.Value == nil {
+		return nil, false, fmt.Errorf("%w: incomplete comparison for %q", ErrInvalidCondition, parsed.Property)
+	}
+
+	actual := read(weather)
+	expected := strconv.FormatFloat(*parsed.Value, 'f', -1, 64)
+	result := compare(float32(actual), float32(*parsed.Value))
+	return []models.ExpressionValue{
+		{Expression: parsed.Property, Value: actual},
+		{Expression: expected, Value: *parsed.Value},
+		{Expression: parsed.Property + " " + parsed.Operator + " " + expected, Value: result},
+	}, result, nil
+}
@@ -0,0 +1,106 @@
+// internal/services/DeliveryWindow.go
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"maxcool.com/weatherapp/internal/models"
+)
+
+const defaultTimezone = "UTC"
+
+// parseClock parses a "HH:MM" string into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// NormalizeDeliveryPreferences fills in defaults for the user's delivery preferences and validates them
+// It returns an error if the time zone, quiet hours or policy are invalid
+func NormalizeDeliveryPreferences(user *models.User) error {
+	if user.Timezone == "" {
+		user.Timezone = defaultTimezone
+	}
+	if user.QuietHoursPolicy == "" {
+		user.QuietHoursPolicy = models.QuietHoursPolicyDefer
+	}
+
+	if _, err := time.LoadLocation(user.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", user.Timezone, err)
+	}
+
+	if (user.QuietHoursStart == "") != (user.QuietHoursEnd == "") {
+		return fmt.Errorf("quiet hours need both a start and an end")
+	}
+	if user.QuietHoursStart != "" {
+		if _, err := parseClock(user.QuietHoursStart); err != nil {
+			return fmt.Errorf("invalid quiet hours start: %w", err)
+		}
+		if _, err := parseClock(user.QuietHoursEnd); err != nil {
+			return fmt.Errorf("invalid quiet hours end: %w", err)
+		}
+	}
+
+	switch user.QuietHoursPolicy {
+	case models.QuietHoursPolicyDefer, models.QuietHoursPolicyDrop:
+	default:
+		return fmt.Errorf("invalid quiet hours policy %q", user.QuietHoursPolicy)
+	}
+
+	return nil
+}
+
+// NextDeliveryTime returns the earliest moment at or after now at which the user may be notified
+// The boolean is true when now itself is outside the user's quiet hours
+// Quiet hours are interpreted in the user's time zone and may span midnight (e.g. 22:00-07:00)
+func NextDeliveryTime(user *models.User, now time.Time) (time.Time, bool, error) {
+	if user.QuietHoursStart == "" || user.QuietHoursEnd == "" {
+		return now, true, nil
+	}
+
+	timezone := user.Timezone
+	if timezone == "" {
+		timezone = defaultTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return now, false, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+
+	start, err := parseClock(user.QuietHoursStart)
+	if err != nil {
+		return now, false, err
+	}
+	end, err := parseClock(user.QuietHoursEnd)
+	if err != nil {
+		return now, false, err
+	}
+	if start == end {
+		return now, true, nil // empty window
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	var quiet bool
+	if start < end {
+		quiet = minute >= start && minute < end
+	} else {
+		quiet = minute >= start || minute < end // window spans midnight
+	}
+	if !quiet {
+		return now, true, nil
+	}
+
+	// The window ends today unless we are in the part before midnight
+	day := local.Day()
+	if minute >= end {
+		day++
+	}
+	next := time.Date(local.Year(), local.Month(), day, end/60, end%60, 0, 0, loc)
+	return next, false, nil
+}
@@ -0,0 +1,91 @@
+// internal/services/NotificationService.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/models"
+)
+
+const (
+	DefaultNotificationPageSize = 50
+	MaxNotificationPageSize     = 200
+)
+
+var (
+	// ErrUserNotFound is returned when the requested user does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrSubscriptionNotFound is returned when the requested subscription does not exist
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+)
+
+type INotificationService interface {
+	GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) (*models.NotificationPage, error)
+	GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) (*models.NotificationPage, error)
+}
+
+type NotificationService struct {
+	DB database.IDB
+}
+
+// NewNotificationService creates a new NotificationService instance
+func NewNotificationService(db database.IDB) *NotificationService {
+	return &NotificationService{DB: db}
+}
+
+// normalizeFilter applies the default page size and clamps the pagination parameters
+func normalizeFilter(filter models.NotificationFilter) models.NotificationFilter {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultNotificationPageSize
+	}
+	if filter.Limit > MaxNotificationPageSize {
+		filter.Limit = MaxNotificationPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return filter
+}
+
+// GetNotificationsByUserID retrieves a page of the notification history of a user
+// It returns ErrUserNotFound if the user does not exist
+func (s *NotificationService) GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) (*models.NotificationPage, error) {
+	user, err := s.DB.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user by ID: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	filter = normalizeFilter(filter)
+	notifications, err := s.DB.GetNotificationsByUserID(ctx, userID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications by user ID: %w", err)
+	}
+
+	return &models.NotificationPage{Notifications: notifications, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
+
+// GetNotificationsBySubscriptionID retrieves a page of the notification history of a subscription
+// It returns ErrSubscriptionNotFound if the subscription does not exist
+func (s *NotificationService) GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) (*models.NotificationPage, error) {
+	subscription, err := s.DB.GetSubscriptionByID(ctx, subID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription by ID: %w", err)
+	}
+	if subscription == nil {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	filter = normalizeFilter(filter)
+	notifications, err := s.DB.GetNotificationsBySubscriptionID(ctx, subID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications by subscription ID: %w", err)
+	}
+
+	return &models.NotificationPage{Notifications: notifications, Limit: filter.Limit, Offset: filter.Offset}, nil
+}
@@ -0,0 +1,71 @@
+// internal/services/Notifier.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sync"
+
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/models"
+)
+
+// Notifier delivers a notification message to a recipient
+type Notifier interface {
+	// Channel returns the name of the channel recorded with sent notifications
+	Channel() string
+	// Send delivers the message and returns the provider's message ID
+	Send(ctx context.Context, to, subject, body string) (string, error)
+}
+
+// EmailNotifier sends notifications as emails through Resend
+// Its settings can be replaced with Update while emails are being sent
+type EmailNotifier struct {
+	mu     sync.RWMutex
+	config *config.Config
+}
+
+// NewEmailNotifier creates an EmailNotifier using the sender address, API key and timeout from the configuration
+func NewEmailNotifier(cfg *config.Config) *EmailNotifier {
+	return &EmailNotifier{config: cfg}
+}
+
+// Update replaces the email settings with the ones from the configuration
+func (n *EmailNotifier) Update(cfg *config.Config) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.config = cfg
+}
+
+// Channel returns the email channel name
+func (n *EmailNotifier) Channel() string {
+	return models.ChannelEmail
+}
+
+// Check reports whether the email settings are complete enough to send emails
+func (n *EmailNotifier) Check() error {
+	n.mu.RLock()
+	cfg := n.config
+	n.mu.RUnlock()
+
+	if cfg.ResendApiKey == "" {
+		return fmt.Errorf("RESEND_API_KEY not set")
+	}
+	if _, err := mail.ParseAddress(cfg.EmailFrom); err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", cfg.EmailFrom, err)
+	}
+	if _, err := url.ParseRequestURI(cfg.ResendBaseURL); err != nil {
+		return fmt.Errorf("invalid Resend base URL: %w", err)
+	}
+	return nil
+}
+
+// Send sends the message as an email
+func (n *EmailNotifier) Send(ctx context.Context, to, subject, body string) (string, error) {
+	n.mu.RLock()
+	cfg := n.config
+	n.mu.RUnlock()
+	return SendEmail(ctx, to, subject, body, cfg)
+}
@@ -2,45 +2,97 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/resend/resend-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"maxcool.com/weatherapp/internal/audit"
 	"maxcool.com/weatherapp/internal/config"
 	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/metrics"
 	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/tracing"
+)
+
+var (
+	// ErrInvalidSubscriptionSchedule is returned when the pause and expiry times of a subscription contradict each other
+	ErrInvalidSubscriptionSchedule = errors.New("invalid subscription schedule")
+	// ErrSubscriptionExpired is returned when resuming a subscription that has already expired
+	ErrSubscriptionExpired = errors.New("subscription has expired")
+	// ErrInvalidCursor is returned for a pagination cursor that was not issued by a previous page
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	DefaultSubscriptionPageSize = 50
+	MaxSubscriptionPageSize     = 200
+	// DefaultNotificationBatchSize is the number of subscriptions the notification run loads at a time
+	// when no batch size is configured
+	DefaultNotificationBatchSize = 500
 )
 
 type ISubscriptionService interface {
-	GetSubscriptionsByUserID(userID int) ([]*models.Subscription, error)
-	CreateSubscription(subscription *models.Subscription) error
-	UpdateSubscription(subscription *models.Subscription) error
-	DeleteSubscription(id int) error
-	GetSubscriptionByID(id int) (*models.Subscription, error)
-	GetWeather(city string) (models.WeatherResponse, error)
-	CheckCondition(condition, city string) (bool, error)
-	SendNotificationToUsers() error
-	CheckWhetherCityExists(city string) (bool, error)
+	GetSubscriptions(ctx context.Context) ([]models.Subscription, error)
+	GetSubscriptionsByUserID(ctx context.Context, userID int) ([]*models.Subscription, error)
+	ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) (*models.SubscriptionPage, error)
+	ListUserSubscriptions(ctx context.Context, userID int, filter models.SubscriptionFilter) (*models.SubscriptionPage, error)
+	CreateSubscription(ctx context.Context, subscription *models.Subscription) error
+	UpdateSubscription(ctx context.Context, subscription *models.Subscription) error
+	DeleteSubscription(ctx context.Context, id int) error
+	GetSubscriptionByID(ctx context.Context, id int) (*models.Subscription, error)
+	PauseSubscription(ctx context.Context, id int, until *time.Time) (*models.Subscription, error)
+	ResumeSubscription(ctx context.Context, id int) (*models.Subscription, error)
+	GetWeather(ctx context.Context, city string) (models.WeatherResponse, error)
+	CheckCondition(ctx context.Context, condition, city string) (bool, error)
+	EvaluateCondition(ctx context.Context, condition, city string) (*models.ConditionEvaluation, error)
+	SendNotificationToUsers(ctx context.Context) error
+	SendDeferredNotifications(ctx context.Context) error
+	PurgeDeleted(ctx context.Context, retention time.Duration) error
+	CheckWhetherCityExists(ctx context.Context, city string) (bool, error)
 }
 
 type SubscriptionService struct {
-	DB     database.IDB
-	Config *config.Config
+	DB        database.IDB
+	Config    *config.Config
+	Weather   *WeatherClient
+	Notifier  Notifier
+	BatchSize int // subscriptions loaded at a time by the notification run, DefaultNotificationBatchSize if not positive
 }
 
 // NewSubscriptionService creates a new SubscriptionService instance
+// The weather client and the email notifier are only set up when a configuration is given
 func NewSubscriptionService(db database.IDB, cfg *config.Config) *SubscriptionService {
-	return &SubscriptionService{DB: db, Config: cfg}
+	s := &SubscriptionService{DB: db, Config: cfg}
+	if cfg != nil {
+		s.Weather = NewWeatherClient(cfg)
+		s.Notifier = NewEmailNotifier(cfg)
+		s.BatchSize = cfg.NotificationBatchSize
+	}
+	return s
+}
+
+// GetSubscriptions retrieves all subscriptions
+func (s *SubscriptionService) GetSubscriptions(ctx context.Context) ([]models.Subscription, error) {
+	subscriptions, err := s.DB.GetSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+	return subscriptions, nil
 }
 
 // GetSubscriptionsByUserID retrieves all subscriptions for a given user ID
-func (s *SubscriptionService) GetSubscriptionsByUserID(userID int) ([]*models.Subscription, error) {
-	subscriptions, err := s.DB.GetSubscriptionsByUserID(userID)
+func (s *SubscriptionService) GetSubscriptionsByUserID(ctx context.Context, userID int) ([]*models.Subscription, error) {
+	subscriptions, err := s.DB.GetSubscriptionsByUserID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscriptions by user ID: %w", err)
 	}
@@ -53,245 +105,724 @@ func (s *SubscriptionService) GetSubscriptionsByUserID(userID int) ([]*models.Su
 	return result, nil
 }
 
-// CreateSubscription creates a new subscription
-func (s *SubscriptionService) CreateSubscription(subscription *models.Subscription) error {
-	user, err := s.DB.GetUserByEmail(subscription.UserEmail)
+// ListSubscriptions retrieves a page of the subscriptions matching the filter, in creation order
+func (s *SubscriptionService) ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) (*models.SubscriptionPage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultSubscriptionPageSize
+	}
+	if filter.Limit > MaxSubscriptionPageSize {
+		filter.Limit = MaxSubscriptionPageSize
+	}
+
+	// One extra subscription tells whether there is a next page
+	pageSize := filter.Limit
+	filter.Limit++
+	subscriptions, err := s.DB.ListSubscriptions(ctx, filter)
 	if err != nil {
-		return fmt.Errorf("failed to get user by email: %w", err)
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
 	}
-	if user == nil {
-		return fmt.Errorf("user not found: %s", subscription.UserEmail)
+
+	page := &models.SubscriptionPage{Subscriptions: []models.SubscriptionResponse{}, Limit: pageSize}
+	if len(subscriptions) > pageSize {
+		subscriptions = subscriptions[:pageSize]
+		page.NextCursor = EncodeCursor(subscriptions[pageSize-1].Id)
+	}
+	for i := range subscriptions {
+		page.Subscriptions = append(page.Subscriptions, models.NewSubscriptionResponse(&subscriptions[i]))
 	}
-	subscription.UserId = user.Id
-	subscription.UserEmail = user.Email
+	return page, nil
+}
 
-	_, err = s.DB.CreateSubscription(subscription)
+// ListUserSubscriptions retrieves a page of the subscriptions of a user matching the filter
+// It returns ErrUserNotFound if the user does not exist
+func (s *SubscriptionService) ListUserSubscriptions(ctx context.Context, userID int, filter models.SubscriptionFilter) (*models.SubscriptionPage, error) {
+	user, err := s.DB.GetUserByID(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("failed to create subscription: %w", err)
+		return nil, fmt.Errorf("failed to get user by ID: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
 	}
 
-	return nil
+	filter.UserId = userID
+	return s.ListSubscriptions(ctx, filter)
 }
 
-// UpdateSubscription updates an existing subscription
-func (s *SubscriptionService) UpdateSubscription(subscription *models.Subscription) error {
-	if err := s.DB.UpdateSubscription(subscription); err != nil {
-		return fmt.Errorf("failed to update subscription: %w", err)
+// forEachSubscription calls fn for every subscription matching the filter, loading BatchSize of them at a time
+// The limit and cursor of the filter are ignored
+func (s *SubscriptionService) forEachSubscription(ctx context.Context, filter models.SubscriptionFilter, fn func(models.Subscription)) error {
+	filter.AfterId = 0
+	filter.Limit = s.BatchSize
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultNotificationBatchSize
 	}
-	return nil
+
+	for {
+		batch, err := s.DB.ListSubscriptions(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to get subscriptions: %w", err)
+		}
+		for _, subscription := range batch {
+			fn(subscription)
+		}
+		if len(batch) < filter.Limit {
+			return nil
+		}
+		filter.AfterId = batch[len(batch)-1].Id
+	}
+}
+
+// EncodeCursor returns the opaque pagination cursor that continues after the subscription with the given ID
+func EncodeCursor(afterID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(afterID)))
 }
 
-// DeleteSubscription deletes a subscription by its ID
-func (s *SubscriptionService) DeleteSubscription(id int) error {
-	if err := s.DB.DeleteSubscription(id); err != nil {
-		return fmt.Errorf("failed to delete subscription: %w", err)
+// DecodeCursor returns the subscription ID encoded by EncodeCursor
+func DecodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
 	}
-	return nil
+	afterID, err := strconv.Atoi(string(data))
+	if err != nil || afterID < 1 {
+		return 0, ErrInvalidCursor
+	}
+	return afterID, nil
+}
+
+// CreateSubscription creates a new subscription
+func (s *SubscriptionService) CreateSubscription(ctx context.Context, subscription *models.Subscription) (err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.CreateSubscription")
+	defer tracing.End(span, &err)
+
+	if subscription.PausedUntil != nil && subscription.ExpiresAt != nil && !subscription.ExpiresAt.After(*subscription.PausedUntil) {
+		return fmt.Errorf("%w: expires_at must be after paused_until", ErrInvalidSubscriptionSchedule)
+	}
+
+	// The user lookup and the insert share a transaction, so the user cannot disappear in between
+	return s.DB.WithTx(ctx, func(tx database.IDB) error {
+		user, err := tx.GetUserByEmail(ctx, subscription.UserEmail)
+		if err != nil {
+			return fmt.Errorf("failed to get user by email: %w", err)
+		}
+		if user == nil {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, subscription.UserEmail)
+		}
+		subscription.UserId = user.Id
+		subscription.UserEmail = user.Email
+		subscription.Active = true
+
+		id, err := tx.CreateSubscription(ctx, subscription)
+		if err != nil {
+			return fmt.Errorf("failed to create subscription: %w", err)
+		}
+		subscription.Id = id
+		return audit.Record(ctx, tx, models.AuditEntitySubscription, id, models.AuditActionCreate, nil, subscription)
+	})
+}
+
+// UpdateSubscription updates an existing subscription and records the change in the audit log
+// Updating a subscription that does not exist does nothing
+func (s *SubscriptionService) UpdateSubscription(ctx context.Context, subscription *models.Subscription) error {
+	return s.DB.WithTx(ctx, func(tx database.IDB) error {
+		before, err := tx.GetSubscriptionByID(ctx, subscription.Id)
+		if err != nil {
+			return fmt.Errorf("failed to get subscription by ID: %w", err)
+		}
+		if before == nil {
+			return nil
+		}
+		if err := tx.UpdateSubscription(ctx, subscription); err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		return audit.Record(ctx, tx, models.AuditEntitySubscription, subscription.Id, models.AuditActionUpdate, before, subscription)
+	})
+}
+
+// DeleteSubscription deletes a subscription by its ID and records the deletion in the audit log
+// Deleting a subscription that does not exist does nothing
+func (s *SubscriptionService) DeleteSubscription(ctx context.Context, id int) error {
+	return s.DB.WithTx(ctx, func(tx database.IDB) error {
+		before, err := tx.GetSubscriptionByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get subscription by ID: %w", err)
+		}
+		if before == nil {
+			return nil
+		}
+		if err := tx.DeleteSubscription(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete subscription: %w", err)
+		}
+		return audit.Record(ctx, tx, models.AuditEntitySubscription, id, models.AuditActionDelete, before, nil)
+	})
 }
 
 // GetSubscriptionByID retrieves a subscription by its ID
-func (s *SubscriptionService) GetSubscriptionByID(id int) (*models.Subscription, error) {
-	subscription, err := s.DB.GetSubscriptionByID(id)
+func (s *SubscriptionService) GetSubscriptionByID(ctx context.Context, id int) (*models.Subscription, error) {
+	subscription, err := s.DB.GetSubscriptionByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscription by ID: %w", err)
 	}
 	return subscription, nil
 }
 
+// IsSubscriptionActive reports whether the subscription should be notified at the given time
+func IsSubscriptionActive(subscription *models.Subscription, now time.Time) bool {
+	if !subscription.Active {
+		return false
+	}
+	if subscription.PausedUntil != nil && now.Before(*subscription.PausedUntil) {
+		return false
+	}
+	if subscription.ExpiresAt != nil && !now.Before(*subscription.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// PauseSubscription suspends notifications for a subscription
+// With a nil until the subscription stays paused until it is resumed,
+// otherwise notifications start again automatically at the given time, which must lie in the future
+// A timed pause replaces any earlier pause, so a subscription paused until resumed
+// also starts again at until
+// It returns the updated subscription, or nil if the subscription does not exist
+// It returns ErrSubscriptionExpired if the subscription has already expired
+func (s *SubscriptionService) PauseSubscription(ctx context.Context, id int, until *time.Time) (*models.Subscription, error) {
+	now := time.Now()
+	if until != nil && !until.After(now) {
+		return nil, fmt.Errorf("%w: pause must end in the future", ErrInvalidSubscriptionSchedule)
+	}
+
+	return s.changeSubscription(ctx, id, func(subscription *models.Subscription) error {
+		if subscription.ExpiresAt != nil && !now.Before(*subscription.ExpiresAt) {
+			return ErrSubscriptionExpired
+		}
+		if until == nil {
+			subscription.Active = false
+			subscription.PausedUntil = nil
+			return nil
+		}
+		if subscription.ExpiresAt != nil && !subscription.ExpiresAt.After(*until) {
+			return fmt.Errorf("%w: pause would outlast the expiry", ErrInvalidSubscriptionSchedule)
+		}
+		subscription.Active = true
+		subscription.PausedUntil = until
+		return nil
+	})
+}
+
+// ResumeSubscription lifts any pause on a subscription
+// It returns the updated subscription, or nil if the subscription does not exist
+// It returns ErrSubscriptionExpired if the subscription can no longer be resumed
+func (s *SubscriptionService) ResumeSubscription(ctx context.Context, id int) (*models.Subscription, error) {
+	return s.changeSubscription(ctx, id, func(subscription *models.Subscription) error {
+		if subscription.ExpiresAt != nil && !time.Now().Before(*subscription.ExpiresAt) {
+			return ErrSubscriptionExpired
+		}
+		subscription.Active = true
+		subscription.PausedUntil = nil
+		return nil
+	})
+}
+
+// changeSubscription loads a subscription, applies change to it, stores it and records the change
+// in the audit log, all in one transaction
+// It returns the updated subscription, or nil if the subscription does not exist
+func (s *SubscriptionService) changeSubscription(ctx context.Context, id int, change func(*models.Subscription) error) (*models.Subscription, error) {
+	var updated *models.Subscription
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		subscription, err := tx.GetSubscriptionByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get subscription by ID: %w", err)
+		}
+		if subscription == nil {
+			return nil
+		}
+		before := *subscription
+
+		if err := change(subscription); err != nil {
+			return err
+		}
+		if err := tx.UpdateSubscription(ctx, subscription); err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		if err := audit.Record(ctx, tx, models.AuditEntitySubscription, id, models.AuditActionUpdate, &before, subscription); err != nil {
+			return err
+		}
+		updated = subscription
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
 // SendEmail sends an email using the provided mailer
 // It takes the recipient's email address, subject, and body as parameters
-// It returns an error if sending the email fails
-func SendEmail(to string, subject string, body string, config *config.Config) error {
-	client := resend.NewClient(config.ResendApiKey)
+// It returns the provider's message ID, or an error if sending the email fails
+func SendEmail(ctx context.Context, to string, subject string, body string, config *config.Config) (string, error) {
+	client := resend.NewCustomClient(&http.Client{Timeout: config.EmailRequestTimeout, Transport: tracing.Transport(nil)}, config.ResendApiKey)
+	baseURL, err := url.Parse(config.ResendBaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Resend base URL: %w", err)
+	}
+	client.BaseURL = baseURL
 
-	log.Printf("Sending email to %s.", to)
+	slog.DebugContext(ctx, "Sending email", "to", to)
 
 	params := &resend.SendEmailRequest{
-		From:    "weatherapp@resend.dev",
+		From:    config.EmailFrom,
 		To:      []string{to},
 		Subject: subject,
 		Html:    body,
 	}
 
-	sent, err := client.Emails.Send(params)
+	sent, err := client.Emails.SendWithContext(ctx, params)
 	if err != nil {
-		log.Printf("Error sending email: %v", err)
-		return err
+		slog.ErrorContext(ctx, "Error sending email", "to", to, "error", err)
+		return "", err
 	}
-	log.Printf("Email sent successfully: %s", sent.Id)
-	return nil
+	slog.InfoContext(ctx, "Email sent successfully", "to", to, "message_id", sent.Id)
+	return sent.Id, nil
 }
 
-const openweathermapAPIBaseUrl = "https://api.openweathermap.org/data/2.5/weather"
+// GetWeather retrieves the weather data for a given city
+// It returns an error if the weather data cannot be fetched
+func (s *SubscriptionService) GetWeather(ctx context.Context, city string) (weather models.WeatherResponse, err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.GetWeather", trace.WithAttributes(attribute.String("weather.city", city)))
+	defer tracing.End(span, &err)
 
-func (s *SubscriptionService) GetWeather(city string) (models.WeatherResponse, error) {
-	// Make a request to the OpenWeatherMap API
-	url := openweathermapAPIBaseUrl + "?q=" + city + "&appid=" + s.Config.OpenWeatherMapAPIKey + "&units=metric"
-	log.Print("GET ", url)
-	resp, err := http.Get(url)
+	return s.Weather.GetWeather(ctx, city)
+}
+
+// CheckWhetherCityExists reports whether the weather provider knows the given city
+// It returns an error if the provider cannot be reached
+func (s *SubscriptionService) CheckWhetherCityExists(ctx context.Context, city string) (exists bool, err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.CheckWhetherCityExists", trace.WithAttributes(attribute.String("weather.city", city)))
+	defer tracing.End(span, &err)
+
+	return s.Weather.CityExists(ctx, city)
+}
+
+// CheckCondition checks if the weather condition is met for a given city
+// It takes the condition string and city name as parameters
+// It returns true if the condition is met, false otherwise
+// It returns an error if the weather data cannot be fetched
+func (s *SubscriptionService) CheckCondition(ctx context.Context, condition, city string) (met bool, err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.CheckCondition", trace.WithAttributes(
+		attribute.String("subscription.condition", condition),
+		attribute.String("weather.city", city),
+	))
+	defer tracing.End(span, &err)
+
+	parsed, err := ParseCondition(condition)
 	if err != nil {
-		log.Fatal("Error fetching weather data: ", err)
-		return models.WeatherResponse{}, fmt.Errorf("error fetching weather data: %w", err)
+		return false, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error fetching weather data: %v", err)
-		return models.WeatherResponse{}, fmt.Errorf("error fetching weather data: %s", resp.Status)
+	weatherResponse, err := s.GetWeather(ctx, city)
+	if err != nil {
+		return false, fmt.Errorf("failed to get weather data: %w", err)
 	}
 
-	// Read the response body
-	var weatherResponse models.WeatherResponse
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&weatherResponse)
+	_, met, err = EvaluateParsedCondition(parsed, weatherResponse)
 	if err != nil {
-		log.Print("Error decoding weather data: ", err)
-		return models.WeatherResponse{}, err
+		return false, err
+	}
+	if met {
+		slog.DebugContext(ctx, "Condition met", "condition", condition, "city", city)
 	}
-	return weatherResponse, nil
+	return met, nil
 }
 
-// GetWeather retrieves the weather data for a given city
-// It takes the city name as a parameter
-// It returns the weather data and an error if the request fails
-// It returns an error if the weather data cannot be fetched
-func (s *SubscriptionService) CheckWhetherCityExists(city string) (bool, error) {
-	// Make a request to the OpenWeatherMap API
-	log.Print("checking whether city exists")
-	url := openweathermapAPIBaseUrl + "?q=" + city + "&appid=" + s.Config.OpenWeatherMapAPIKey + "&units=metric"
-	log.Print("GET ", url)
-	resp, err := http.Get(url)
+// EvaluateCondition parses the condition, fetches the current weather for the city and
+// explains the result: the parsed structure, each sub-expression's value and the final result
+// It returns an error wrapping ErrInvalidCondition if the condition is malformed
+func (s *SubscriptionService) EvaluateCondition(ctx context.Context, condition, city string) (evaluation *models.ConditionEvaluation, err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.EvaluateCondition", trace.WithAttributes(
+		attribute.String("subscription.condition", condition),
+		attribute.String("weather.city", city),
+	))
+	defer tracing.End(span, &err)
+
+	parsed, err := ParseCondition(condition)
 	if err != nil {
-		log.Fatal("Error fetching weather data: ", err)
-		return false, fmt.Errorf("error fetching weather data: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusNotFound {
-			log.Printf("City not found: %s", city)
-			return false, nil
-		}
-		log.Printf("Error fetching weather data: %v", err)
-		return false, fmt.Errorf("error fetching weather data: %s", resp.Status)
+	weatherResponse, err := s.GetWeather(ctx, city)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get weather data: %w", err)
 	}
 
-	return true, nil
+	values, result, err := EvaluateParsedCondition(parsed, weatherResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ConditionEvaluation{
+		Condition: condition,
+		City:      city,
+		Parsed:    parsed,
+		Values:    values,
+		Result:    result,
+		Weather:   snapshotOf(weatherResponse),
+	}, nil
 }
 
-// CheckCondition checks if the weather condition is met for a given city
-// It takes the condition string and city name as parameters
-// It returns true if the condition is met, false otherwise
-// It returns an error if the weather data cannot be fetched
-func (s *SubscriptionService) CheckCondition(condition, city string) (bool, error) {
-	weatherResponse, err := s.GetWeather(city)
-	if err != nil {
-		return false, fmt.Errorf("failed to get weather data: %w", err)
+// notificationHit is a single subscription whose condition was met during a notification run
+type notificationHit struct {
+	SubscriptionId int
+	Line           string
+	Weather        *models.WeatherSnapshot
+	DeferredId     int // the deferred notification the hit was loaded from, if any
+}
+
+const (
+	updateSubject = "Weather Update"
+	digestSubject = "Weather Digest"
+)
+
+// describeHit renders the city, condition and current weather values of a met subscription
+func describeHit(subscription models.Subscription, weather models.WeatherResponse) string {
+	description := ""
+	if len(weather.Weather) > 0 {
+		description = ", " + strings.ToLower(weather.Weather[0].Main)
 	}
+	return fmt.Sprintf("The weather condition `%s` is met for city `%s` (temperature %.1f°C, feels like %.1f°C, humidity %d%%%s).",
+		subscription.Condition, subscription.City,
+		weather.Main.Temp, weather.Main.Feels_like, weather.Main.Humidity, description)
+}
 
-	splitCondition := strings.Split(condition, ":")
+// snapshotOf extracts the values recorded with a notification from a weather response
+func snapshotOf(weather models.WeatherResponse) *models.WeatherSnapshot {
+	snapshot := &models.WeatherSnapshot{
+		Temperature: weather.Main.Temp,
+		FeelsLike:   weather.Main.Feels_like,
+		Humidity:    weather.Main.Humidity,
+	}
+	if len(weather.Weather) > 0 {
+		snapshot.Main = weather.Weather[0].Main
+	}
+	return snapshot
+}
 
-	if len(splitCondition) < 2 {
-		return false, fmt.Errorf("invalid condition format: %s", condition)
+// composeDigest renders several hits as the body of a single digest email
+func composeDigest(lines []string) string {
+	var b strings.Builder
+	b.WriteString("<p>Your weather subscriptions that matched today:</p><ul>")
+	for _, line := range lines {
+		b.WriteString("<li>" + line + "</li>")
 	}
-	if splitCondition[0] != "main" && len(splitCondition) != 3 {
-		return false, fmt.Errorf("invalid condition format: %s", condition)
+	b.WriteString("</ul>")
+	return b.String()
+}
+
+// SendNotificationToUsers sends notifications to users based on their subscriptions
+// It deactivates expired subscriptions, then walks the active subscriptions in batches of BatchSize
+// It checks if the weather condition is met for each active subscription
+// The met subscriptions are grouped per user and handed to the dispatch path,
+// which sends them right away (one email per subscription, or a single digest email
+// for users in digest mode) or holds them back according to the user's quiet hours
+func (s *SubscriptionService) SendNotificationToUsers(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.SendNotificationToUsers")
+	defer tracing.End(span, &err)
+
+	now := time.Now()
+	defer func() {
+		metrics.NotificationRunDuration.Observe(time.Since(now).Seconds())
+	}()
+
+	if expired, err := s.expireSubscriptions(ctx, now); err != nil {
+		slog.ErrorContext(ctx, "Failed to expire subscriptions", "error", err)
+	} else if expired > 0 {
+		slog.InfoContext(ctx, "Deactivated expired subscriptions", "count", expired)
 	}
 
-	var prop float32
-	var operator func(a, b float32) bool
-	var condVal float32
+	hitsByUser := map[int][]notificationHit{}
+	userOrder := []int{}
+	weatherCache := newWeatherCache(s.GetWeather)
 
-	switch splitCondition[0] {
-	case "feels_like":
-		prop = float32(weatherResponse.Main.Feels_like)
-	case "humidity":
-		prop = float32(weatherResponse.Main.Humidity)
-	case "main": // special case
-		return strings.EqualFold(weatherResponse.Weather[0].Main, splitCondition[1]), nil
-	case "temperature":
-		prop = float32(weatherResponse.Main.Temp)
-	default:
-		return false, fmt.Errorf("invalid property: %s", splitCondition[0])
+	// Subscriptions are loaded in batches, so the run does not hold all of them in memory
+	active := true
+	err = s.forEachSubscription(ctx, models.SubscriptionFilter{Active: &active}, func(subscription models.Subscription) {
+		if !IsSubscriptionActive(&subscription, now) {
+			return
+		}
+
+		// Check the weather condition
+		parsed, err := ParseCondition(subscription.Condition)
+		if err != nil {
+			slog.WarnContext(ctx, "Invalid subscription condition", "subscription_id", subscription.Id, "error", err)
+			return
+		}
+		weatherResponse, err := weatherCache.Get(ctx, subscription.City)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get weather data", "subscription_id", subscription.Id, "city", subscription.City, "error", err)
+			return
+		}
+		_, met, err := EvaluateParsedCondition(parsed, weatherResponse)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to evaluate condition", "subscription_id", subscription.Id, "error", err)
+			return
+		}
+		metrics.SubscriptionsEvaluated.Inc()
+
+		if met {
+			metrics.SubscriptionsMatched.Inc()
+			if _, ok := hitsByUser[subscription.UserId]; !ok {
+				userOrder = append(userOrder, subscription.UserId)
+			}
+			hitsByUser[subscription.UserId] = append(hitsByUser[subscription.UserId], notificationHit{
+				SubscriptionId: subscription.Id,
+				Line:           describeHit(subscription, weatherResponse),
+				Weather:        snapshotOf(weatherResponse),
+			})
+		}
+	})
+	if err != nil {
+		return err
 	}
 
-	switch splitCondition[1] {
-	case "==":
-		operator = func(a, b float32) bool { return a == b }
-	case "!=":
-		operator = func(a, b float32) bool { return a != b }
-	case "<":
-		operator = func(a, b float32) bool { return a < b }
-	case "<=":
-		operator = func(a, b float32) bool { return a <= b }
-	case ">":
-		operator = func(a, b float32) bool { return a > b }
-	case ">=":
-		operator = func(a, b float32) bool { return a >= b }
-	default:
-		return false, fmt.Errorf("invalid operator: %s", splitCondition[1])
+	for _, userID := range userOrder {
+		user, err := s.DB.GetUserByID(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get user", "user_id", userID, "error", err)
+			continue
+		}
+		if user == nil {
+			slog.WarnContext(ctx, "User not found", "user_id", userID)
+			continue
+		}
+
+		if err := s.dispatchNotifications(ctx, user, hitsByUser[userID], now); err != nil {
+			slog.ErrorContext(ctx, "Failed to dispatch notifications", "user_id", userID, "error", err)
+			continue
+		}
 	}
 
-	condVal64, err := strconv.ParseFloat(splitCondition[2], 32)
-	condVal = float32(condVal64)
+	return nil
+}
+
+// dispatchNotifications is the single place where notifications are either delivered or held back
+// If now falls inside the user's quiet hours the notifications are deferred to the end of the
+// quiet hours or dropped, depending on the user's quiet hours policy
+func (s *SubscriptionService) dispatchNotifications(ctx context.Context, user *models.User, hits []notificationHit, now time.Time) error {
+	deliverAt, allowed, err := NextDeliveryTime(user, now)
 	if err != nil {
-		return false, fmt.Errorf("invalid condition value: %s", splitCondition[2])
+		return fmt.Errorf("failed to compute delivery time: %w", err)
+	}
+
+	if !allowed {
+		if user.QuietHoursPolicy == models.QuietHoursPolicyDrop {
+			slog.InfoContext(ctx, "Dropping notifications during quiet hours", "user_id", user.Id, "count", len(hits))
+			return nil
+		}
+
+		// Either all hits of the user are deferred or none, so a retry does not defer some of them twice
+		err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+			for _, hit := range hits {
+				deferred := models.DeferredNotification{
+					UserId:         user.Id,
+					SubscriptionId: hit.SubscriptionId,
+					Subject:        updateSubject,
+					Body:           hit.Line,
+					Weather:        hit.Weather,
+					DeliverAfter:   deliverAt,
+				}
+				if _, err := tx.CreateDeferredNotification(ctx, &deferred); err != nil {
+					return fmt.Errorf("failed to defer notification for subscription %d: %w", hit.SubscriptionId, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, hit := range hits {
+			slog.InfoContext(ctx, "Deferred notification", "subscription_id", hit.SubscriptionId, "deliver_after", deliverAt.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	_, err = s.deliverHits(ctx, user, hits)
+	return err
+}
+
+// deliverHits sends the hits of one user to their current address, either as separate emails or as a single digest
+// It returns the hits that were delivered; the hits that could not be sent are reported in the error
+func (s *SubscriptionService) deliverHits(ctx context.Context, user *models.User, hits []notificationHit) ([]notificationHit, error) {
+	if user.DigestMode {
+		lines := make([]string, len(hits))
+		for i, hit := range hits {
+			lines[i] = hit.Line
+		}
+		if err := s.deliverNotification(ctx, user.Email, user.Id, hits, digestSubject, composeDigest(lines)); err != nil {
+			return nil, err
+		}
+		return hits, nil
 	}
 
-	if operator(prop, condVal) {
-		log.Printf("Condition met: %s %s %f for city %s", splitCondition[0], splitCondition[1], condVal, city)
-		return true, nil
+	delivered := make([]notificationHit, 0, len(hits))
+	var errs []error
+	for _, hit := range hits {
+		if err := s.deliverNotification(ctx, user.Email, user.Id, []notificationHit{hit}, updateSubject, hit.Line); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", hit.SubscriptionId, err))
+			continue
+		}
+		delivered = append(delivered, hit)
 	}
+	return delivered, errors.Join(errs...)
+}
 
-	return false, nil
+// deliverNotification sends the message and records one notification per subscription hit it covers,
+// together with the weather that triggered it, the channel and the provider's message ID
+// Failing to record a notification is only logged, since the message has already gone out
+func (s *SubscriptionService) deliverNotification(ctx context.Context, to string, userID int, hits []notificationHit, subject, body string) (err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.deliverNotification", trace.WithAttributes(
+		attribute.Int("user.id", userID),
+		attribute.String("notification.channel", s.Notifier.Channel()),
+		attribute.Int("notification.subscriptions", len(hits)),
+	))
+	defer tracing.End(span, &err)
+
+	messageID, err := s.Notifier.Send(ctx, to, subject, body)
+	if err != nil {
+		metrics.NotificationsFailed.WithLabelValues(s.Notifier.Channel()).Inc()
+		return fmt.Errorf("failed to send notification to user %s: %w", to, err)
+	}
+	metrics.NotificationsSent.WithLabelValues(s.Notifier.Channel()).Inc()
+	slog.InfoContext(ctx, "Notification sent", "user_id", userID, "to", to, "subscriptions", len(hits))
+
+	// A digest is recorded for all of its subscriptions or for none of them
+	sentAt := time.Now()
+	notifications := make([]models.Notification, len(hits))
+	for i, hit := range hits {
+		notifications[i] = models.Notification{
+			UserId:            userID,
+			SubscriptionId:    hit.SubscriptionId,
+			SentAt:            sentAt,
+			Channel:           s.Notifier.Channel(),
+			ProviderMessageId: messageID,
+			Weather:           hit.Weather,
+		}
+	}
+	slog.DebugContext(ctx, "Creating notifications in DB", "user_id", userID, "count", len(notifications))
+	if err := s.DB.CreateNotifications(ctx, notifications); err != nil {
+		slog.ErrorContext(ctx, "Failed to create notifications in DB", "user_id", userID, "error", err)
+	}
+	return nil
 }
 
-// SendNotificationToUsers sends notifications to users based on their subscriptions
-// It retrieves all subscriptions from the database
-// It checks if the weather condition is met for each subscription
-// If the condition is met, it sends an email notification to the user
-func (s *SubscriptionService) SendNotificationToUsers() error {
-	subscriptions, err := s.DB.GetSubscriptions()
+// SendDeferredNotifications delivers notifications that were held back by quiet hours and are now due
+// Due notifications of a user in digest mode are delivered together as a single digest email
+// Deferred notifications are removed once delivered, or when their user no longer exists;
+// the ones that fail to send are kept and retried on the next run
+func (s *SubscriptionService) SendDeferredNotifications(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.SendDeferredNotifications")
+	defer tracing.End(span, &err)
+
+	due, err := s.DB.GetDueDeferredNotifications(ctx, time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to get subscriptions: %w", err)
+		return fmt.Errorf("failed to get due deferred notifications: %w", err)
 	}
 
-	for _, subscription := range subscriptions {
-		// Check the weather condition
-		met, err := s.CheckCondition(subscription.Condition, subscription.City)
+	byUser := map[int][]models.DeferredNotification{}
+	userOrder := []int{}
+	for _, deferred := range due {
+		if _, ok := byUser[deferred.UserId]; !ok {
+			userOrder = append(userOrder, deferred.UserId)
+		}
+		byUser[deferred.UserId] = append(byUser[deferred.UserId], deferred)
+	}
+
+	for _, userID := range userOrder {
+		user, err := s.DB.GetUserByID(ctx, userID)
 		if err != nil {
-			log.Printf("Error for subscription %d: %v", subscription.Id, err)
+			slog.ErrorContext(ctx, "Failed to get user for deferred notifications", "user_id", userID, "error", err)
 			continue
 		}
 
-		if met {
-			// Send email notification
-			subject := "Weather Update"
-			body := fmt.Sprintf("The weather condition `%s` is met for city `%s`.", subscription.Condition, subscription.City)
-			err = SendEmail(subscription.UserEmail, subject, body, s.Config)
+		done := []int{}
+		if user == nil {
+			for _, deferred := range byUser[userID] {
+				done = append(done, deferred.Id)
+			}
+		} else {
+			hits := make([]notificationHit, len(byUser[userID]))
+			for i, deferred := range byUser[userID] {
+				hits[i] = notificationHit{SubscriptionId: deferred.SubscriptionId, Line: deferred.Body, Weather: deferred.Weather, DeferredId: deferred.Id}
+			}
+			delivered, err := s.deliverHits(ctx, user, hits)
 			if err != nil {
-				log.Printf("Failed to send email to user %s: %v", subscription.UserEmail, err)
-				continue
+				slog.ErrorContext(ctx, "Failed to deliver deferred notifications, retrying on the next run", "user_id", userID, "error", err)
 			}
-			log.Printf("Notification sent to user %s for subscription %d", subscription.UserEmail, subscription.Id)
-
-			// Add new notification to the database
-			notif := models.Notification{
-				UserId:         subscription.UserId,
-				SubscriptionId: subscription.Id,
-				SentAt:         time.Now(),
+			for _, hit := range delivered {
+				done = append(done, hit.DeferredId)
 			}
+		}
 
-			log.Printf("Creating notification in DB for subscription with userId %d and city %s",
-				subscription.UserId, subscription.City)
-			_, err = s.DB.CreateNotification(&notif)
-			if err != nil {
-				log.Printf("Failed to create notification in DB for subscription with userId %d and city %s: %v",
-					subscription.UserId, subscription.City, err)
-				continue
+		for _, id := range done {
+			if err := s.DB.DeleteDeferredNotification(ctx, id); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete deferred notification", "deferred_notification_id", id, "error", err)
 			}
 		}
 	}
 
 	return nil
 }
+
+// PurgeDeleted permanently removes users and subscriptions deleted longer than the retention period ago,
+// together with their notification history
+func (s *SubscriptionService) PurgeDeleted(ctx context.Context, retention time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "SubscriptionService.PurgeDeleted")
+	defer tracing.End(span, &err)
+
+	ctx = audit.WithActor(ctx, audit.SystemActor)
+	var userIDs, subscriptionIDs []int
+	err = s.DB.WithTx(ctx, func(tx database.IDB) error {
+		var err error
+		if userIDs, subscriptionIDs, err = tx.PurgeDeleted(ctx, time.Now().Add(-retention)); err != nil {
+			return err
+		}
+		for _, id := range userIDs {
+			if err := audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionPurge, nil, nil); err != nil {
+				return err
+			}
+		}
+		for _, id := range subscriptionIDs {
+			if err := audit.Record(ctx, tx, models.AuditEntitySubscription, id, models.AuditActionPurge, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to purge deleted rows: %w", err)
+	}
+	if purged := len(userIDs) + len(subscriptionIDs); purged > 0 {
+		slog.InfoContext(ctx, "Purged deleted users and subscriptions", "count", purged)
+	}
+	return nil
+}
+
+// expireSubscriptions deactivates the subscriptions that expired by now and records it in the audit log as the system
+// Returns the number of subscriptions that were deactivated
+func (s *SubscriptionService) expireSubscriptions(ctx context.Context, now time.Time) (int, error) {
+	ctx = audit.WithActor(ctx, audit.SystemActor)
+	var expired []int
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		var err error
+		if expired, err = tx.ExpireSubscriptions(ctx, now); err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := audit.Record(ctx, tx, models.AuditEntitySubscription, id, models.AuditActionExpire, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
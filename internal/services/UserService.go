@@ -2,19 +2,33 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"maxcool.com/weatherapp/internal/audit"
 	"maxcool.com/weatherapp/internal/database"
 	"maxcool.com/weatherapp/internal/models"
 )
 
+// ErrInvalidDeliveryPreferences is returned when a user's time zone or quiet hours settings are invalid
+var ErrInvalidDeliveryPreferences = errors.New("invalid delivery preferences")
+
 type IUserService interface {
-	GetUserByID(id int) (*models.User, error)
-	CreateUser(user *models.User) error
-	UpdateUser(user *models.User) error
-	DeleteUser(id int) error
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	GetUsers(ctx context.Context) ([]models.User, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	DeleteUser(ctx context.Context, id int) (bool, error)
+	UpdateDeliveryPreferences(ctx context.Context, id int, prefs *models.DeliveryPreferences) (*models.User, error)
+	ExportUser(ctx context.Context, id int) (*models.UserExport, error)
+	EraseUser(ctx context.Context, id int) (bool, error)
 }
 
+// exportPageSize is the number of notifications read at a time when exporting a user's history
+const exportPageSize = 500
+
 type UserService struct {
 	DB database.IDB
 }
@@ -25,45 +39,203 @@ func NewUserService(db database.IDB) *UserService {
 }
 
 // GetUserByID retrieves a user by their ID
-func (s *UserService) GetUserByID(id int) (*models.User, error) {
-	user, err := s.DB.GetUserByID(id)
+func (s *UserService) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	user, err := s.DB.GetUserByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by ID: %w", err)
 	}
 	return user, nil
 }
 
+// GetUsers retrieves all users
+func (s *UserService) GetUsers(ctx context.Context) ([]models.User, error) {
+	users, err := s.DB.GetUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
 // GetUserByEmail retrieves a user by their email
-func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
-	user, err := s.DB.GetUserByEmail(email)
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.DB.GetUserByEmail(ctx, email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
 	return user, nil
 }
 
-// CreateUser creates a new user
-func (s *UserService) CreateUser(user *models.User) error {
-	id, err := s.DB.CreateUser(user)
+// CreateUser creates a new user and records it in the audit log
+func (s *UserService) CreateUser(ctx context.Context, user *models.User) error {
+	if err := NormalizeDeliveryPreferences(user); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDeliveryPreferences, err)
+	}
+
+	return s.DB.WithTx(ctx, func(tx database.IDB) error {
+		id, err := tx.CreateUser(ctx, user)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		user.Id = id
+		return audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionCreate, nil, user)
+	})
+}
+
+// UpdateUser updates an existing user and records the change in the audit log
+// Updating a user that does not exist does nothing
+func (s *UserService) UpdateUser(ctx context.Context, user *models.User) error {
+	return s.DB.WithTx(ctx, func(tx database.IDB) error {
+		before, err := tx.GetUserByID(ctx, user.Id)
+		if err != nil {
+			return fmt.Errorf("failed to get user by ID: %w", err)
+		}
+		if before == nil {
+			return nil
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return audit.Record(ctx, tx, models.AuditEntityUser, user.Id, models.AuditActionUpdate, before, user)
+	})
+}
+
+// DeleteUser deletes a user by their ID and records the deletion in the audit log
+// It returns false if the user does not exist, in which case nothing is done
+func (s *UserService) DeleteUser(ctx context.Context, id int) (bool, error) {
+	var deleted bool
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		before, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get user by ID: %w", err)
+		}
+		if before == nil {
+			return nil
+		}
+		if err := tx.DeleteUser(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		deleted = true
+		return audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionDelete, before, nil)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create user: %w", err)
+		return false, err
 	}
-	user.Id = id
-	return nil
+	return deleted, nil
 }
 
-// UpdateUser updates an existing user
-func (s *UserService) UpdateUser(user *models.User) error {
-	if err := s.DB.UpdateUser(user); err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+// ExportUser collects the personal data held about a user and records the export in the audit log
+// Deleted users and subscriptions are exported too, as their data is held until they are purged
+// It returns nil if the user does not exist
+func (s *UserService) ExportUser(ctx context.Context, id int) (*models.UserExport, error) {
+	var export *models.UserExport
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		user, err := tx.GetUserIncludingDeleted(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get user by ID: %w", err)
+		}
+		if user == nil {
+			return nil
+		}
+
+		result := &models.UserExport{
+			ExportedAt:    time.Now().UTC(),
+			User:          models.NewUserResponse(user),
+			Subscriptions: []models.SubscriptionResponse{},
+			Notifications: []models.Notification{},
+		}
+		filter := models.SubscriptionFilter{UserId: id, Limit: exportPageSize, IncludeDeleted: true}
+		for {
+			subscriptions, err := tx.ListSubscriptions(ctx, filter)
+			if err != nil {
+				return fmt.Errorf("failed to get subscriptions by user ID: %w", err)
+			}
+			for i := range subscriptions {
+				result.Subscriptions = append(result.Subscriptions, models.NewSubscriptionResponse(&subscriptions[i]))
+			}
+			if len(subscriptions) < exportPageSize {
+				break
+			}
+			filter.AfterId = subscriptions[len(subscriptions)-1].Id
+		}
+
+		// The whole history is exported, not just one page of it
+		for filter := (models.NotificationFilter{Limit: exportPageSize}); ; filter.Offset += exportPageSize {
+			notifications, err := tx.GetNotificationsByUserID(ctx, id, filter)
+			if err != nil {
+				return fmt.Errorf("failed to get notifications by user ID: %w", err)
+			}
+			result.Notifications = append(result.Notifications, notifications...)
+			if len(notifications) < exportPageSize {
+				break
+			}
+		}
+
+		export = result
+		return audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionExport, nil, nil)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return export, nil
 }
 
-// DeleteUser deletes a user by their ID
-func (s *UserService) DeleteUser(id int) error {
-	if err := s.DB.DeleteUser(id); err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+// EraseUser permanently removes a user, even one that is already deleted, with all of its personal data:
+// subscriptions, notification history with the provider message IDs, and the states and API client actors recorded in the audit log
+// The erasure itself is recorded in the audit log without any personal data
+// It reports whether the user existed
+func (s *UserService) EraseUser(ctx context.Context, id int) (bool, error) {
+	var erased bool
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		var err error
+		if erased, err = tx.EraseUser(ctx, id); err != nil {
+			return fmt.Errorf("failed to erase user: %w", err)
+		}
+		if !erased {
+			return nil
+		}
+		return audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionErase, nil, nil)
+	})
+	if err != nil {
+		return false, err
+	}
+	return erased, nil
+}
+
+// UpdateDeliveryPreferences replaces the time zone, quiet hours and digest settings of a user
+// and records the change in the audit log
+// It returns the updated user, or nil if the user does not exist
+func (s *UserService) UpdateDeliveryPreferences(ctx context.Context, id int, prefs *models.DeliveryPreferences) (*models.User, error) {
+	var updated *models.User
+	err := s.DB.WithTx(ctx, func(tx database.IDB) error {
+		user, err := tx.GetUserByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get user by ID: %w", err)
+		}
+		if user == nil {
+			return nil
+		}
+		before := *user
+
+		user.Timezone = prefs.Timezone
+		user.QuietHoursStart = prefs.QuietHoursStart
+		user.QuietHoursEnd = prefs.QuietHoursEnd
+		user.QuietHoursPolicy = prefs.QuietHoursPolicy
+		user.DigestMode = prefs.DigestMode
+		if err := NormalizeDeliveryPreferences(user); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDeliveryPreferences, err)
+		}
+
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if err := audit.Record(ctx, tx, models.AuditEntityUser, id, models.AuditActionUpdate, &before, user); err != nil {
+			return err
+		}
+		updated = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return updated, nil
 }
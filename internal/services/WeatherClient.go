@@ -0,0 +1,188 @@
+// internal/services/WeatherClient.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/metrics"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/tracing"
+)
+
+// ErrCityNotFound is returned when the provider does not know the requested city
+var ErrCityNotFound = errors.New("city not found")
+
+// WeatherClient talks to the OpenWeatherMap current weather API
+// Its settings can be replaced with Update while requests are in flight
+type WeatherClient struct {
+	BaseURL string
+	APIKey  string
+	HTTP    *http.Client
+
+	mu sync.RWMutex
+}
+
+// NewWeatherClient creates a WeatherClient using the provider URL, API key and timeout from the configuration
+func NewWeatherClient(cfg *config.Config) *WeatherClient {
+	return &WeatherClient{
+		BaseURL: cfg.OpenWeatherMapBaseURL,
+		APIKey:  cfg.OpenWeatherMapAPIKey,
+		HTTP:    &http.Client{Timeout: cfg.WeatherRequestTimeout, Transport: tracing.Transport(nil)},
+	}
+}
+
+// Update replaces the provider URL, API key and timeout with the ones from the configuration
+// Requests already in flight finish with the previous settings
+func (c *WeatherClient) Update(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.BaseURL = cfg.OpenWeatherMapBaseURL
+	c.APIKey = cfg.OpenWeatherMapAPIKey
+	c.HTTP = &http.Client{Timeout: cfg.WeatherRequestTimeout, Transport: tracing.Transport(nil)}
+}
+
+// get requests the current weather for a city in metric units
+// The API key is sent as a query parameter, so the request URL is never logged,
+// and tracing.Transport leaves the query string out of the outbound span
+func (c *WeatherClient) get(ctx context.Context, city string) (*http.Response, error) {
+	c.mu.RLock()
+	baseURL, apiKey, client := c.BaseURL, c.APIKey, c.HTTP
+	c.mu.RUnlock()
+
+	query := url.Values{}
+	query.Set("q", city)
+	query.Set("appid", apiKey)
+	query.Set("units", "metric")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating weather request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	duration := time.Since(start)
+	metrics.WeatherRequestDuration.Observe(duration.Seconds())
+	if err != nil {
+		metrics.WeatherRequests.WithLabelValues("error").Inc()
+		// Errors from the HTTP client include the request URL, drop it to keep the API key out of logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		slog.ErrorContext(ctx, "Error fetching weather data", "city", city, "error", err)
+		return nil, fmt.Errorf("error fetching weather data: %w", err)
+	}
+	metrics.WeatherRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	slog.DebugContext(ctx, "Weather request", "city", city, "status", resp.StatusCode, "duration", duration)
+	return resp, nil
+}
+
+// GetWeather retrieves the weather data for a given city
+// It returns an error wrapping ErrCityNotFound if the provider does not know the city,
+// and another error if the weather data cannot be fetched
+func (c *WeatherClient) GetWeather(ctx context.Context, city string) (models.WeatherResponse, error) {
+	resp, err := c.get(ctx, city)
+	if err != nil {
+		return models.WeatherResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		slog.InfoContext(ctx, "City not found", "city", city)
+		return models.WeatherResponse{}, fmt.Errorf("%w: %s", ErrCityNotFound, city)
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "Error fetching weather data", "city", city, "status", resp.Status)
+		return models.WeatherResponse{}, fmt.Errorf("error fetching weather data: %s", resp.Status)
+	}
+
+	// Read the response body
+	var weatherResponse models.WeatherResponse
+	decoder := json.NewDecoder(resp.Body)
+	err = decoder.Decode(&weatherResponse)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error decoding weather data", "city", city, "error", err)
+		return models.WeatherResponse{}, err
+	}
+	return weatherResponse, nil
+}
+
+// CityExists reports whether the provider knows the given city
+// It returns an error if the provider cannot be reached or answers with an unexpected status
+func (c *WeatherClient) CityExists(ctx context.Context, city string) (bool, error) {
+	slog.DebugContext(ctx, "Checking whether city exists", "city", city)
+	resp, err := c.get(ctx, city)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			slog.InfoContext(ctx, "City not found", "city", city)
+			return false, nil
+		}
+		slog.ErrorContext(ctx, "Error fetching weather data", "city", city, "status", resp.Status)
+		return false, fmt.Errorf("error fetching weather data: %s", resp.Status)
+	}
+
+	return true, nil
+}
+
+// probeCity is the city requested to check that the provider is reachable and accepts the API key
+const probeCity = "London"
+
+// Ping checks that the provider is reachable and accepts the API key
+func (c *WeatherClient) Ping(ctx context.Context) error {
+	resp, err := c.get(ctx, probeCity)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("weather provider answered %s", resp.Status)
+	}
+	return nil
+}
+
+// weatherCache remembers the weather per city for the duration of a notification run,
+// so subscriptions for the same city share a single provider request
+type weatherCache struct {
+	fetch   func(ctx context.Context, city string) (models.WeatherResponse, error)
+	entries map[string]models.WeatherResponse
+}
+
+// newWeatherCache creates an empty cache that fetches missing cities with fetch
+func newWeatherCache(fetch func(ctx context.Context, city string) (models.WeatherResponse, error)) *weatherCache {
+	return &weatherCache{fetch: fetch, entries: map[string]models.WeatherResponse{}}
+}
+
+// Get returns the cached weather for the city, fetching it on the first lookup
+// Failed lookups are not cached
+func (c *weatherCache) Get(ctx context.Context, city string) (models.WeatherResponse, error) {
+	key := strings.ToLower(strings.TrimSpace(city))
+	if weather, ok := c.entries[key]; ok {
+		metrics.WeatherCacheLookups.WithLabelValues("hit").Inc()
+		return weather, nil
+	}
+	metrics.WeatherCacheLookups.WithLabelValues("miss").Inc()
+
+	weather, err := c.fetch(ctx, city)
+	if err != nil {
+		return models.WeatherResponse{}, err
+	}
+	c.entries[key] = weather
+	return weather, nil
+}
@@ -0,0 +1,273 @@
+// internal/tests/Audit_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/audit"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/ratelimit"
+	"maxcool.com/weatherapp/internal/server"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// expectAudit expects an audit event of the given entity and action to be recorded
+func expectAudit(mockDB *MockDB, entityType string, entityID int, action string) *mock.Call {
+	return mockDB.On("CreateAuditEvent", mock.MatchedBy(func(e *models.AuditEvent) bool {
+		return e.EntityType == entityType && e.EntityId == entityID && e.Action == action
+	})).Return(1, nil)
+}
+
+// failingAuditDB is an IDB that cannot write to the audit log
+type failingAuditDB struct {
+	database.IDB
+}
+
+func (db failingAuditDB) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) (int, error) {
+	return 0, errors.New("audit log unavailable")
+}
+
+func (db failingAuditDB) WithTx(ctx context.Context, fn func(tx database.IDB) error) error {
+	return db.IDB.WithTx(ctx, func(tx database.IDB) error {
+		return fn(failingAuditDB{tx})
+	})
+}
+
+func TestAuditActor(t *testing.T) {
+	assert.Equal(t, audit.SystemActor, audit.Actor(context.Background()))
+	assert.Equal(t, "cli:admin", audit.Actor(audit.WithActor(context.Background(), "cli:admin")))
+}
+
+func TestAuditMiddleware_IdentifiesClient(t *testing.T) {
+	var actor string
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = audit.Actor(r.Context())
+	})
+
+	request := httptest.NewRequest("POST", "/user", nil)
+	request.RemoteAddr = "203.0.113.7:51234"
+	request.Header.Set("X-Forwarded-For", "198.51.100.1")
+	clients := ratelimit.NewClients(&config.Config{APIKeys: "secret"})
+	audit.Middleware(clients)(capture).ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "anonymous:ip:203.0.113.7", actor)
+
+	audit.Middleware(ratelimit.NewClients(&config.Config{RateLimitTrustProxy: true}))(capture).ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "anonymous:ip:198.51.100.1", actor)
+
+	request.Header.Set("X-API-Key", "secret")
+	audit.Middleware(clients)(capture).ServeHTTP(httptest.NewRecorder(), request)
+	assert.Regexp(t, `^api:key:[0-9a-f]{16}$`, actor)
+	assert.NotContains(t, actor, "secret")
+
+	// A key that is not configured cannot pick the actor
+	request.Header.Set("X-API-Key", "made-up")
+	audit.Middleware(clients)(capture).ServeHTTP(httptest.NewRecorder(), request)
+	assert.Equal(t, "anonymous:ip:203.0.113.7", actor)
+}
+
+func TestRequireAdmin_RecordsAdminActor(t *testing.T) {
+	router, db, user := newUserAdminRouter(t)
+
+	require.Equal(t, http.StatusNoContent, serveAdmin(router, "DELETE", fmt.Sprintf("/users/%d", user.Id)).Code)
+
+	events, err := db.GetAuditEvents(context.Background(), models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: user.Id, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditActionDelete, events[0].Action)
+	assert.Equal(t, audit.AdminActor, events[0].Actor)
+}
+
+func TestScheduledJobs_RecordAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewMemoryDB()
+	subscriptionService := services.NewSubscriptionService(db, nil)
+	user := &models.User{Name: "Jane Doe", Email: "jane@example.com", Timezone: "UTC", QuietHoursPolicy: models.QuietHoursPolicyDefer}
+	_, err := db.CreateUser(ctx, user)
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(-time.Hour)
+	expiring := &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "main:rain", UserEmail: user.Email, Active: true, ExpiresAt: &expiresAt}
+	_, err = db.CreateSubscription(ctx, expiring)
+	require.NoError(t, err)
+
+	// Requests made by an API client do not lend it the jobs' changes
+	ctx = audit.WithActor(ctx, "api:ip:203.0.113.7")
+	require.NoError(t, subscriptionService.SendNotificationToUsers(ctx))
+	require.NoError(t, db.DeleteUser(ctx, user.Id))
+	require.NoError(t, subscriptionService.PurgeDeleted(ctx, -time.Second))
+
+	events, err := db.GetAuditEvents(ctx, models.AuditFilter{Actor: audit.SystemActor, Limit: 10})
+	require.NoError(t, err)
+	actions := map[string]string{}
+	for _, e := range events {
+		actions[fmt.Sprintf("%s %d", e.EntityType, e.EntityId)] += e.Action + " "
+		assert.Nil(t, e.Before)
+		assert.Nil(t, e.After)
+	}
+	assert.Equal(t, map[string]string{
+		fmt.Sprintf("subscription %d", expiring.Id): "purge expire ",
+		fmt.Sprintf("user %d", user.Id):             "purge ",
+	}, actions)
+}
+
+func TestUserService_RecordsAuditEvents(t *testing.T) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(db)
+	ctx := logging.WithRequestID(audit.WithActor(context.Background(), "api:ip:203.0.113.7"), "req-1")
+
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, userService.CreateUser(ctx, user))
+	_, err := userService.UpdateDeliveryPreferences(ctx, user.Id, &models.DeliveryPreferences{Timezone: "Europe/Kyiv"})
+	require.NoError(t, err)
+	found, err := userService.DeleteUser(context.Background(), user.Id)
+	require.NoError(t, err)
+	require.True(t, found)
+	found, err = userService.DeleteUser(ctx, user.Id)
+	require.NoError(t, err, "deleting a missing user")
+	require.False(t, found)
+
+	events, err := db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: user.Id, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	deleted, updated, created := events[0], events[1], events[2]
+	assert.Equal(t, models.AuditActionCreate, created.Action)
+	assert.Equal(t, "api:ip:203.0.113.7", created.Actor)
+	assert.Equal(t, "req-1", created.RequestId)
+	assert.Nil(t, created.Before)
+	assert.Contains(t, string(created.After), `"email":"jane.doe@example.com"`)
+
+	assert.Equal(t, models.AuditActionUpdate, updated.Action)
+	assert.Contains(t, string(updated.Before), `"timezone":"UTC"`)
+	assert.Contains(t, string(updated.After), `"timezone":"Europe/Kyiv"`)
+
+	assert.Equal(t, models.AuditActionDelete, deleted.Action)
+	assert.Equal(t, audit.SystemActor, deleted.Actor)
+	assert.Empty(t, deleted.RequestId)
+	assert.Contains(t, string(deleted.Before), `"timezone":"Europe/Kyiv"`)
+	assert.Nil(t, deleted.After)
+}
+
+func TestSubscriptionService_RecordsAuditEvents(t *testing.T) {
+	db := database.NewMemoryDB()
+	ctx := audit.WithActor(context.Background(), "cli:admin")
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, services.NewUserService(db).CreateUser(ctx, user))
+	subscriptionService := services.NewSubscriptionService(db, nil)
+
+	subscription := &models.Subscription{City: "Kyiv", Condition: "temperature:>:30", UserEmail: user.Email}
+	require.NoError(t, subscriptionService.CreateSubscription(ctx, subscription))
+	_, err := subscriptionService.PauseSubscription(ctx, subscription.Id, nil)
+	require.NoError(t, err)
+	_, err = subscriptionService.ResumeSubscription(ctx, subscription.Id)
+	require.NoError(t, err)
+	require.NoError(t, subscriptionService.DeleteSubscription(ctx, subscription.Id))
+
+	events, err := db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntitySubscription, EntityId: subscription.Id, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Action)
+		assert.Equal(t, "cli:admin", e.Actor)
+	}
+	assert.Equal(t, []string{models.AuditActionDelete, models.AuditActionUpdate, models.AuditActionUpdate, models.AuditActionCreate}, actions)
+	assert.Contains(t, string(events[2].Before), `"active":true`)
+	assert.Contains(t, string(events[2].After), `"active":false`)
+}
+
+func TestAuditFailureRollsBackTheChange(t *testing.T) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(failingAuditDB{db})
+
+	err := userService.CreateUser(context.Background(), &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"})
+
+	assert.ErrorContains(t, err, "audit log unavailable")
+	users, err := db.GetUsers(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func newAdminRouter(mockDB *MockDB, adminToken string) http.Handler {
+	handler := &handlers.Handler{Config: &config.Config{AdminToken: adminToken}, AuditService: services.NewAuditService(mockDB)}
+	return server.NewRouter(handler, nil)
+}
+
+func adminRequest(router http.Handler, path, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetAuditEventsHandler(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminRouter(mockDB, "secret")
+	events := []models.AuditEvent{{Id: 2, EntityType: models.AuditEntityUser, EntityId: 5, Action: models.AuditActionUpdate, Actor: "cli:admin",
+		Before: json.RawMessage(`{"name":"Old"}`), After: json.RawMessage(`{"name":"New"}`)}}
+	mockDB.On("GetAuditEvents", models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: 5, Limit: 10, Offset: 0}).Return(events, nil)
+
+	recorder := adminRequest(router, "/admin/audit?entity_type=user&entity_id=5&limit=10", "secret")
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var page models.AuditPage
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Equal(t, 10, page.Limit)
+	require.Len(t, page.Events, 1)
+	assert.JSONEq(t, `{"name":"New"}`, string(page.Events[0].After))
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetAuditEventsHandler_ByActor(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminRouter(mockDB, "secret")
+	mockDB.On("GetAuditEvents", models.AuditFilter{Actor: "api:ip:203.0.113.7", Limit: services.DefaultAuditPageSize}).Return([]models.AuditEvent{}, nil)
+
+	recorder := adminRequest(router, "/admin/audit?actor=api:ip:203.0.113.7", "secret")
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetAuditEventsHandler_RequiresToken(t *testing.T) {
+	mockDB := new(MockDB)
+
+	recorder := adminRequest(newAdminRouter(mockDB, ""), "/admin/audit", "")
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "disabled without ADMIN_TOKEN")
+
+	router := newAdminRouter(mockDB, "secret")
+	recorder = adminRequest(router, "/admin/audit", "")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+	recorder = adminRequest(router, "/admin/audit", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	mockDB.AssertNotCalled(t, "GetAuditEvents", mock.Anything)
+}
+
+func TestGetAuditEventsHandler_InvalidFilter(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminRouter(mockDB, "secret")
+
+	for _, query := range []string{"entity_type=notification", "entity_id=5", "entity_type=user&entity_id=x", "limit=0", "offset=-1"} {
+		recorder := adminRequest(router, "/admin/audit?"+query, "secret")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+	mockDB.AssertNotCalled(t, "GetAuditEvents", mock.Anything)
+}
@@ -0,0 +1,78 @@
+// internal/tests/Condition_test.go
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func weatherFixture() models.WeatherResponse {
+	var weather models.WeatherResponse
+	weather.Weather = append(weather.Weather, struct {
+		Id          int    `json:"id"`
+		Main        string `json:"main"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	}{Id: 800, Main: "Clear", Description: "clear sky"})
+	weather.Main.Temp = 21.5
+	weather.Main.Feels_like = 20
+	weather.Main.Humidity = 40
+	return weather
+}
+
+func TestParseCondition(t *testing.T) {
+	parsed, err := services.ParseCondition("temperature:<=:35")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "temperature", parsed.Property)
+	assert.Equal(t, "<=", parsed.Operator)
+	assert.Equal(t, 35.0, *parsed.Value)
+
+	parsed, err = services.ParseCondition("main:clear")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "main", parsed.Property)
+	assert.Equal(t, "clear", parsed.Main)
+
+	// Extra segments of a main condition are ignored
+	parsed, err = services.ParseCondition("main:rain:heavy")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "rain", parsed.Main)
+}
+
+func TestParseCondition_Invalid(t *testing.T) {
+	for _, condition := range []string{"", "temperature", "temperature:<=", "pressure:>:1000", "humidity:=>:20", "feels_like:==:warm", "main:"} {
+		err := services.ValidateCondition(condition)
+		assert.ErrorIs(t, err, services.ErrInvalidCondition, condition)
+	}
+}
+
+func TestEvaluateParsedCondition_Numeric(t *testing.T) {
+	parsed, err := services.ParseCondition("temperature:>:30")
+	assert.NoError(t, err)
+
+	values, result, err := services.EvaluateParsedCondition(parsed, weatherFixture())
+
+	assert.NoError(t, err)
+	assert.False(t, result)
+	assert.Equal(t, []models.ExpressionValue{
+		{Expression: "temperature", Value: 21.5},
+		{Expression: "30", Value: 30.0},
+		{Expression: "temperature > 30", Value: false},
+	}, values)
+}
+
+func TestEvaluateParsedCondition_Main(t *testing.T) {
+	parsed, err := services.ParseCondition("main:clear")
+	assert.NoError(t, err)
+
+	values, result, err := services.EvaluateParsedCondition(parsed, weatherFixture())
+
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, "Clear", values[0].Value)
+}
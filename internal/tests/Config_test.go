@@ -0,0 +1,234 @@
+// internal/tests/Config_test.go
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/config"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	t.Setenv("POSTGRES_CONNECTION_STRING", "postgres://localhost/weatherapp")
+
+	cfg, args, err := config.Load([]string{"serve"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"serve"}, args)
+	assert.Equal(t, "8080", cfg.ServerPort)
+	assert.Equal(t, 25, cfg.DBMaxOpenConns)
+	assert.Equal(t, 5*time.Minute, cfg.DBConnMaxLifetime)
+	assert.Equal(t, "weatherapp@resend.dev", cfg.EmailFrom)
+	assert.Equal(t, "12:00", cfg.NotificationTime)
+
+	// Routes that create records or call the weather provider are limited out of the box
+	limits, err := cfg.RouteRateLimits()
+	assert.NoError(t, err)
+	for _, route := range []string{"POST /user", "POST /subscribe", "POST /conditions/evaluate", "GET /weather"} {
+		assert.Contains(t, limits, route)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weatherapp.yaml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\nport: 9000\nemail_from: file@example.com\nweather_request_timeout: 3s\n"), 0o600)
+	assert.NoError(t, err)
+
+	t.Setenv("PORT", "9001")
+	t.Setenv("EMAIL_FROM", "env@example.com")
+
+	cfg, _, err := config.Load([]string{"--config", file, "--email-from", "flag@example.com"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres://file/weatherapp", cfg.PostgresConnectionString) // file only
+	assert.Equal(t, 3*time.Second, cfg.WeatherRequestTimeout)                   // file only
+	assert.Equal(t, "9001", cfg.ServerPort)                                     // env beats file
+	assert.Equal(t, "flag@example.com", cfg.EmailFrom)                          // flag beats env
+}
+
+func TestLoadConfig_FileFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"weatherapp.yaml": "postgres_connection_string: postgres://file/weatherapp\nport: 9000\nweather_request_timeout: 3s\nrate_limit_enabled: false\n",
+		"weatherapp.yml":  "postgres_connection_string: postgres://file/weatherapp\nport: 9000\nweather_request_timeout: 3s\nrate_limit_enabled: false\n",
+		"weatherapp.toml": "postgres_connection_string = \"postgres://file/weatherapp\"\nport = 9000\nweather_request_timeout = \"3s\"\nrate_limit_enabled = false\n",
+	} {
+		file := filepath.Join(t.TempDir(), name)
+		err := os.WriteFile(file, []byte(content), 0o600)
+		assert.NoError(t, err)
+
+		cfg, _, err := config.Load([]string{"--config", file})
+
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "postgres://file/weatherapp", cfg.PostgresConnectionString, name)
+			assert.Equal(t, "9000", cfg.ServerPort, name)
+			assert.Equal(t, 3*time.Second, cfg.WeatherRequestTimeout, name)
+			assert.False(t, cfg.RateLimitEnabled, name)
+		}
+	}
+
+	// A TOML file is not read as YAML
+	file := filepath.Join(t.TempDir(), "weatherapp.toml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\n"), 0o600)
+	assert.NoError(t, err)
+	_, _, err = config.Load([]string{"--config", file})
+	assert.ErrorContains(t, err, "error parsing config file")
+}
+
+func TestLoadConfig_ReportsAllProblems(t *testing.T) {
+	t.Setenv("POSTGRES_CONNECTION_STRING", "")
+	t.Setenv("PORT", "not-a-port")
+	t.Setenv("DB_MAX_OPEN_CONNS", "many")
+	t.Setenv("SHUTDOWN_TIMEOUT", "-1s")
+	t.Setenv("NOTIFICATION_TIME", "noon")
+
+	_, _, err := config.Load(nil)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "POSTGRES_CONNECTION_STRING not set")
+	assert.Contains(t, err.Error(), "PORT")
+	assert.Contains(t, err.Error(), "DB_MAX_OPEN_CONNS")
+	assert.Contains(t, err.Error(), "SHUTDOWN_TIMEOUT")
+	assert.Contains(t, err.Error(), "NOTIFICATION_TIME")
+}
+
+func TestLoadConfig_DatabaseDriver(t *testing.T) {
+	t.Setenv("POSTGRES_CONNECTION_STRING", "postgres://localhost/weatherapp")
+
+	cfg, _, err := config.Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "stdlib", cfg.DBDriver)
+	assert.Equal(t, "cache_statement", cfg.DBQueryExecMode)
+	assert.Equal(t, 512, cfg.DBStatementCacheCapacity)
+
+	t.Setenv("DB_DRIVER", "pgxpool")
+	t.Setenv("DB_MIN_CONNS", "5")
+	t.Setenv("DB_QUERY_EXEC_MODE", "simple_protocol")
+	cfg, _, err = config.Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "pgxpool", cfg.DBDriver)
+	assert.Equal(t, 5, cfg.DBMinConns)
+
+	t.Setenv("DB_DRIVER", "mysql")
+	t.Setenv("DB_MIN_CONNS", "100")
+	t.Setenv("DB_QUERY_EXEC_MODE", "prepare")
+	_, _, err = config.Load(nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "DB_DRIVER")
+	assert.Contains(t, err.Error(), "DB_MIN_CONNS")
+	assert.Contains(t, err.Error(), "DB_QUERY_EXEC_MODE")
+}
+
+func TestLoadConfig_SQLite(t *testing.T) {
+	t.Setenv("POSTGRES_CONNECTION_STRING", "sqlite://./weatherapp.db")
+
+	cfg, _, err := config.Load(nil)
+	assert.NoError(t, err)
+	assert.True(t, cfg.UsesSQLite())
+
+	t.Setenv("DB_DRIVER", "pgxpool")
+	_, _, err = config.Load(nil)
+	assert.ErrorContains(t, err, "DB_DRIVER")
+
+	t.Setenv("POSTGRES_CONNECTION_STRING", "postgres://localhost/weatherapp")
+	cfg, _, err = config.Load(nil)
+	assert.NoError(t, err)
+	assert.False(t, cfg.UsesSQLite())
+}
+
+func TestLoadConfig_UnknownFileSetting(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weatherapp.yaml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\nsmtp_host: mail\n"), 0o600)
+	assert.NoError(t, err)
+
+	_, _, err = config.Load([]string{"--config", file})
+
+	assert.ErrorContains(t, err, "unknown setting")
+}
+
+func TestConfigReload_ReportsChangedSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weatherapp.yaml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\nnotification_time: \"08:00\"\n"), 0o600)
+	assert.NoError(t, err)
+
+	cfg, _, err := config.Load([]string{"--config", file})
+	assert.NoError(t, err)
+
+	err = os.WriteFile(file, []byte("postgres_connection_string: postgres://file/other\nnotification_time: \"09:30\"\nlog_level: debug\n"), 0o600)
+	assert.NoError(t, err)
+
+	reloaded, err := cfg.Reload()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "08:00", cfg.NotificationTime) // the running configuration is untouched
+	assert.Equal(t, "09:30", reloaded.NotificationTime)
+	changed, restart := cfg.Diff(reloaded)
+	assert.Equal(t, []string{"NotificationTime", "LogLevel"}, changed)
+	assert.Equal(t, []string{"PostgresConnectionString"}, restart)
+}
+
+func TestConfigReload_KeepsRestartSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weatherapp.yaml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\nnotification_time: \"08:00\"\n"), 0o600)
+	assert.NoError(t, err)
+	cfg, _, err := config.Load([]string{"--config", file})
+	assert.NoError(t, err)
+
+	err = os.WriteFile(file, []byte("postgres_connection_string: postgres://file/other\nnotification_time: \"09:30\"\n"), 0o600)
+	assert.NoError(t, err)
+	reloaded, err := cfg.Reload()
+	assert.NoError(t, err)
+	running := cfg.WithReloadable(reloaded)
+
+	assert.Equal(t, "09:30", running.NotificationTime)
+	assert.Equal(t, "postgres://file/weatherapp", running.PostgresConnectionString)
+	assert.Equal(t, file, running.ConfigFile())
+
+	// The next reload still reports the setting that needs a restart
+	reloaded, err = running.Reload()
+	assert.NoError(t, err)
+	changed, restart := running.Diff(reloaded)
+	assert.Empty(t, changed)
+	assert.Equal(t, []string{"PostgresConnectionString"}, restart)
+}
+
+func TestConfigReload_InvalidFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "weatherapp.yaml")
+	err := os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\n"), 0o600)
+	assert.NoError(t, err)
+
+	cfg, _, err := config.Load([]string{"--config", file})
+	assert.NoError(t, err)
+
+	err = os.WriteFile(file, []byte("postgres_connection_string: postgres://file/weatherapp\nlog_level: loud\n"), 0o600)
+	assert.NoError(t, err)
+
+	_, err = cfg.Reload()
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "LOG_LEVEL")
+}
+
+func TestConfigReload_RereadsDotEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("POSTGRES_CONNECTION_STRING", "postgres://localhost/weatherapp")
+	t.Setenv("EMAIL_FROM", "env@example.com")
+	err := os.WriteFile(".env", []byte("NOTIFICATION_TIME=08:00\nEMAIL_FROM=dotenv@example.com\n"), 0o600)
+	assert.NoError(t, err)
+
+	cfg, _, err := config.Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "08:00", cfg.NotificationTime)
+	assert.Equal(t, "env@example.com", cfg.EmailFrom) // env beats .env
+
+	err = os.WriteFile(".env", []byte("NOTIFICATION_TIME=09:30\nEMAIL_FROM=dotenv@example.com\n"), 0o600)
+	assert.NoError(t, err)
+
+	reloaded, err := cfg.Reload()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "09:30", reloaded.NotificationTime)
+	assert.Equal(t, "env@example.com", reloaded.EmailFrom)
+}
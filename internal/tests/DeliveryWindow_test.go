@@ -0,0 +1,85 @@
+// internal/tests/DeliveryWindow_test.go
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func TestNextDeliveryTime_NoQuietHours(t *testing.T) {
+	user := &models.User{Timezone: "UTC"}
+	now := time.Date(2025, 6, 1, 23, 30, 0, 0, time.UTC)
+
+	next, allowed, err := services.NextDeliveryTime(user, now)
+
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, now, next)
+}
+
+func TestNextDeliveryTime_OutsideQuietHours(t *testing.T) {
+	user := &models.User{Timezone: "UTC", QuietHoursStart: "22:00", QuietHoursEnd: "07:00"}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	next, allowed, err := services.NextDeliveryTime(user, now)
+
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, now, next)
+}
+
+func TestNextDeliveryTime_QuietHoursSpanningMidnight(t *testing.T) {
+	user := &models.User{Timezone: "UTC", QuietHoursStart: "22:00", QuietHoursEnd: "07:00"}
+
+	// Before midnight the window ends the next morning
+	next, allowed, err := services.NextDeliveryTime(user, time.Date(2025, 6, 1, 23, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Date(2025, 6, 2, 7, 0, 0, 0, time.UTC), next)
+
+	// After midnight the window ends the same morning
+	next, allowed, err = services.NextDeliveryTime(user, time.Date(2025, 6, 2, 3, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Date(2025, 6, 2, 7, 0, 0, 0, time.UTC), next)
+}
+
+func TestNextDeliveryTime_UsesUserTimezone(t *testing.T) {
+	user := &models.User{Timezone: "Europe/Kyiv", QuietHoursStart: "12:00", QuietHoursEnd: "14:00"}
+	kyiv, err := time.LoadLocation("Europe/Kyiv")
+	assert.NoError(t, err)
+
+	// 10:00 UTC is 13:00 in Kyiv during summer time
+	next, allowed, err := services.NextDeliveryTime(user, time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC))
+
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.True(t, time.Date(2025, 6, 1, 14, 0, 0, 0, kyiv).Equal(next))
+}
+
+func TestNormalizeDeliveryPreferences_Defaults(t *testing.T) {
+	user := &models.User{Name: "John Doe", Email: "john.doe@example.com"}
+
+	err := services.NormalizeDeliveryPreferences(user)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "UTC", user.Timezone)
+	assert.Equal(t, models.QuietHoursPolicyDefer, user.QuietHoursPolicy)
+}
+
+func TestNormalizeDeliveryPreferences_Invalid(t *testing.T) {
+	cases := []models.User{
+		{Timezone: "Mars/Olympus"},
+		{QuietHoursStart: "22:00"},
+		{QuietHoursStart: "25:00", QuietHoursEnd: "07:00"},
+		{QuietHoursPolicy: "sometimes"},
+	}
+
+	for _, user := range cases {
+		assert.Error(t, services.NormalizeDeliveryPreferences(&user))
+	}
+}
@@ -0,0 +1,465 @@
+// internal/tests/Handlers_test.go
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/server"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func newTestRouter(t *testing.T, mockDB *MockDB) http.Handler {
+	return newConfiguredTestRouter(t, mockDB, nil)
+}
+
+// newAdminTestRouter is newTestRouter with the admin token "secret", see serveAdmin
+func newAdminTestRouter(t *testing.T, mockDB *MockDB) http.Handler {
+	return newConfiguredTestRouter(t, mockDB, &config.Config{AdminToken: "secret"})
+}
+
+func newConfiguredTestRouter(t *testing.T, mockDB *MockDB, cfg *config.Config) http.Handler {
+	weather := newWeatherServer(t)
+	subscriptionService := &services.SubscriptionService{
+		DB:      mockDB,
+		Weather: &services.WeatherClient{BaseURL: weather.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}},
+	}
+	handler := handlers.NewHandler(services.NewUserService(mockDB), subscriptionService, services.NewNotificationService(mockDB), cfg)
+	return server.NewRouter(handler, nil)
+}
+
+func serveJSON(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+func TestPostSubscriptionHandler_ReturnsCreatedSubscription(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+	mockDB.On("GetUserByEmail", "test@example.com").Return(&models.User{Id: 3, Email: "test@example.com"}, nil)
+	mockDB.On("CreateSubscription", mock.MatchedBy(func(s *models.Subscription) bool {
+		return s.UserId == 3 && s.City == "New York" && s.Condition == "temperature:>:20"
+	})).Return(42, nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 42, models.AuditActionCreate)
+
+	recorder := serveJSON(router, "POST", "/subscribe", `{"email":"test@example.com","city":"New York","condition":"temperature:>:20"}`)
+
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "/subscriptions/42", recorder.Header().Get("Location"))
+	var response models.SubscriptionResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, models.SubscriptionResponse{Id: 42, UserId: 3, Email: "test@example.com", City: "New York", Condition: "temperature:>:20", Active: true}, response)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPostSubscriptionHandler_IgnoresServerSetFields(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+	mockDB.On("GetUserByEmail", "test@example.com").Return(&models.User{Id: 3, Email: "test@example.com"}, nil)
+	mockDB.On("CreateSubscription", mock.MatchedBy(func(s *models.Subscription) bool {
+		return s.Id == 0 && s.UserId == 3
+	})).Return(42, nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 42, models.AuditActionCreate)
+
+	recorder := serveJSON(router, "POST", "/subscribe", `{"id":7,"user_id":99,"email":"test@example.com","city":"New York","condition":"temperature:>:20"}`)
+
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPostSubscriptionHandler_Validation(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+
+	for body, problem := range map[string]string{
+		`{"city":"New York","condition":"temperature:>:20"}`:                                                    "email is required",
+		`{"email":"not-an-email","city":"New York","condition":"temperature:>:20"}`:                             "email must be a valid email address",
+		`{"email":"test@example.com","condition":"temperature:>:20"}`:                                           "city is required",
+		`{"email":"test@example.com","city":"` + strings.Repeat("a", 101) + `","condition":"temperature:>:20"}`: "city must be at most 100 characters",
+		`{"email":"test@example.com","city":"New York"}`:                                                        "condition is required",
+		`{"email":"test@example.com","city":"New York","condition":"pressure:>:20"}`:                            "condition is invalid",
+	} {
+		recorder := serveJSON(router, "POST", "/subscribe", body)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, body)
+		assert.Contains(t, recorder.Body.String(), problem, body)
+	}
+	mockDB.AssertNotCalled(t, "CreateSubscription", mock.Anything)
+}
+
+func TestPostSubscriptionHandler_UserNotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+	mockDB.On("GetUserByEmail", "test@example.com").Return((*models.User)(nil), nil)
+
+	recorder := serveJSON(router, "POST", "/subscribe", `{"email":"test@example.com","city":"New York","condition":"temperature:>:20"}`)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestPostUserHandler_ReturnsCreatedUser(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+	mockDB.On("CreateUser", mock.MatchedBy(func(u *models.User) bool {
+		return u.Name == "Test" && u.Email == "test@example.com"
+	})).Return(5, nil)
+	expectAudit(mockDB, models.AuditEntityUser, 5, models.AuditActionCreate)
+
+	recorder := serveJSON(router, "POST", "/user", `{"name":"Test","email":"test@example.com"}`)
+
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "/users/5", recorder.Header().Get("Location"))
+	var response models.UserResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, 5, response.Id)
+	assert.Equal(t, "test@example.com", response.Email)
+	assert.Equal(t, "UTC", response.Timezone)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPostUserHandler_Validation(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+
+	for body, problem := range map[string]string{
+		`{"email":"test@example.com"}`:                                            "name is required",
+		`{"name":"Test","email":"test"}`:                                          "email must be a valid email address",
+		`{"name":"Test","email":"test@example.com","quiet_hours_policy":"later"}`: "quiet_hours_policy must be one of: defer, drop",
+	} {
+		recorder := serveJSON(router, "POST", "/user", body)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, body)
+		assert.Contains(t, recorder.Body.String(), problem, body)
+	}
+	mockDB.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestGetUserHandler(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	mockDB.On("GetUserByID", 5).Return(&models.User{Id: 5, Name: "Test", Email: "test@example.com"}, nil)
+	mockDB.On("GetUserByID", 6).Return((*models.User)(nil), nil)
+
+	recorder := serveAdmin(router, "GET", "/users/5")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"email":"test@example.com"`)
+
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "GET", "/users/6").Code)
+}
+
+func TestGetUserHandler_RequiresAdminToken(t *testing.T) {
+	mockDB := new(MockDB)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(newAdminTestRouter(t, mockDB), "GET", "/users/5", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(newTestRouter(t, mockDB), "GET", "/users/5", "").Code, "no admin token configured")
+	mockDB.AssertNotCalled(t, "GetUserByID", mock.Anything)
+}
+
+// TestPerRecordRoutes_RequireAdminToken covers every route that addresses a user or subscription by its sequential ID
+func TestPerRecordRoutes_RequireAdminToken(t *testing.T) {
+	cases := []struct {
+		method, path, body string
+	}{
+		{"GET", "/users/1", ""},
+		{"DELETE", "/users/1", ""},
+		{"PUT", "/users/1/preferences", `{"timezone":"UTC"}`},
+		{"GET", "/users/1/subscriptions", ""},
+		{"GET", "/users/1/notifications", ""},
+		{"GET", "/users/1/export", ""},
+		{"GET", "/subscriptions/1", ""},
+		{"POST", "/subscriptions/1/pause", ""},
+		{"POST", "/subscriptions/1/resume", ""},
+		{"GET", "/subscriptions/1/notifications", ""},
+	}
+	mockDB := new(MockDB) // any database call fails the test
+	router := server.NewRouter(handlers.NewHandler(services.NewUserService(mockDB), services.NewSubscriptionService(mockDB, nil), services.NewNotificationService(mockDB), &config.Config{AdminToken: "secret"}), nil)
+
+	covered := map[string]bool{}
+	for _, c := range cases {
+		covered[c.method+" "+c.path] = true
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, c.method, c.path, c.body).Code, c.method+" "+c.path)
+
+		request := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		request.Header.Set("Authorization", "Bearer wrong")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, c.method+" "+c.path+" with a wrong token")
+	}
+
+	// A new route with an ID must be added to the cases above
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.Contains(path, "{id") {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			assert.True(t, covered[method+" "+routeVariable.ReplaceAllString(path, "1")], "%s %s is not covered", method, path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestGetSubscriptionHandler(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	mockDB.On("GetSubscriptionByID", 42).Return(&models.Subscription{Id: 42, UserId: 3, UserEmail: "test@example.com", City: "New York", Active: true}, nil)
+	mockDB.On("GetSubscriptionByID", 43).Return((*models.Subscription)(nil), nil)
+
+	recorder := serveAdmin(router, "GET", "/subscriptions/42")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"email":"test@example.com"`)
+
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "GET", "/subscriptions/43").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, "GET", "/subscriptions/42", "").Code)
+}
+
+func TestPostPauseSubscriptionHandler_RejectsPastUntil(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+
+	recorder := serveAdminJSON(router, "POST", "/subscriptions/42/pause", `{"until":"2020-01-01T00:00:00Z"}`)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "pause must end in the future")
+	mockDB.AssertNotCalled(t, "UpdateSubscription", mock.Anything)
+}
+
+func TestPostPauseSubscriptionHandler_Expired(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	expired := time.Now().Add(-time.Hour)
+	mockDB.On("GetSubscriptionByID", 42).Return(&models.Subscription{Id: 42, ExpiresAt: &expired}, nil)
+
+	recorder := serveAdmin(router, "POST", "/subscriptions/42/pause")
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	mockDB.AssertNotCalled(t, "UpdateSubscription", mock.Anything)
+}
+
+func TestPostPauseAndResumeSubscriptionHandler(t *testing.T) {
+	router, db, user := newUserAdminRouter(t)
+	id, err := db.CreateSubscription(context.Background(), &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "temperature:>:30", Active: true})
+	require.NoError(t, err)
+
+	for _, action := range []string{"pause", "resume"} {
+		path := fmt.Sprintf("/subscriptions/%d/%s", id, action)
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, "POST", path, "").Code)
+
+		recorder := serveAdmin(router, "POST", path)
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		assert.NotContains(t, recorder.Body.String(), `"email"`)
+		var subscription models.SubscriptionResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &subscription))
+		assert.Equal(t, action == "resume", subscription.Active)
+	}
+}
+
+func TestGetUserSubscriptionsHandler(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	active := true
+	mockDB.On("GetUserByID", 3).Return(&models.User{Id: 3, Email: "test@example.com"}, nil)
+	mockDB.On("ListSubscriptions", models.SubscriptionFilter{UserId: 3, City: "Kyiv", Active: &active, AfterId: 10, Limit: 2}).
+		Return([]models.Subscription{{Id: 11, UserId: 3, UserEmail: "test@example.com", City: "Kyiv", Active: true}}, nil)
+
+	recorder := serveAdmin(router, "GET", "/users/3/subscriptions?city=Kyiv&active=true&limit=1&cursor="+services.EncodeCursor(10))
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var page models.SubscriptionPage
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	require.Len(t, page.Subscriptions, 1)
+	assert.Equal(t, "test@example.com", page.Subscriptions[0].Email)
+	assert.Empty(t, page.NextCursor)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetUserSubscriptionsHandler_UserNotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	mockDB.On("GetUserByID", 3).Return(nil, nil)
+
+	recorder := serveAdmin(router, "GET", "/users/3/subscriptions")
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestGetUserSubscriptionsHandler_RequiresAdminToken(t *testing.T) {
+	mockDB := new(MockDB)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(newAdminTestRouter(t, mockDB), "GET", "/users/3/subscriptions", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(newTestRouter(t, mockDB), "GET", "/users/3/subscriptions", "").Code, "no admin token configured")
+	mockDB.AssertNotCalled(t, "ListSubscriptions", mock.Anything)
+}
+
+func TestGetUserSubscriptionsHandler_InvalidFilter(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+
+	for _, query := range []string{"active=maybe", "limit=0", "cursor=bogus", "user_id=x"} {
+		recorder := serveAdmin(router, "GET", "/users/3/subscriptions?"+query)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+	}
+	mockDB.AssertNotCalled(t, "ListSubscriptions", mock.Anything)
+}
+
+func TestGetSubscriptionsHandler_AdminOnly(t *testing.T) {
+	mockDB := new(MockDB)
+	handler := handlers.NewHandler(nil, services.NewSubscriptionService(mockDB, nil), nil, &config.Config{AdminToken: "secret"})
+	router := server.NewRouter(handler, nil)
+	mockDB.On("ListSubscriptions", models.SubscriptionFilter{UserId: 3, Limit: services.DefaultSubscriptionPageSize + 1}).Return([]models.Subscription{}, nil)
+
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(router, "/admin/subscriptions", "").Code)
+	recorder := adminRequest(router, "/admin/subscriptions?user_id=3", "secret")
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.JSONEq(t, `{"subscriptions":[],"limit":50}`, recorder.Body.String())
+	mockDB.AssertExpectations(t)
+}
+
+// newUserAdminRouter serves the user admin endpoints backed by an in-memory database with one user
+func newUserAdminRouter(t *testing.T) (http.Handler, database.IDB, *models.User) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(db)
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, userService.CreateUser(context.Background(), user))
+	handler := handlers.NewHandler(userService, services.NewSubscriptionService(db, nil), nil, &config.Config{AdminToken: "secret"})
+	return server.NewRouter(handler, nil), db, user
+}
+
+func serveAdmin(router http.Handler, method, path string) *httptest.ResponseRecorder {
+	return serveAdminJSON(router, method, path, "")
+}
+
+func serveAdminJSON(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestPutUserPreferencesHandler(t *testing.T) {
+	router, _, user := newUserAdminRouter(t)
+	path := fmt.Sprintf("/users/%d/preferences", user.Id)
+	body := `{"timezone":"Europe/Kyiv","quiet_hours_start":"22:00","quiet_hours_end":"07:00","quiet_hours_policy":"drop","digest_mode":true}`
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, "PUT", path, body).Code)
+	assert.Equal(t, http.StatusNotFound, serveAdminJSON(router, "PUT", "/users/999/preferences", body).Code)
+
+	recorder := serveAdminJSON(router, "PUT", path, body)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.NotContains(t, recorder.Body.String(), user.Email)
+	var prefs models.DeliveryPreferences
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &prefs))
+	assert.Equal(t, models.DeliveryPreferences{Timezone: "Europe/Kyiv", QuietHoursStart: "22:00", QuietHoursEnd: "07:00", QuietHoursPolicy: "drop", DigestMode: true}, prefs)
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	router, db, user := newUserAdminRouter(t)
+	path := fmt.Sprintf("/users/%d", user.Id)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, "DELETE", path, "").Code)
+	assert.Equal(t, http.StatusBadRequest, serveAdmin(router, "DELETE", path+"?erase=maybe").Code)
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "DELETE", "/users/999").Code)
+
+	require.Equal(t, http.StatusNoContent, serveAdmin(router, "DELETE", path).Code)
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "DELETE", path).Code, "already deleted")
+
+	// An erasure also removes users that are only deleted
+	require.Equal(t, http.StatusNoContent, serveAdmin(router, "DELETE", path+"?erase=true").Code)
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "DELETE", path+"?erase=true").Code)
+	events, err := db.GetAuditEvents(context.Background(), models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: user.Id, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditActionErase, events[0].Action)
+}
+
+func TestGetUserExportHandler(t *testing.T) {
+	router, _, user := newUserAdminRouter(t)
+	path := fmt.Sprintf("/users/%d/export", user.Id)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, "GET", path, "").Code)
+	assert.Equal(t, http.StatusBadRequest, serveAdmin(router, "GET", path+"?format=csv").Code)
+	assert.Equal(t, http.StatusNotFound, serveAdmin(router, "GET", "/users/999/export").Code)
+
+	recorder := serveAdmin(router, "GET", path)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, recorder.Header().Get("Content-Disposition"), fmt.Sprintf(`filename="user-%d-export.json"`, user.Id))
+	var export models.UserExport
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &export))
+	assert.Equal(t, user.Email, export.User.Email)
+	assert.Empty(t, export.Subscriptions)
+}
+
+func TestGetUserExportHandler_Zip(t *testing.T) {
+	router, _, user := newUserAdminRouter(t)
+
+	recorder := serveAdmin(router, "GET", fmt.Sprintf("/users/%d/export?format=zip", user.Id))
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "application/zip", recorder.Header().Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	require.NoError(t, err)
+	names := []string{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"profile.json", "subscriptions.json", "notifications.json"}, names)
+
+	f, err := archive.Open("profile.json")
+	require.NoError(t, err)
+	defer f.Close()
+	var profile struct {
+		User models.UserResponse `json:"user"`
+	}
+	require.NoError(t, json.NewDecoder(f).Decode(&profile))
+	assert.Equal(t, user.Email, profile.User.Email)
+}
+
+func TestPostEvaluateConditionHandler_SingleProviderCall(t *testing.T) {
+	var calls atomic.Int32
+	weather := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Query().Get("q") != "Kyiv" {
+			http.Error(w, `{"cod":"404","message":"city not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"weather":[{"id":800,"main":"Clear"}],"main":{"temp":25.5,"feels_like":26,"humidity":40}}`))
+	}))
+	t.Cleanup(weather.Close)
+	subscriptionService := &services.SubscriptionService{
+		DB:      new(MockDB),
+		Weather: &services.WeatherClient{BaseURL: weather.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}},
+	}
+	router := server.NewRouter(handlers.NewHandler(nil, subscriptionService, nil, nil), nil)
+
+	recorder := serveJSON(router, "POST", "/conditions/evaluate", `{"city":"Kyiv","condition":"temperature:>:20"}`)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, recorder.Body.String(), `"result":true`)
+	assert.Equal(t, int32(1), calls.Load())
+
+	recorder = serveJSON(router, "POST", "/conditions/evaluate", `{"city":"Atlantis","condition":"main:clear"}`)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, int32(2), calls.Load())
+}
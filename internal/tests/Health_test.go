@@ -0,0 +1,106 @@
+// internal/tests/Health_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/health"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func okCheck(ctx context.Context) error {
+	return nil
+}
+
+func TestChecker_ReportsEachComponent(t *testing.T) {
+	checker := health.NewChecker(time.Second,
+		health.Check{Name: "database", Run: okCheck},
+		health.Check{Name: "weather", Run: func(ctx context.Context) error { return errors.New("401 Unauthorized") }},
+	)
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, health.StatusFail, report.Status)
+	assert.Equal(t, "database", report.Components[0].Name)
+	assert.Equal(t, health.StatusOK, report.Components[0].Status)
+	assert.Equal(t, health.StatusFail, report.Components[1].Status)
+	assert.Equal(t, "401 Unauthorized", report.Components[1].Error)
+}
+
+func TestChecker_TimesOutSlowChecks(t *testing.T) {
+	checker := health.NewChecker(10*time.Millisecond, health.Check{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, health.StatusFail, report.Status)
+}
+
+func TestCached_ReusesResult(t *testing.T) {
+	calls := 0
+	check := health.Cached(time.Minute, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	assert.NoError(t, check(context.Background()))
+	assert.NoError(t, check(context.Background()))
+	assert.Equal(t, 1, calls)
+}
+
+func TestReadyzHandler(t *testing.T) {
+	handler := &handlers.Handler{Health: health.NewChecker(time.Second,
+		health.Check{Name: "database", Run: func(ctx context.Context) error { return errors.New("connection refused") }},
+	)}
+	recorder := httptest.NewRecorder()
+
+	handler.ReadyzHandler(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	var report health.Report
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&report))
+	assert.Equal(t, "database", report.Components[0].Name)
+	assert.Equal(t, "connection refused", report.Components[0].Error)
+}
+
+func TestLivezHandler(t *testing.T) {
+	handler := &handlers.Handler{}
+	recorder := httptest.NewRecorder()
+
+	handler.LivezHandler(recorder, httptest.NewRequest("GET", "/livez", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"status":"ok"`)
+}
+
+func TestWeatherClient_Ping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"cod":401,"message":"Invalid API key"}`, http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+	client := &services.WeatherClient{BaseURL: server.URL, APIKey: "wrong-key", HTTP: &http.Client{Timeout: time.Second}}
+
+	err := client.Ping(context.Background())
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestEmailNotifier_Check(t *testing.T) {
+	notifier := services.NewEmailNotifier(&config.Config{EmailFrom: "weatherapp@resend.dev", ResendBaseURL: "https://api.resend.com/"})
+	assert.ErrorContains(t, notifier.Check(), "RESEND_API_KEY")
+
+	notifier.Update(&config.Config{ResendApiKey: "key", EmailFrom: "weatherapp@resend.dev", ResendBaseURL: "https://api.resend.com/"})
+	assert.NoError(t, notifier.Check())
+}
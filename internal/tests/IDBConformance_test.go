@@ -0,0 +1,921 @@
+// internal/tests/IDBConformance_test.go
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/models"
+)
+
+// postgresTestEnv holds the connection string of a disposable database for TestPostgresDB_Conformance
+// Every subtest empties all tables, never point it at a database with data you want to keep
+const postgresTestEnv = "WEATHERAPP_TEST_POSTGRES"
+
+func TestMemoryDB_Conformance(t *testing.T) {
+	runIDBConformance(t, func(t *testing.T) database.IDB {
+		return database.NewMemoryDB()
+	})
+}
+
+// TestPostgresDB_Conformance runs with both drivers, as with pgxpool every query runs natively through pgx
+// and CreateNotifications uses COPY instead of INSERT, encoding the weather snapshot itself
+func TestPostgresDB_Conformance(t *testing.T) {
+	connectionString := os.Getenv(postgresTestEnv)
+	if connectionString == "" {
+		t.Skipf("%s is not set", postgresTestEnv)
+	}
+
+	for _, driver := range []string{"stdlib", "pgxpool"} {
+		t.Run(driver, func(t *testing.T) {
+			t.Setenv("POSTGRES_CONNECTION_STRING", connectionString)
+			t.Setenv("DB_DRIVER", driver)
+
+			cfg, _, err := config.Load(nil)
+			require.NoError(t, err)
+			require.NoError(t, database.MigrateUpAll(cfg))
+			db, err := database.NewDB(cfg)
+			require.NoError(t, err)
+			t.Cleanup(db.Close)
+
+			runIDBConformance(t, func(t *testing.T) database.IDB {
+				_, err := db.SQL.Exec("TRUNCATE users, subscriptions, notifications, deferred_notifications, audit_log RESTART IDENTITY CASCADE")
+				require.NoError(t, err)
+				return db
+			})
+
+			t.Run("ListenNotify", func(t *testing.T) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				if driver != "pgxpool" {
+					assert.ErrorIs(t, db.Listen(ctx, "weather_events", func(string) {}), database.ErrListenNotSupported)
+					return
+				}
+
+				payloads := make(chan string, 1)
+				done := make(chan error, 1)
+				go func() { done <- db.Listen(ctx, "weather_events", func(p string) { payloads <- p }) }()
+
+				// Listen has no ready signal, so notify until the listener picks a notification up
+				require.Eventually(t, func() bool {
+					if err := db.Notify(ctx, "weather_events", "hello"); err != nil {
+						return false
+					}
+					select {
+					case p := <-payloads:
+						return p == "hello"
+					case <-time.After(100 * time.Millisecond):
+						return false
+					}
+				}, 5*time.Second, 10*time.Millisecond)
+
+				cancel()
+				assert.NoError(t, <-done)
+			})
+		})
+	}
+}
+
+func TestSQLiteDB_Conformance(t *testing.T) {
+	runIDBConformance(t, func(t *testing.T) database.IDB {
+		t.Setenv("POSTGRES_CONNECTION_STRING", "sqlite://"+filepath.Join(t.TempDir(), "weatherapp.db"))
+
+		cfg, _, err := config.Load(nil)
+		require.NoError(t, err)
+		require.NoError(t, database.MigrateUpAll(cfg))
+		db, err := database.NewDB(cfg)
+		require.NoError(t, err)
+		t.Cleanup(db.Close)
+		return db
+	})
+}
+
+func TestSQLiteDB_ListenNotSupported(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("POSTGRES_CONNECTION_STRING", "sqlite://"+filepath.Join(t.TempDir(), "weatherapp.db"))
+	cfg, _, err := config.Load(nil)
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateUpAll(cfg))
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.ErrorIs(t, db.Listen(ctx, "weather_events", func(string) {}), database.ErrListenNotSupported)
+	assert.ErrorIs(t, db.Notify(ctx, "weather_events", "hello"), database.ErrListenNotSupported)
+}
+
+func TestSQLiteDB_SubscriptionEmailMigration(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("POSTGRES_CONNECTION_STRING", "sqlite://"+filepath.Join(t.TempDir(), "weatherapp.db"))
+	cfg, _, err := config.Load(nil)
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateGoto(cfg, 20261018150000))
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	// A subscription whose copy of the email went stale before the migration
+	_, err = db.SQL.Exec("INSERT INTO users (id, name, email) VALUES (1, 'Jane Doe', 'new@example.com')")
+	require.NoError(t, err)
+	_, err = db.SQL.Exec("INSERT INTO subscriptions (id, user_id, city, condition, user_email) VALUES (1, 1, 'Kyiv', 'main:rain', 'old@example.com')")
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateUpAll(cfg))
+
+	storedEmail := func() string {
+		var email string
+		require.NoError(t, db.SQL.QueryRow("SELECT user_email FROM subscriptions WHERE id = 1").Scan(&email))
+		return email
+	}
+	assert.Equal(t, "new@example.com", storedEmail(), "backfilled")
+
+	user, err := db.GetUserByID(ctx, 1)
+	require.NoError(t, err)
+	user.Email = "newer@example.com"
+	require.NoError(t, db.UpdateUser(ctx, user))
+	assert.Equal(t, "newer@example.com", storedEmail(), "follows the user")
+
+	_, err = db.SQL.Exec("UPDATE subscriptions SET user_email = 'other@example.com' WHERE id = 1")
+	assert.Error(t, err, "cannot diverge from the user")
+}
+
+func TestPostgresDB_UpdatedAtTriggers(t *testing.T) {
+	connectionString := os.Getenv(postgresTestEnv)
+	if connectionString == "" {
+		t.Skipf("%s is not set", postgresTestEnv)
+	}
+	t.Setenv("POSTGRES_CONNECTION_STRING", connectionString)
+
+	cfg, _, err := config.Load(nil)
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateUpAll(cfg))
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	_, err = db.SQL.Exec("TRUNCATE users, subscriptions, notifications, deferred_notifications, audit_log RESTART IDENTITY CASCADE")
+	require.NoError(t, err)
+
+	checkUpdatedAtTriggers(t, db)
+}
+
+func TestSQLiteDB_UpdatedAtTriggers(t *testing.T) {
+	t.Setenv("POSTGRES_CONNECTION_STRING", "sqlite://"+filepath.Join(t.TempDir(), "weatherapp.db"))
+	cfg, _, err := config.Load(nil)
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateUpAll(cfg))
+	db, err := database.NewDB(cfg)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	checkUpdatedAtTriggers(t, db)
+}
+
+// checkUpdatedAtTriggers checks that the schema moves updated_at on changes made outside the application
+// db must be empty
+func checkUpdatedAtTriggers(t *testing.T, db *database.DB) {
+	ctx := context.Background()
+	longAgo := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := &models.User{Name: "Jane Doe", Email: "jane@example.com", Timezone: "UTC", QuietHoursPolicy: models.QuietHoursPolicyDefer}
+	_, err := db.CreateUser(ctx, user)
+	require.NoError(t, err)
+	sub := &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "main:rain", UserEmail: user.Email, Active: true}
+	_, err = db.CreateSubscription(ctx, sub)
+	require.NoError(t, err)
+	_, err = db.SQL.Exec("UPDATE users SET updated_at = $1", longAgo)
+	require.NoError(t, err)
+	_, err = db.SQL.Exec("UPDATE subscriptions SET updated_at = $1", longAgo)
+	require.NoError(t, err)
+
+	stored, err := db.GetUserByID(ctx, user.Id)
+	require.NoError(t, err)
+	assert.True(t, longAgo.Equal(stored.UpdatedAt), "an UPDATE that sets updated_at keeps its value")
+
+	// Changes made outside the application move updated_at, also those cascading from the user
+	_, err = db.SQL.Exec("UPDATE users SET email = 'jane.doe@example.com' WHERE id = $1", user.Id)
+	require.NoError(t, err)
+	stored, err = db.GetUserByID(ctx, user.Id)
+	require.NoError(t, err)
+	assert.True(t, stored.UpdatedAt.After(longAgo), "user")
+	storedSub, err := db.GetSubscriptionByID(ctx, sub.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", storedSub.UserEmail)
+	assert.True(t, storedSub.UpdatedAt.After(longAgo), "subscription")
+}
+
+// runIDBConformance checks the behaviour every IDB implementation shares with the SQL schema
+// newDB must return an empty database
+func runIDBConformance(t *testing.T, newDB func(t *testing.T) database.IDB) {
+	ctx := context.Background()
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	createUser := func(t *testing.T, db database.IDB, email string) *models.User {
+		user := &models.User{Name: "Test", Email: email, Timezone: "UTC", QuietHoursPolicy: models.QuietHoursPolicyDefer}
+		_, err := db.CreateUser(ctx, user)
+		require.NoError(t, err)
+		return user
+	}
+	createSubscription := func(t *testing.T, db database.IDB, user *models.User) *models.Subscription {
+		sub := &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "temperature:>:30", UserEmail: user.Email, Active: true}
+		_, err := db.CreateSubscription(ctx, sub)
+		require.NoError(t, err)
+		return sub
+	}
+	createNotification := func(t *testing.T, db database.IDB, sub *models.Subscription, sentAt time.Time) *models.Notification {
+		n := &models.Notification{UserId: sub.UserId, SubscriptionId: sub.Id, SentAt: sentAt, Channel: models.ChannelEmail}
+		_, err := db.CreateNotification(ctx, n)
+		require.NoError(t, err)
+		return n
+	}
+	ids := func(notifications []models.Notification) []int {
+		result := []int{}
+		for _, n := range notifications {
+			result = append(result, n.Id)
+		}
+		return result
+	}
+
+	t.Run("Users", func(t *testing.T) {
+		db := newDB(t)
+
+		missing, err := db.GetUserByID(ctx, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+		missing, err = db.GetUserByEmail(ctx, "nobody@example.com")
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+
+		second := createUser(t, db, "b@example.com")
+		first := createUser(t, db, "a@example.com")
+		assert.NotEqual(t, first.Id, second.Id)
+
+		found, err := db.GetUserByEmail(ctx, "a@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, first, found)
+		found, err = db.GetUserByID(ctx, second.Id)
+		require.NoError(t, err)
+		assert.Equal(t, second, found)
+
+		users, err := db.GetUsers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []models.User{*second, *first}, users)
+
+		first.DigestMode = true
+		first.QuietHoursStart, first.QuietHoursEnd = "22:00", "07:00"
+		require.NoError(t, db.UpdateUser(ctx, first))
+		found, err = db.GetUserByID(ctx, first.Id)
+		require.NoError(t, err)
+		assert.Equal(t, first, found)
+
+		// Updating a user that does not exist is not an error
+		assert.NoError(t, db.UpdateUser(ctx, &models.User{Id: 999, Name: "Ghost", Email: "ghost@example.com"}))
+	})
+
+	t.Run("UniqueEmail", func(t *testing.T) {
+		db := newDB(t)
+		first := createUser(t, db, "a@example.com")
+		second := createUser(t, db, "b@example.com")
+
+		_, err := db.CreateUser(ctx, &models.User{Name: "Copy", Email: "a@example.com"})
+		assert.Error(t, err)
+
+		second.Email = first.Email
+		assert.Error(t, db.UpdateUser(ctx, second))
+		found, err := db.GetUserByID(ctx, second.Id)
+		require.NoError(t, err)
+		assert.Equal(t, "b@example.com", found.Email)
+
+		users, err := db.GetUsers(ctx)
+		require.NoError(t, err)
+		assert.Len(t, users, 2)
+	})
+
+	t.Run("Subscriptions", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+
+		_, err := db.CreateSubscription(ctx, &models.Subscription{UserId: 999, City: "Kyiv", Condition: "temperature:>:30", UserEmail: "ghost@example.com"})
+		assert.Error(t, err, "subscription of a missing user")
+
+		missing, err := db.GetSubscriptionByID(ctx, 1)
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+
+		expires := base.Add(24 * time.Hour)
+		sub := &models.Subscription{UserId: user.Id, City: "Lviv", Condition: "main:clear", UserEmail: user.Email, Active: true, ExpiresAt: &expires}
+		_, err = db.CreateSubscription(ctx, sub)
+		require.NoError(t, err)
+		other := createSubscription(t, db, user)
+
+		all, err := db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		for _, s := range all {
+			assert.Equal(t, user.Email, s.UserEmail)
+		}
+
+		found, err := db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.Equal(t, "Lviv", found.City)
+		assert.Equal(t, user.Email, found.UserEmail)
+		assert.Nil(t, found.PausedUntil)
+		require.NotNil(t, found.ExpiresAt)
+		assert.True(t, expires.Equal(*found.ExpiresAt))
+
+		byUser, err := db.GetSubscriptionsByUserID(ctx, user.Id)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []int{sub.Id, other.Id}, []int{byUser[0].Id, byUser[1].Id})
+		assert.Equal(t, user.Email, byUser[0].UserEmail)
+
+		paused := base.Add(time.Hour)
+		found.PausedUntil = &paused
+		found.Active = false
+		found.UserEmail = "changed@example.com"
+		require.NoError(t, db.UpdateSubscription(ctx, found))
+		found, err = db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.False(t, found.Active)
+		require.NotNil(t, found.PausedUntil)
+		assert.True(t, paused.Equal(*found.PausedUntil))
+
+		// The email belongs to the user and is not changed through the subscription
+		all, err = db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		for _, s := range all {
+			assert.Equal(t, user.Email, s.UserEmail)
+		}
+
+		found.UserId = 999
+		assert.Error(t, db.UpdateSubscription(ctx, found), "subscription moved to a missing user")
+		assert.NoError(t, db.UpdateSubscription(ctx, &models.Subscription{Id: 999, UserId: user.Id}))
+	})
+
+	t.Run("SubscriptionsFollowUserEmail", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "old@example.com")
+		other := createUser(t, db, "other@example.com")
+		sub := createSubscription(t, db, user)
+
+		user.Email = "new@example.com"
+		require.NoError(t, db.UpdateUser(ctx, user))
+
+		found, err := db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.Equal(t, "new@example.com", found.UserEmail)
+		byUser, err := db.GetSubscriptionsByUserID(ctx, user.Id)
+		require.NoError(t, err)
+		require.Len(t, byUser, 1)
+		assert.Equal(t, "new@example.com", byUser[0].UserEmail)
+		all, err := db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, "new@example.com", all[0].UserEmail)
+
+		// Moving the subscription to another user moves it to their email
+		found.UserId = other.Id
+		require.NoError(t, db.UpdateSubscription(ctx, found))
+		all, err = db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, "other@example.com", all[0].UserEmail)
+	})
+
+	t.Run("ListSubscriptions", func(t *testing.T) {
+		db := newDB(t)
+		jane := createUser(t, db, "jane@example.com")
+		john := createUser(t, db, "john@example.com")
+		kyiv := createSubscription(t, db, jane)
+		lviv := &models.Subscription{UserId: jane.Id, City: "Lviv", Condition: "main:rain", UserEmail: jane.Email}
+		_, err := db.CreateSubscription(ctx, lviv)
+		require.NoError(t, err)
+		johnKyiv := createSubscription(t, db, john)
+		deleted := createSubscription(t, db, jane)
+		require.NoError(t, db.DeleteSubscription(ctx, deleted.Id))
+		lastKyiv := createSubscription(t, db, john)
+
+		subIDs := func(filter models.SubscriptionFilter) []int {
+			subscriptions, err := db.ListSubscriptions(ctx, filter)
+			require.NoError(t, err)
+			result := []int{}
+			for _, s := range subscriptions {
+				result = append(result, s.Id)
+			}
+			return result
+		}
+		active, inactive := true, false
+
+		assert.Equal(t, []int{kyiv.Id, lviv.Id, johnKyiv.Id, lastKyiv.Id}, subIDs(models.SubscriptionFilter{}))
+		assert.Equal(t, []int{kyiv.Id, lviv.Id}, subIDs(models.SubscriptionFilter{UserId: jane.Id}))
+		assert.Equal(t, []int{kyiv.Id, johnKyiv.Id, lastKyiv.Id}, subIDs(models.SubscriptionFilter{City: "KYIV"}))
+		assert.Equal(t, []int{lviv.Id}, subIDs(models.SubscriptionFilter{Active: &inactive}))
+		assert.Equal(t, []int{kyiv.Id}, subIDs(models.SubscriptionFilter{UserId: jane.Id, City: "kyiv", Active: &active}))
+
+		// Keyset pages continue after the last ID of the previous page, skipping deleted subscriptions
+		assert.Equal(t, []int{kyiv.Id, lviv.Id}, subIDs(models.SubscriptionFilter{Limit: 2}))
+		assert.Equal(t, []int{johnKyiv.Id, lastKyiv.Id}, subIDs(models.SubscriptionFilter{AfterId: lviv.Id, Limit: 2}))
+		assert.Empty(t, subIDs(models.SubscriptionFilter{AfterId: lastKyiv.Id, Limit: 2}))
+		assert.Equal(t, []int{lastKyiv.Id}, subIDs(models.SubscriptionFilter{City: "Kyiv", AfterId: johnKyiv.Id}))
+
+		page, err := db.ListSubscriptions(ctx, models.SubscriptionFilter{UserId: john.Id, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, john.Email, page[0].UserEmail)
+	})
+
+	t.Run("ExpireSubscriptions", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+
+		past, future := base.Add(-time.Hour), base.Add(time.Hour)
+		var created []int
+		for _, s := range []models.Subscription{
+			{Active: true, ExpiresAt: &past},
+			{Active: true, ExpiresAt: &base},
+			{Active: true, ExpiresAt: &future},
+			{Active: true},
+			{Active: false, ExpiresAt: &past},
+		} {
+			s.UserId, s.City, s.Condition, s.UserEmail = user.Id, "Kyiv", "main:rain", user.Email
+			id, err := db.CreateSubscription(ctx, &s)
+			require.NoError(t, err)
+			created = append(created, id)
+		}
+
+		expired, err := db.ExpireSubscriptions(ctx, base)
+		require.NoError(t, err)
+		assert.Equal(t, created[:2], expired)
+
+		subscriptions, err := db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		active := 0
+		for _, s := range subscriptions {
+			if s.Active {
+				active++
+			}
+		}
+		assert.Equal(t, 2, active)
+
+		expired, err = db.ExpireSubscriptions(ctx, base)
+		require.NoError(t, err)
+		assert.Empty(t, expired)
+	})
+
+	t.Run("Timestamps", func(t *testing.T) {
+		db := newDB(t)
+		before := time.Now().Add(-time.Second)
+		user := createUser(t, db, "a@example.com")
+		sub := createSubscription(t, db, user)
+
+		assert.True(t, user.CreatedAt.After(before))
+		assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+		assert.True(t, sub.CreatedAt.After(before))
+		assert.Equal(t, sub.CreatedAt, sub.UpdatedAt)
+
+		time.Sleep(time.Millisecond)
+		user.Name = "Renamed"
+		require.NoError(t, db.UpdateUser(ctx, user))
+		foundUser, err := db.GetUserByID(ctx, user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, user.CreatedAt, foundUser.CreatedAt)
+		assert.True(t, foundUser.UpdatedAt.After(foundUser.CreatedAt))
+		assert.Equal(t, user.UpdatedAt, foundUser.UpdatedAt)
+
+		sub.Active = false
+		require.NoError(t, db.UpdateSubscription(ctx, sub))
+		foundSub, err := db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.Equal(t, sub.CreatedAt, foundSub.CreatedAt)
+		assert.True(t, foundSub.UpdatedAt.After(foundSub.CreatedAt))
+	})
+
+	t.Run("DeleteUserIsSoft", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		other := createUser(t, db, "b@example.com")
+		sub := createSubscription(t, db, user)
+		otherSub := createSubscription(t, db, other)
+		createNotification(t, db, sub, base)
+		createNotification(t, db, otherSub, base)
+		_, err := db.CreateDeferredNotification(ctx, &models.DeferredNotification{UserId: user.Id, SubscriptionId: sub.Id, Subject: "s", Body: "b", DeliverAfter: base})
+		require.NoError(t, err)
+
+		require.NoError(t, db.DeleteUser(ctx, user.Id))
+		assert.NoError(t, db.DeleteUser(ctx, user.Id), "deleting a missing user")
+
+		found, err := db.GetUserByID(ctx, user.Id)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+		found, err = db.GetUserByEmail(ctx, user.Email)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+		users, err := db.GetUsers(ctx)
+		require.NoError(t, err)
+		assert.Len(t, users, 1)
+		foundSub, err := db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.Nil(t, foundSub)
+		all, err := db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, otherSub.Id, all[0].Id)
+		due, err := db.GetDueDeferredNotifications(ctx, base)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		// Deleted users can neither be updated nor get new subscriptions
+		user.Name = "Ghost"
+		require.NoError(t, db.UpdateUser(ctx, user))
+		_, err = db.CreateSubscription(ctx, &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "main:rain", UserEmail: user.Email})
+		assert.Error(t, err)
+
+		// Their data can still be read for an export until they are purged
+		found, err = db.GetUserIncludingDeleted(ctx, user.Id)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, "Test", found.Name)
+		assert.Equal(t, user.Email, found.Email)
+		found, err = db.GetUserIncludingDeleted(ctx, 999)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+		withDeleted, err := db.ListSubscriptions(ctx, models.SubscriptionFilter{UserId: user.Id, IncludeDeleted: true})
+		require.NoError(t, err)
+		require.Len(t, withDeleted, 1)
+		assert.Equal(t, sub.Id, withDeleted[0].Id)
+		assert.Equal(t, user.Email, withDeleted[0].UserEmail)
+
+		// The notification history is kept until the user is purged
+		history, err := db.GetNotificationsBySubscriptionID(ctx, sub.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+	})
+
+	t.Run("DeleteSubscriptionIsSoft", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		sub := createSubscription(t, db, user)
+		kept := createSubscription(t, db, user)
+		createNotification(t, db, sub, base)
+		createNotification(t, db, kept, base)
+		_, err := db.CreateDeferredNotification(ctx, &models.DeferredNotification{UserId: user.Id, SubscriptionId: sub.Id, Subject: "s", Body: "b", DeliverAfter: base})
+		require.NoError(t, err)
+
+		require.NoError(t, db.DeleteSubscription(ctx, sub.Id))
+
+		found, err := db.GetUserByID(ctx, user.Id)
+		require.NoError(t, err)
+		assert.NotNil(t, found)
+		foundSub, err := db.GetSubscriptionByID(ctx, sub.Id)
+		require.NoError(t, err)
+		assert.Nil(t, foundSub)
+		byUser, err := db.GetSubscriptionsByUserID(ctx, user.Id)
+		require.NoError(t, err)
+		require.Len(t, byUser, 1)
+		assert.Equal(t, kept.Id, byUser[0].Id)
+		listed, err := db.ListSubscriptions(ctx, models.SubscriptionFilter{UserId: user.Id})
+		require.NoError(t, err)
+		assert.Len(t, listed, 1)
+		listed, err = db.ListSubscriptions(ctx, models.SubscriptionFilter{UserId: user.Id, IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.Len(t, listed, 2)
+		due, err := db.GetDueDeferredNotifications(ctx, base)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		history, err := db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, history, 2)
+	})
+
+	t.Run("EmailReusableAfterDelete", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		require.NoError(t, db.DeleteUser(ctx, user.Id))
+
+		again := createUser(t, db, "a@example.com")
+		assert.NotEqual(t, user.Id, again.Id)
+		found, err := db.GetUserByEmail(ctx, "a@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, again.Id, found.Id)
+	})
+
+	t.Run("PurgeDeleted", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		other := createUser(t, db, "b@example.com")
+		sub := createSubscription(t, db, user)
+		removed := createSubscription(t, db, other)
+		kept := createSubscription(t, db, other)
+		createNotification(t, db, sub, base)
+		createNotification(t, db, removed, base)
+		createNotification(t, db, kept, base)
+
+		require.NoError(t, db.DeleteUser(ctx, user.Id))
+		require.NoError(t, db.DeleteSubscription(ctx, removed.Id))
+
+		// Rows deleted after the cutoff are kept
+		users, subscriptions, err := db.PurgeDeleted(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Empty(t, users)
+		assert.Empty(t, subscriptions)
+		history, err := db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+
+		users, subscriptions, err = db.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, []int{user.Id}, users)
+		assert.Equal(t, []int{sub.Id, removed.Id}, subscriptions, "the user's subscription and the other user's deleted one")
+
+		history, err = db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, history)
+		history, err = db.GetNotificationsByUserID(ctx, other.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, kept.Id, history[0].SubscriptionId)
+
+		users, subscriptions, err = db.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Empty(t, users)
+		assert.Empty(t, subscriptions)
+	})
+
+	t.Run("EraseUser", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		other := createUser(t, db, "b@example.com")
+		sub := createSubscription(t, db, user)
+		otherSub := createSubscription(t, db, other)
+		createNotification(t, db, sub, base)
+		createNotification(t, db, otherSub, base)
+		_, err := db.CreateDeferredNotification(ctx, &models.DeferredNotification{UserId: user.Id, SubscriptionId: sub.Id, Subject: "s", Body: "b", DeliverAfter: base})
+		require.NoError(t, err)
+
+		record := func(entityType string, entityID int, actor, state string) {
+			_, err := db.CreateAuditEvent(ctx, &models.AuditEvent{EntityType: entityType, EntityId: entityID, Action: models.AuditActionUpdate,
+				Actor: actor, Before: json.RawMessage(state), After: json.RawMessage(state)})
+			require.NoError(t, err)
+		}
+		record(models.AuditEntityUser, user.Id, "anonymous:ip:203.0.113.7", fmt.Sprintf(`{"id":%d,"email":"a@example.com"}`, user.Id))
+		record(models.AuditEntitySubscription, sub.Id, "api:key:0123456789abcdef", fmt.Sprintf(`{"id":%d,"user_id":%d,"email":"a@example.com"}`, sub.Id, user.Id))
+		record(models.AuditEntityUser, user.Id, "cli:admin", fmt.Sprintf(`{"id":%d,"email":"a@example.com"}`, user.Id))
+		record(models.AuditEntityUser, other.Id, "anonymous:ip:198.51.100.1", fmt.Sprintf(`{"id":%d,"email":"b@example.com"}`, other.Id))
+		record(models.AuditEntitySubscription, otherSub.Id, "cli:admin", fmt.Sprintf(`{"id":%d,"user_id":%d,"email":"b@example.com"}`, otherSub.Id, other.Id))
+
+		erased, err := db.EraseUser(ctx, user.Id)
+		require.NoError(t, err)
+		assert.True(t, erased)
+
+		found, err := db.GetUserByID(ctx, user.Id)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+		history, err := db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, history)
+		due, err := db.GetDueDeferredNotifications(ctx, base)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+		history, err = db.GetNotificationsByUserID(ctx, other.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+
+		// The events are kept, but no longer hold the erased user's data, nor the API clients that made them
+		events, err := db.GetAuditEvents(ctx, models.AuditFilter{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, events, 5)
+		actors := []string{}
+		for _, e := range events {
+			if e.EntityId == user.Id && e.EntityType == models.AuditEntityUser || e.EntityId == sub.Id && e.EntityType == models.AuditEntitySubscription {
+				assert.Nil(t, e.Before, "%s %d", e.EntityType, e.EntityId)
+				assert.Nil(t, e.After, "%s %d", e.EntityType, e.EntityId)
+			} else {
+				assert.Contains(t, string(e.After), "b@example.com")
+			}
+			actors = append(actors, e.Actor)
+		}
+		assert.Equal(t, []string{"cli:admin", "anonymous:ip:198.51.100.1", "cli:admin", models.AuditActorErased, models.AuditActorErased}, actors)
+
+		erased, err = db.EraseUser(ctx, user.Id)
+		require.NoError(t, err)
+		assert.False(t, erased, "erasing a missing user")
+
+		// Soft-deleted users can be erased before they are purged
+		require.NoError(t, db.DeleteUser(ctx, other.Id))
+		erased, err = db.EraseUser(ctx, other.Id)
+		require.NoError(t, err)
+		assert.True(t, erased)
+
+		createUser(t, db, "a@example.com")
+	})
+
+	t.Run("Notifications", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		sub := createSubscription(t, db, user)
+
+		_, err := db.CreateNotification(ctx, &models.Notification{UserId: user.Id, SubscriptionId: 999, SentAt: base, Channel: models.ChannelEmail})
+		assert.Error(t, err, "notification of a missing subscription")
+		_, err = db.CreateNotification(ctx, &models.Notification{UserId: 999, SubscriptionId: sub.Id, SentAt: base, Channel: models.ChannelEmail})
+		assert.Error(t, err, "notification of a missing user")
+
+		weather := &models.WeatherSnapshot{Main: "Clear", Temperature: 31.5, FeelsLike: 33, Humidity: 40}
+		withWeather := &models.Notification{UserId: user.Id, SubscriptionId: sub.Id, SentAt: base, Channel: models.ChannelEmail, ProviderMessageId: "msg-1", Weather: weather}
+		_, err = db.CreateNotification(ctx, withWeather)
+		require.NoError(t, err)
+		older := createNotification(t, db, sub, base.Add(-time.Hour))
+		sameTime := createNotification(t, db, sub, base)
+		newest := createNotification(t, db, sub, base.Add(time.Hour))
+
+		history, err := db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []int{newest.Id, sameTime.Id, withWeather.Id, older.Id}, ids(history))
+		assert.Equal(t, "msg-1", history[2].ProviderMessageId)
+		assert.Equal(t, weather, history[2].Weather)
+		assert.True(t, base.Equal(history[2].SentAt))
+		assert.Empty(t, history[0].ProviderMessageId)
+		assert.Nil(t, history[0].Weather)
+
+		from, to := base, base.Add(time.Hour)
+		history, err = db.GetNotificationsBySubscriptionID(ctx, sub.Id, models.NotificationFilter{From: &from, To: &to, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []int{newest.Id, sameTime.Id, withWeather.Id}, ids(history))
+
+		history, err = db.GetNotificationsBySubscriptionID(ctx, sub.Id, models.NotificationFilter{Limit: 2, Offset: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []int{sameTime.Id, withWeather.Id}, ids(history))
+
+		history, err = db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 0})
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		history, err = db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10, Offset: 10})
+		require.NoError(t, err)
+		assert.Empty(t, history)
+	})
+
+	t.Run("CreateNotificationsIsAllOrNothing", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		sub := createSubscription(t, db, user)
+
+		weather := &models.WeatherSnapshot{Main: "Clear", Temperature: 31.5, FeelsLike: 33, Humidity: 40}
+		require.NoError(t, db.CreateNotifications(ctx, nil))
+		require.NoError(t, db.CreateNotifications(ctx, []models.Notification{
+			{UserId: user.Id, SubscriptionId: sub.Id, SentAt: base, Channel: models.ChannelEmail},
+			{UserId: user.Id, SubscriptionId: sub.Id, SentAt: base.Add(time.Minute), Channel: models.ChannelEmail, ProviderMessageId: "msg-1", Weather: weather},
+		}))
+
+		err := db.CreateNotifications(ctx, []models.Notification{
+			{UserId: user.Id, SubscriptionId: sub.Id, SentAt: base, Channel: models.ChannelEmail},
+			{UserId: user.Id, SubscriptionId: 999, SentAt: base, Channel: models.ChannelEmail},
+		})
+		assert.Error(t, err)
+
+		history, err := db.GetNotificationsByUserID(ctx, user.Id, models.NotificationFilter{Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, "msg-1", history[0].ProviderMessageId)
+		assert.Equal(t, weather, history[0].Weather)
+		assert.True(t, base.Add(time.Minute).Equal(history[0].SentAt))
+		assert.Empty(t, history[1].ProviderMessageId)
+		assert.Nil(t, history[1].Weather)
+	})
+
+	t.Run("DeferredNotifications", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "a@example.com")
+		sub := createSubscription(t, db, user)
+
+		_, err := db.CreateDeferredNotification(ctx, &models.DeferredNotification{UserId: user.Id, SubscriptionId: 999, Subject: "s", Body: "b", DeliverAfter: base})
+		assert.Error(t, err, "deferred notification of a missing subscription")
+
+		create := func(deliverAfter time.Time) *models.DeferredNotification {
+			n := &models.DeferredNotification{UserId: user.Id, SubscriptionId: sub.Id, Subject: "Weather alert", Body: "It is hot", DeliverAfter: deliverAfter}
+			_, err := db.CreateDeferredNotification(ctx, n)
+			require.NoError(t, err)
+			return n
+		}
+		later := create(base.Add(-time.Minute))
+		earlier := create(base.Add(-time.Hour))
+		onTime := create(base)
+		create(base.Add(time.Second))
+
+		due, err := db.GetDueDeferredNotifications(ctx, base)
+		require.NoError(t, err)
+		require.Len(t, due, 3)
+		assert.Equal(t, []int{earlier.Id, later.Id, onTime.Id}, []int{due[0].Id, due[1].Id, due[2].Id})
+		assert.Equal(t, "It is hot", due[0].Body)
+		assert.True(t, earlier.DeliverAfter.Equal(due[0].DeliverAfter))
+
+		require.NoError(t, db.DeleteDeferredNotification(ctx, earlier.Id))
+		assert.NoError(t, db.DeleteDeferredNotification(ctx, earlier.Id), "deleting a missing deferred notification")
+		due, err = db.GetDueDeferredNotifications(ctx, base)
+		require.NoError(t, err)
+		assert.Len(t, due, 2)
+	})
+
+	t.Run("WithTx", func(t *testing.T) {
+		db := newDB(t)
+
+		err := db.WithTx(ctx, func(tx database.IDB) error {
+			createUser(t, tx, "committed@example.com")
+			// A nested call joins the outer transaction
+			return tx.WithTx(ctx, func(tx database.IDB) error {
+				createUser(t, tx, "nested@example.com")
+				return nil
+			})
+		})
+		require.NoError(t, err)
+
+		failure := errors.New("failure")
+		err = db.WithTx(ctx, func(tx database.IDB) error {
+			user := createUser(t, tx, "rolled-back@example.com")
+			createSubscription(t, tx, user)
+			found, err := tx.GetUserByEmail(ctx, "rolled-back@example.com")
+			require.NoError(t, err)
+			assert.NotNil(t, found, "the transaction sees its own writes")
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+
+		users, err := db.GetUsers(ctx)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, "committed@example.com", users[0].Email)
+		assert.Equal(t, "nested@example.com", users[1].Email)
+		subscriptions, err := db.GetSubscriptions(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, subscriptions)
+	})
+
+	t.Run("AuditLog", func(t *testing.T) {
+		db := newDB(t)
+
+		empty, err := db.GetAuditEvents(ctx, models.AuditFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, empty)
+
+		created := &models.AuditEvent{EntityType: models.AuditEntityUser, EntityId: 1, Action: models.AuditActionCreate, Actor: "api:ip:10.0.0.1",
+			After: json.RawMessage(`{"id":1,"name":"Test"}`), RequestId: "req-1"}
+		_, err = db.CreateAuditEvent(ctx, created)
+		require.NoError(t, err)
+		assert.NotZero(t, created.Id)
+		assert.False(t, created.CreatedAt.IsZero())
+
+		for _, e := range []models.AuditEvent{
+			{EntityType: models.AuditEntityUser, EntityId: 1, Action: models.AuditActionUpdate, Actor: "cli:admin",
+				Before: json.RawMessage(`{"id":1,"name":"Test"}`), After: json.RawMessage(`{"id":1,"name":"Renamed"}`)},
+			{EntityType: models.AuditEntitySubscription, EntityId: 1, Action: models.AuditActionCreate, Actor: "api:ip:10.0.0.1",
+				After: json.RawMessage(`{"id":1}`)},
+			{EntityType: models.AuditEntityUser, EntityId: 2, Action: models.AuditActionDelete, Actor: "system",
+				Before: json.RawMessage(`{"id":2}`)},
+		} {
+			_, err := db.CreateAuditEvent(ctx, &e)
+			require.NoError(t, err)
+		}
+
+		events, err := db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, models.AuditActionUpdate, events[0].Action, "newest first")
+		assert.JSONEq(t, `{"id":1,"name":"Test"}`, string(events[0].Before))
+		assert.JSONEq(t, `{"id":1,"name":"Renamed"}`, string(events[0].After))
+		assert.Equal(t, created.Id, events[1].Id)
+		assert.Nil(t, events[1].Before)
+		assert.Equal(t, "req-1", events[1].RequestId)
+		assert.Equal(t, created.CreatedAt, events[1].CreatedAt)
+
+		events, err = db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, events, 3)
+
+		events, err = db.GetAuditEvents(ctx, models.AuditFilter{Actor: "api:ip:10.0.0.1", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, models.AuditEntitySubscription, events[0].EntityType)
+
+		events, err = db.GetAuditEvents(ctx, models.AuditFilter{Limit: 2, Offset: 1})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, models.AuditEntitySubscription, events[0].EntityType)
+		assert.Nil(t, events[0].Before)
+		assert.Equal(t, models.AuditActionUpdate, events[1].Action)
+
+		// Audit events outlive the entities they describe
+		user := createUser(t, db, "a@example.com")
+		require.NoError(t, db.DeleteUser(ctx, user.Id))
+		_, _, err = db.PurgeDeleted(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+		events, err = db.GetAuditEvents(ctx, models.AuditFilter{Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, events, 4)
+	})
+}
@@ -0,0 +1,99 @@
+// internal/tests/Logging_test.go
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// captureLogs sends the default logger to a buffer for the duration of the test
+func captureLogs(t *testing.T, cfg *config.Config) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	assert.NoError(t, logging.Update(cfg))
+	slog.SetDefault(slog.New(logging.NewHandler(&buf, "json")))
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		_ = logging.Update(&config.Config{LogLevel: "info"})
+	})
+	return &buf
+}
+
+func TestLogging_RedactsSecrets(t *testing.T) {
+	buf := captureLogs(t, &config.Config{LogLevel: "info"})
+
+	slog.Info("GET https://api.example.com/weather?appid=secret123&q=Kyiv",
+		"api_key", "secret456",
+		"error", errors.New("dial postgres://app:hunter2@db:5432/weatherapp failed"),
+		"email", "user@example.com",
+	)
+
+	out := buf.String()
+	assert.NotContains(t, out, "secret123")
+	assert.NotContains(t, out, "secret456")
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "q=Kyiv")
+	assert.Contains(t, out, "user@example.com") // emails are kept unless configured otherwise
+}
+
+func TestLogging_RedactsEmailsWhenConfigured(t *testing.T) {
+	buf := captureLogs(t, &config.Config{LogLevel: "info", LogRedactEmails: true})
+
+	slog.Info("Notification sent", "to", "user@example.com")
+
+	assert.NotContains(t, buf.String(), "user@example.com")
+	assert.Contains(t, buf.String(), "u***@example.com")
+}
+
+func TestLoggingMiddleware_RequestID(t *testing.T) {
+	buf := captureLogs(t, &config.Config{LogLevel: "info"})
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "handling request")
+	}))
+
+	request := httptest.NewRequest("GET", "/weather", nil)
+	request.Header.Set(logging.RequestIDHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, "abc-123", recorder.Header().Get(logging.RequestIDHeader))
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte(`"request_id":"abc-123"`)))
+}
+
+func TestLoggingMiddleware_GeneratesRequestID(t *testing.T) {
+	captureLogs(t, &config.Config{LogLevel: "info"})
+	handler := logging.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, logging.RequestID(r.Context()))
+	}))
+
+	request := httptest.NewRequest("GET", "/weather", nil)
+	request.Header.Set(logging.RequestIDHeader, "not a valid id\n")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Regexp(t, "^[0-9a-f]{16}$", recorder.Header().Get(logging.RequestIDHeader))
+}
+
+func TestWeatherClient_DoesNotLogAPIKey(t *testing.T) {
+	buf := captureLogs(t, &config.Config{LogLevel: "debug"})
+	server := newWeatherServer(t)
+	client := &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}}
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+
+	_, err := client.GetWeather(ctx, "New York")
+
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"request_id":"req-1"`)
+	assert.NotContains(t, buf.String(), "test-key")
+}
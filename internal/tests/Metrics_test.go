@@ -0,0 +1,80 @@
+// internal/tests/Metrics_test.go
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"maxcool.com/weatherapp/internal/metrics"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func TestMetricsMiddleware_UsesRouteTemplate(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(metrics.Middleware)
+	r.HandleFunc("/users/{id:[0-9]+}/notifications", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	counter := metrics.HTTPRequests.WithLabelValues("GET", "/users/{id:[0-9]+}/notifications", "404")
+	before := testutil.ToFloat64(counter)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1/notifications", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/2/notifications", nil))
+
+	assert.Equal(t, before+2, testutil.ToFloat64(counter))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics.SubscriptionsEvaluated.Add(0)
+	recorder := httptest.NewRecorder()
+
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.Contains(recorder.Body.String(), "weatherapp_subscriptions_evaluated_total"))
+	assert.True(t, strings.Contains(recorder.Body.String(), "go_goroutines"))
+}
+
+func TestSendNotificationToUsers_Metrics(t *testing.T) {
+	server := newWeatherServer(t)
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{}
+	subscriptionService := &services.SubscriptionService{
+		DB:       mockDB,
+		Weather:  &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}},
+		Notifier: notifier,
+	}
+
+	subscriptions := []models.Subscription{
+		{Id: 1, UserId: 1, City: "New York", Condition: "temperature:>:20", UserEmail: "test@example.com", Active: true},
+		{Id: 2, UserId: 1, City: "new york", Condition: "humidity:>:90", UserEmail: "test@example.com", Active: true},
+	}
+	mockDB.On("ExpireSubscriptions", mock.Anything).Return(nil, nil)
+	mockDB.On("ListSubscriptions", mock.Anything).Return(subscriptions, nil)
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Email: "test@example.com"}, nil)
+	mockDB.On("CreateNotifications", mock.Anything).Return(nil).Once()
+
+	hits := testutil.ToFloat64(metrics.WeatherCacheLookups.WithLabelValues("hit"))
+	evaluated := testutil.ToFloat64(metrics.SubscriptionsEvaluated)
+	matched := testutil.ToFloat64(metrics.SubscriptionsMatched)
+	sent := testutil.ToFloat64(metrics.NotificationsSent.WithLabelValues("fake"))
+
+	err := subscriptionService.SendNotificationToUsers(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.WeatherCacheLookups.WithLabelValues("hit")))
+	assert.Equal(t, evaluated+2, testutil.ToFloat64(metrics.SubscriptionsEvaluated))
+	assert.Equal(t, matched+1, testutil.ToFloat64(metrics.SubscriptionsMatched))
+	assert.Equal(t, sent+1, testutil.ToFloat64(metrics.NotificationsSent.WithLabelValues("fake")))
+	assert.Len(t, notifier.sent, 1)
+	mockDB.AssertExpectations(t)
+}
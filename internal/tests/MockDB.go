@@ -2,76 +2,171 @@
 package tests
 
 import (
+	"context"
+	"time"
+
 	"github.com/stretchr/testify/mock"
 	"maxcool.com/weatherapp/internal/database"
 	"maxcool.com/weatherapp/internal/models"
 )
 
+// MockDB is a testify mock of database.IDB
+// Methods returning a pointer or slice accept an untyped nil, e.g. On("GetUserByID", 1).Return(nil, nil)
 type MockDB struct {
 	mock.Mock
 }
 
-func (m *MockDB) GetUserByID(id int) (*models.User, error) {
+func (m *MockDB) GetUserByID(ctx context.Context, id int) (*models.User, error) {
 	args := m.Called(id)
-	return args.Get(0).(*models.User), args.Error(1)
+	result, _ := args.Get(0).(*models.User)
+	return result, args.Error(1)
 }
 
-func (m *MockDB) GetUserByEmail(email string) (*models.User, error) {
+func (m *MockDB) GetUserIncludingDeleted(ctx context.Context, id int) (*models.User, error) {
+	args := m.Called(id)
+	result, _ := args.Get(0).(*models.User)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	args := m.Called(email)
-	return args.Get(0).(*models.User), args.Error(1)
+	result, _ := args.Get(0).(*models.User)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) GetUsers(ctx context.Context) ([]models.User, error) {
+	args := m.Called()
+	result, _ := args.Get(0).([]models.User)
+	return result, args.Error(1)
 }
 
-func (m *MockDB) CreateUser(user *models.User) (int, error) {
+func (m *MockDB) CreateUser(ctx context.Context, user *models.User) (int, error) {
 	args := m.Called(user)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockDB) UpdateUser(user *models.User) error {
+func (m *MockDB) UpdateUser(ctx context.Context, user *models.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockDB) DeleteUser(id int) error {
+func (m *MockDB) DeleteUser(ctx context.Context, id int) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
-func (m *MockDB) CreateSubscription(sub *models.Subscription) (int, error) {
+func (m *MockDB) CreateSubscription(ctx context.Context, sub *models.Subscription) (int, error) {
 	args := m.Called(sub)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockDB) GetSubscriptionByID(subID int) (*models.Subscription, error) {
+func (m *MockDB) GetSubscriptionByID(ctx context.Context, subID int) (*models.Subscription, error) {
 	args := m.Called(subID)
-	return args.Get(0).(*models.Subscription), args.Error(1)
+	result, _ := args.Get(0).(*models.Subscription)
+	return result, args.Error(1)
 }
 
-func (m *MockDB) UpdateSubscription(sub *models.Subscription) error {
+func (m *MockDB) UpdateSubscription(ctx context.Context, sub *models.Subscription) error {
 	args := m.Called(sub)
 	return args.Error(0)
 }
 
-func (m *MockDB) DeleteSubscription(subID int) error {
+func (m *MockDB) DeleteSubscription(ctx context.Context, subID int) error {
 	args := m.Called(subID)
 	return args.Error(0)
 }
 
-func (m *MockDB) GetSubscriptions() ([]models.Subscription, error) {
+func (m *MockDB) GetSubscriptions(ctx context.Context) ([]models.Subscription, error) {
 	args := m.Called()
-	return args.Get(0).([]models.Subscription), args.Error(1)
+	result, _ := args.Get(0).([]models.Subscription)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) ListSubscriptions(ctx context.Context, filter models.SubscriptionFilter) ([]models.Subscription, error) {
+	args := m.Called(filter)
+	result, _ := args.Get(0).([]models.Subscription)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) ExpireSubscriptions(ctx context.Context, now time.Time) ([]int, error) {
+	args := m.Called(now)
+	result, _ := args.Get(0).([]int)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) PurgeDeleted(ctx context.Context, before time.Time) ([]int, []int, error) {
+	args := m.Called(before)
+	userIDs, _ := args.Get(0).([]int)
+	subscriptionIDs, _ := args.Get(1).([]int)
+	return userIDs, subscriptionIDs, args.Error(2)
+}
+
+func (m *MockDB) EraseUser(ctx context.Context, userID int) (bool, error) {
+	args := m.Called(userID)
+	return args.Bool(0), args.Error(1)
 }
 
 func (m *MockDB) Close() {
 }
 
-func (m *MockDB) CreateNotification(notification *models.Notification) (int, error) {
+// WithTx runs fn against the mock itself, so expectations are the same inside and outside transactions
+func (m *MockDB) WithTx(ctx context.Context, fn func(tx database.IDB) error) error {
+	return fn(m)
+}
+
+func (m *MockDB) CreateNotification(ctx context.Context, notification *models.Notification) (int, error) {
 	args := m.Called(notification)
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockDB) GetSubscriptionsByUserID(userID int) ([]models.Subscription, error) {
+func (m *MockDB) CreateNotifications(ctx context.Context, notifications []models.Notification) error {
+	args := m.Called(notifications)
+	return args.Error(0)
+}
+
+func (m *MockDB) GetNotificationsByUserID(ctx context.Context, userID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	args := m.Called(userID, filter)
+	result, _ := args.Get(0).([]models.Notification)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) GetNotificationsBySubscriptionID(ctx context.Context, subID int, filter models.NotificationFilter) ([]models.Notification, error) {
+	args := m.Called(subID, filter)
+	result, _ := args.Get(0).([]models.Notification)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) GetSubscriptionsByUserID(ctx context.Context, userID int) ([]models.Subscription, error) {
 	args := m.Called(userID)
-	return args.Get(0).([]models.Subscription), args.Error(1)
+	result, _ := args.Get(0).([]models.Subscription)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) CreateDeferredNotification(ctx context.Context, deferred *models.DeferredNotification) (int, error) {
+	args := m.Called(deferred)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDB) GetDueDeferredNotifications(ctx context.Context, now time.Time) ([]models.DeferredNotification, error) {
+	args := m.Called(now)
+	result, _ := args.Get(0).([]models.DeferredNotification)
+	return result, args.Error(1)
+}
+
+func (m *MockDB) DeleteDeferredNotification(ctx context.Context, id int) error {
+	args := m.Called(id)
+	return args.Error(0)
 }
 
 var _ database.IDB = &MockDB{}
+
+func (m *MockDB) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) (int, error) {
+	args := m.Called(event)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDB) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	args := m.Called(filter)
+	result, _ := args.Get(0).([]models.AuditEvent)
+	return result, args.Error(1)
+}
@@ -0,0 +1,73 @@
+// internal/tests/NotificationService_test.go
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func TestGetNotificationsByUserID(t *testing.T) {
+	mockDB := new(MockDB)
+	notificationService := services.NewNotificationService(mockDB)
+
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	expectedNotifications := []models.Notification{
+		{Id: 2, UserId: 1, SubscriptionId: 3, Channel: models.ChannelEmail, ProviderMessageId: "msg-2",
+			Weather: &models.WeatherSnapshot{Main: "Clear", Temperature: 31.5, FeelsLike: 33, Humidity: 40}},
+	}
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1}, nil)
+	mockDB.On("GetNotificationsByUserID", 1, models.NotificationFilter{From: &from, Limit: services.DefaultNotificationPageSize}).
+		Return(expectedNotifications, nil)
+
+	page, err := notificationService.GetNotificationsByUserID(context.Background(), 1, models.NotificationFilter{From: &from})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedNotifications, page.Notifications)
+	assert.Equal(t, services.DefaultNotificationPageSize, page.Limit)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetNotificationsByUserID_UserNotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	notificationService := services.NewNotificationService(mockDB)
+
+	mockDB.On("GetUserByID", 1).Return((*models.User)(nil), nil)
+
+	_, err := notificationService.GetNotificationsByUserID(context.Background(), 1, models.NotificationFilter{})
+
+	assert.ErrorIs(t, err, services.ErrUserNotFound)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetNotificationsBySubscriptionID_ClampsLimit(t *testing.T) {
+	mockDB := new(MockDB)
+	notificationService := services.NewNotificationService(mockDB)
+
+	mockDB.On("GetSubscriptionByID", 3).Return(&models.Subscription{Id: 3}, nil)
+	mockDB.On("GetNotificationsBySubscriptionID", 3, models.NotificationFilter{Limit: services.MaxNotificationPageSize, Offset: 10}).
+		Return([]models.Notification{}, nil)
+
+	page, err := notificationService.GetNotificationsBySubscriptionID(context.Background(), 3, models.NotificationFilter{Limit: 1000, Offset: 10})
+
+	assert.NoError(t, err)
+	assert.Empty(t, page.Notifications)
+	assert.Equal(t, services.MaxNotificationPageSize, page.Limit)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetNotificationsBySubscriptionID_NotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	notificationService := services.NewNotificationService(mockDB)
+
+	mockDB.On("GetSubscriptionByID", 3).Return((*models.Subscription)(nil), nil)
+
+	_, err := notificationService.GetNotificationsBySubscriptionID(context.Background(), 3, models.NotificationFilter{})
+
+	assert.ErrorIs(t, err, services.ErrSubscriptionNotFound)
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,249 @@
+// internal/tests/OpenAPI_test.go
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/health"
+	"maxcool.com/weatherapp/internal/models"
+	"maxcool.com/weatherapp/internal/server"
+)
+
+// openAPIDocument is the part of the OpenAPI document the tests compare with the code
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Type       string                   `json:"type"`
+	Ref        string                   `json:"$ref"`
+	Items      *openAPISchema           `json:"items"`
+	Properties map[string]openAPISchema `json:"properties"`
+}
+
+func loadOpenAPIDocument(t *testing.T) openAPIDocument {
+	var doc openAPIDocument
+	require.NoError(t, json.Unmarshal(server.OpenAPISpec, &doc))
+	return doc
+}
+
+// routeVariable matches a path variable with a pattern, e.g. {id:[0-9]+}
+var routeVariable = regexp.MustCompile(`\{(\w+):[^}]+\}`)
+
+func TestOpenAPI_DocumentsAllRoutes(t *testing.T) {
+	router := server.NewRouter(&handlers.Handler{}, nil)
+
+	var routes []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes = append(routes, strings.ToLower(method)+" "+routeVariable.ReplaceAllString(path, "{$1}"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var documented []string
+	for path, operations := range loadOpenAPIDocument(t).Paths {
+		for method := range operations {
+			documented = append(documented, method+" "+path)
+		}
+	}
+
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented)
+}
+
+func TestOpenAPI_SchemasMatchModels(t *testing.T) {
+	schemas := loadOpenAPIDocument(t).Components.Schemas
+
+	for name, model := range map[string]any{
+		"UserRequest":                models.UserRequest{},
+		"UserResponse":               models.UserResponse{},
+		"UserExport":                 models.UserExport{},
+		"DeliveryPreferences":        models.DeliveryPreferences{},
+		"SubscriptionDto":            models.SubscriptionDto{},
+		"SubscriptionResponse":       models.SubscriptionResponse{},
+		"SubscriptionPage":           models.SubscriptionPage{},
+		"PauseRequest":               models.PauseRequest{},
+		"Notification":               models.Notification{},
+		"NotificationPage":           models.NotificationPage{},
+		"WeatherSnapshot":            models.WeatherSnapshot{},
+		"ConditionEvaluationRequest": models.ConditionEvaluationRequest{},
+		"ConditionEvaluation":        models.ConditionEvaluation{},
+		"ParsedCondition":            models.ParsedCondition{},
+		"ExpressionValue":            models.ExpressionValue{},
+		"HealthReport":               health.Report{},
+		"ComponentStatus":            health.ComponentStatus{},
+		"AuditEvent":                 models.AuditEvent{},
+		"AuditPage":                  models.AuditPage{},
+	} {
+		schema, ok := schemas[name]
+		if !assert.True(t, ok, "schema %s is missing", name) {
+			continue
+		}
+
+		modelType := reflect.TypeOf(model)
+		fields := map[string]reflect.Type{}
+		for i := 0; i < modelType.NumField(); i++ {
+			field := modelType.Field(i)
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if jsonName != "" && jsonName != "-" {
+				fields[jsonName] = field.Type
+			}
+		}
+
+		assert.ElementsMatch(t, keys(fields), keys(schema.Properties), "properties of %s", name)
+		for jsonName, fieldType := range fields {
+			if property, ok := schema.Properties[jsonName]; ok {
+				assert.Equal(t, openAPIType(fieldType), propertyType(property), "type of %s.%s", name, jsonName)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	router := server.NewRouter(&handlers.Handler{}, nil)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(recorder.Body.Bytes()))
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/docs", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "openapi.json")
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
+
+// openAPIType returns the OpenAPI type a Go type is encoded as, "" for values of any type
+func openAPIType(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return "string"
+	}
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	case reflect.Struct:
+		return "object"
+	default:
+		return ""
+	}
+}
+
+// propertyType returns the type of a schema property, "object" for references to other schemas
+func propertyType(property openAPISchema) string {
+	if property.Ref != "" {
+		return "object"
+	}
+	return property.Type
+}
+
+func TestOpenAPI_ValidatesRequests(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+
+	for _, c := range []struct{ method, path, body, problem string }{
+		{"POST", "/user", `{"name":5,"email":"test@example.com"}`, "name must be a string"},
+		{"POST", "/user", `{"name":"Test","email":"test@example.com","digest_mode":"yes"}`, "digest_mode must be true or false"},
+		{"POST", "/user", `["Test"]`, "request body must be a JSON object"},
+		{"POST", "/user", `{}`, "name is required; email is required"},
+		{"PUT", "/users/1/preferences", `{"quiet_hours_policy":"later"}`, "quiet_hours_policy must be one of: defer, drop"},
+		{"POST", "/subscriptions/1/pause", `{"until":"tomorrow"}`, "until must be an RFC 3339 date-time"},
+		{"POST", "/conditions/evaluate", `{"condition":"temperature:>:20"}`, "city is required"},
+		{"GET", "/users/1/notifications?limit=500", "", "limit must be at most 200"},
+		{"GET", "/users/1/notifications?offset=x", "", "offset must be an integer"},
+		{"GET", "/users/0", "", "id must be at least 1"},
+		{"GET", "/weather", "", "city is required"},
+	} {
+		recorder := serveJSON(router, c.method, c.path, c.body)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, c.path+" "+c.body)
+		assert.Contains(t, recorder.Body.String(), "validation failed: "+c.problem, c.path+" "+c.body)
+	}
+	mockDB.AssertExpectations(t) // nothing reached the database
+}
+
+func TestOpenAPI_ValidRequestReachesHandler(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newAdminTestRouter(t, mockDB)
+	mockDB.On("GetNotificationsByUserID", 1, models.NotificationFilter{Limit: 200, Offset: 10}).Return([]models.Notification{}, nil)
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1}, nil).Maybe()
+
+	recorder := serveAdmin(router, "GET", "/users/1/notifications?limit=200&offset=10")
+
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	mockDB.AssertExpectations(t)
+}
+
+func TestOpenAPI_RejectsOversizedBody(t *testing.T) {
+	mockDB := new(MockDB)
+	router := newTestRouter(t, mockDB)
+
+	body := `{"name":"` + strings.Repeat("a", 1<<20) + `","email":"test@example.com"}`
+	recorder := serveJSON(router, "POST", "/user", body)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	mockDB.AssertExpectations(t) // nothing reached the database
+}
+
+func TestOpenAPI_RejectsUnsupportedKeywords(t *testing.T) {
+	require.NoError(t, server.CheckOpenAPISpec(server.OpenAPISpec))
+
+	for _, c := range []struct{ spec, problem string }{
+		{`{"paths":{"/user":{"post":{"requestBody":{"content":{"application/json":{"schema":{"$ref":"#/components/schemas/User"}}}}}}},
+			"components":{"schemas":{"User":{"type":"object","properties":{"name":{"type":"string","pattern":"^[a-z]+$"}}}}}}`, "User.name: keyword pattern"},
+		{`{"paths":{"/users":{"get":{"parameters":[{"name":"ids","in":"query","schema":{"type":"array"}}]}}}}`, "parameter ids: type array"},
+		{`{"paths":{"/users":{"get":{"parameters":[{"name":"since","in":"query","schema":{"type":"string","format":"date"}}]}}}}`, "parameter since: format date"},
+		{`{"paths":{"/users":{"get":{"parameters":[{"name":"X-Id","in":"header","schema":{"type":"string"}}]}}}}`, "parameter X-Id is in header"},
+	} {
+		err := server.CheckOpenAPISpec([]byte(c.spec))
+		require.Error(t, err, c.problem)
+		assert.Contains(t, err.Error(), c.problem)
+	}
+}
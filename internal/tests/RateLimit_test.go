@@ -0,0 +1,133 @@
+// internal/tests/RateLimit_test.go
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/ratelimit"
+)
+
+func newRateLimitedRouter(t *testing.T, cfg *config.Config) *mux.Router {
+	limiter, err := ratelimit.New(cfg)
+	require.NoError(t, err)
+
+	r := mux.NewRouter()
+	r.Use(limiter.Middleware)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.HandleFunc("/user", ok).Methods("POST")
+	r.HandleFunc("/weather", ok).Methods("GET")
+	return r
+}
+
+func rateLimitedRequest(r http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := config.ParseRateLimit("5/m")
+	require.NoError(t, err)
+	assert.Equal(t, config.RateLimit{Requests: 5, Period: time.Minute}, limit)
+
+	limit, err = config.ParseRateLimit("10/30s")
+	require.NoError(t, err)
+	assert.Equal(t, config.RateLimit{Requests: 10, Period: 30 * time.Second}, limit)
+
+	limit, err = config.ParseRateLimit("off")
+	require.NoError(t, err)
+	assert.True(t, limit.Unlimited())
+
+	for _, invalid := range []string{"", "5", "0/m", "x/m", "5/week", "5/-1s"} {
+		_, err := config.ParseRateLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRouteRateLimits(t *testing.T) {
+	cfg := &config.Config{RateLimitRoutes: "post /user=5/m, POST /subscribe=10/h"}
+	limits, err := cfg.RouteRateLimits()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]config.RateLimit{
+		"POST /user":      {Requests: 5, Period: time.Minute},
+		"POST /subscribe": {Requests: 10, Period: time.Hour},
+	}, limits)
+
+	cfg.RateLimitRoutes = "/user=5/m"
+	_, err = cfg.RouteRateLimits()
+	assert.Error(t, err)
+}
+
+func TestRateLimit_RejectsOverLimit(t *testing.T) {
+	r := newRateLimitedRouter(t, &config.Config{RateLimitDefault: "off", RateLimitRoutes: "POST /user=2/m"})
+
+	first := rateLimitedRequest(r, "POST", "/user", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", first.Header().Get("RateLimit-Policy"))
+
+	second := rateLimitedRequest(r, "POST", "/user", nil)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "0", second.Header().Get("RateLimit-Remaining"))
+
+	third := rateLimitedRequest(r, "POST", "/user", nil)
+	assert.Equal(t, http.StatusTooManyRequests, third.Code)
+	assert.Equal(t, "30", third.Header().Get("Retry-After"))
+	assert.Equal(t, "0", third.Header().Get("RateLimit-Remaining"))
+}
+
+func TestRateLimit_SeparateBucketsPerClient(t *testing.T) {
+	r := newRateLimitedRouter(t, &config.Config{RateLimitDefault: "off", RateLimitRoutes: "POST /user=1/m", APIKeys: "key-1, key-2"})
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", nil).Code)
+
+	// The same IP with an accepted API key is a different client, and each key has its own bucket
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-API-Key": "key-1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-API-Key": "key-1"}).Code)
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-API-Key": "key-2"}).Code)
+
+	// Unknown keys do not get a bucket of their own
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-API-Key": "made-up-1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-API-Key": "made-up-2"}).Code)
+
+	// X-Forwarded-For is ignored unless the proxy is trusted
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-Forwarded-For": "198.51.100.7"}).Code)
+}
+
+func TestRateLimit_TrustedProxy(t *testing.T) {
+	r := newRateLimitedRouter(t, &config.Config{RateLimitDefault: "off", RateLimitRoutes: "POST /user=1/m", RateLimitTrustProxy: true})
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-Forwarded-For": "198.51.100.7, 10.0.0.1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-Forwarded-For": "198.51.100.7"}).Code)
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", map[string]string{"X-Forwarded-For": "198.51.100.8"}).Code)
+}
+
+func TestRateLimit_DefaultLimit(t *testing.T) {
+	r := newRateLimitedRouter(t, &config.Config{RateLimitDefault: "off", RateLimitRoutes: "POST /user=1/m"})
+	for i := 0; i < 5; i++ {
+		recorder := rateLimitedRequest(r, "GET", "/weather", nil)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+	}
+
+	r = newRateLimitedRouter(t, &config.Config{RateLimitDefault: "1/h", RateLimitRoutes: "POST /user=off"})
+	assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "GET", "/weather", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(r, "GET", "/weather", nil).Code)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, rateLimitedRequest(r, "POST", "/user", nil).Code)
+	}
+}
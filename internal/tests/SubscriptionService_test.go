@@ -2,9 +2,16 @@
 package tests
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"maxcool.com/weatherapp/internal/database"
 	"maxcool.com/weatherapp/internal/models"
 	"maxcool.com/weatherapp/internal/services"
 )
@@ -19,7 +26,7 @@ func TestGetSubscriptionsByUserID(t *testing.T) {
 	}
 	mockDB.On("GetSubscriptionsByUserID", 1).Return(expectedSubscriptions, nil)
 
-	subscriptions, err := subscriptionService.GetSubscriptionsByUserID(1)
+	subscriptions, err := subscriptionService.GetSubscriptionsByUserID(context.Background(), 1)
 
 	assert.NoError(t, err)
 	assert.Len(t, subscriptions, 2)
@@ -36,10 +43,12 @@ func TestCreateSubscription(t *testing.T) {
 
 	mockDB.On("GetUserByEmail", "test@example.com").Return(user, nil)
 	mockDB.On("CreateSubscription", subscription).Return(1, nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionCreate)
 
-	err := subscriptionService.CreateSubscription(subscription)
+	err := subscriptionService.CreateSubscription(context.Background(), subscription)
 
 	assert.NoError(t, err)
+	assert.Equal(t, 1, subscription.Id)
 	assert.Equal(t, 1, subscription.UserId)
 	mockDB.AssertExpectations(t)
 }
@@ -52,7 +61,7 @@ func TestCreateSubscription_UserNotFound(t *testing.T) {
 
 	mockDB.On("GetUserByEmail", "test@example.com").Return((*models.User)(nil), nil)
 
-	err := subscriptionService.CreateSubscription(subscription)
+	err := subscriptionService.CreateSubscription(context.Background(), subscription)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "user not found")
@@ -65,9 +74,11 @@ func TestUpdateSubscription(t *testing.T) {
 
 	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30"}
 
+	mockDB.On("GetSubscriptionByID", 1).Return(&models.Subscription{Id: 1, City: "Kyiv", Condition: "temperature:>:30"}, nil)
 	mockDB.On("UpdateSubscription", subscription).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionUpdate)
 
-	err := subscriptionService.UpdateSubscription(subscription)
+	err := subscriptionService.UpdateSubscription(context.Background(), subscription)
 
 	assert.NoError(t, err)
 	mockDB.AssertExpectations(t)
@@ -77,9 +88,11 @@ func TestDeleteSubscription(t *testing.T) {
 	mockDB := new(MockDB)
 	subscriptionService := services.NewSubscriptionService(mockDB, nil)
 
+	mockDB.On("GetSubscriptionByID", 1).Return(&models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30"}, nil)
 	mockDB.On("DeleteSubscription", 1).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionDelete)
 
-	err := subscriptionService.DeleteSubscription(1)
+	err := subscriptionService.DeleteSubscription(context.Background(), 1)
 
 	assert.NoError(t, err)
 	mockDB.AssertExpectations(t)
@@ -92,9 +105,426 @@ func TestGetSubscriptionByID(t *testing.T) {
 	expectedSubscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30"}
 	mockDB.On("GetSubscriptionByID", 1).Return(expectedSubscription, nil)
 
-	subscription, err := subscriptionService.GetSubscriptionByID(1)
+	subscription, err := subscriptionService.GetSubscriptionByID(context.Background(), 1)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedSubscription, subscription)
 	mockDB.AssertExpectations(t)
 }
+
+func TestCreateSubscription_ExpiresBeforePause(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	pausedUntil := time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	user := &models.User{Id: 1, Email: "test@example.com"}
+	subscription := &models.Subscription{City: "New York", Condition: "temperature:>:30", UserEmail: "test@example.com",
+		PausedUntil: &pausedUntil, ExpiresAt: &expiresAt}
+
+	mockDB.On("GetUserByEmail", "test@example.com").Return(user, nil)
+
+	err := subscriptionService.CreateSubscription(context.Background(), subscription)
+
+	assert.ErrorIs(t, err, services.ErrInvalidSubscriptionSchedule)
+	mockDB.AssertNotCalled(t, "CreateSubscription", subscription)
+}
+
+func TestIsSubscriptionActive(t *testing.T) {
+	now := time.Date(2025, 6, 5, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	assert.True(t, services.IsSubscriptionActive(&models.Subscription{Active: true}, now))
+	assert.False(t, services.IsSubscriptionActive(&models.Subscription{Active: false}, now))
+	assert.False(t, services.IsSubscriptionActive(&models.Subscription{Active: true, PausedUntil: &future}, now))
+	assert.True(t, services.IsSubscriptionActive(&models.Subscription{Active: true, PausedUntil: &past}, now))
+	assert.False(t, services.IsSubscriptionActive(&models.Subscription{Active: true, ExpiresAt: &past}, now))
+	assert.True(t, services.IsSubscriptionActive(&models.Subscription{Active: true, ExpiresAt: &future}, now))
+}
+
+func TestPauseSubscription_Indefinitely(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", Active: true}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+	mockDB.On("UpdateSubscription", subscription).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionUpdate)
+
+	paused, err := subscriptionService.PauseSubscription(context.Background(), 1, nil)
+
+	assert.NoError(t, err)
+	assert.False(t, paused.Active)
+	assert.Nil(t, paused.PausedUntil)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPauseSubscription_Until(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	until := time.Now().Add(24 * time.Hour)
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", Active: true}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+	mockDB.On("UpdateSubscription", subscription).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionUpdate)
+
+	paused, err := subscriptionService.PauseSubscription(context.Background(), 1, &until)
+
+	assert.NoError(t, err)
+	assert.True(t, paused.Active)
+	assert.Equal(t, &until, paused.PausedUntil)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPauseSubscription_UntilResumesInactiveSubscription(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	// Paused until resumed, then paused again with an end
+	until := time.Now().Add(24 * time.Hour)
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", Active: false}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+	mockDB.On("UpdateSubscription", subscription).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionUpdate)
+
+	paused, err := subscriptionService.PauseSubscription(context.Background(), 1, &until)
+
+	assert.NoError(t, err)
+	assert.True(t, paused.Active)
+	assert.Equal(t, &until, paused.PausedUntil)
+	assert.False(t, services.IsSubscriptionActive(paused, time.Now()))
+	assert.True(t, services.IsSubscriptionActive(paused, until))
+	mockDB.AssertExpectations(t)
+}
+
+func TestPauseSubscription_UntilInThePast(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	until := time.Now().Add(-time.Hour)
+	_, err := subscriptionService.PauseSubscription(context.Background(), 1, &until)
+
+	assert.ErrorIs(t, err, services.ErrInvalidSubscriptionSchedule)
+	mockDB.AssertNotCalled(t, "UpdateSubscription", mock.Anything)
+}
+
+func TestPauseSubscription_Expired(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	expired := time.Now().Add(-time.Hour)
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", Active: false, ExpiresAt: &expired}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+
+	_, err := subscriptionService.PauseSubscription(context.Background(), 1, nil)
+
+	assert.ErrorIs(t, err, services.ErrSubscriptionExpired)
+	mockDB.AssertNotCalled(t, "UpdateSubscription", mock.Anything)
+}
+
+func TestResumeSubscription(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	until := time.Now().Add(24 * time.Hour)
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", Active: false, PausedUntil: &until}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+	mockDB.On("UpdateSubscription", subscription).Return(nil)
+	expectAudit(mockDB, models.AuditEntitySubscription, 1, models.AuditActionUpdate)
+
+	resumed, err := subscriptionService.ResumeSubscription(context.Background(), 1)
+
+	assert.NoError(t, err)
+	assert.True(t, resumed.Active)
+	assert.Nil(t, resumed.PausedUntil)
+	mockDB.AssertExpectations(t)
+}
+
+func TestResumeSubscription_Expired(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	expiredAt := time.Now().Add(-time.Hour)
+	subscription := &models.Subscription{Id: 1, City: "New York", Condition: "temperature:>:30", ExpiresAt: &expiredAt}
+	mockDB.On("GetSubscriptionByID", 1).Return(subscription, nil)
+
+	_, err := subscriptionService.ResumeSubscription(context.Background(), 1)
+
+	assert.ErrorIs(t, err, services.ErrSubscriptionExpired)
+	mockDB.AssertExpectations(t)
+}
+
+// fakeNotifier records the messages it is asked to send
+// Messages whose body contains failOn, if set, fail to send
+type fakeNotifier struct {
+	sent   []string
+	failOn string
+}
+
+func (n *fakeNotifier) Channel() string {
+	return "fake"
+}
+
+func (n *fakeNotifier) Send(ctx context.Context, to, subject, body string) (string, error) {
+	if n.failOn != "" && strings.Contains(body, n.failOn) {
+		return "", errors.New("smtp unavailable")
+	}
+	n.sent = append(n.sent, to+": "+subject)
+	return "message-1", nil
+}
+
+func TestSendDeferredNotifications_Digest(t *testing.T) {
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{}
+	subscriptionService := &services.SubscriptionService{DB: mockDB, Notifier: notifier}
+
+	user := &models.User{Id: 1, Email: "test@example.com", DigestMode: true}
+	due := []models.DeferredNotification{
+		{Id: 10, UserId: 1, SubscriptionId: 1, Body: "Rain in Kyiv"},
+		{Id: 11, UserId: 1, SubscriptionId: 2, Body: "Heat in Lviv"},
+	}
+	mockDB.On("GetDueDeferredNotifications", mock.Anything).Return(due, nil)
+	mockDB.On("GetUserByID", 1).Return(user, nil)
+	mockDB.On("CreateNotifications", mock.MatchedBy(func(notifications []models.Notification) bool {
+		return len(notifications) == 2 &&
+			notifications[0].SubscriptionId == 1 && notifications[1].SubscriptionId == 2 &&
+			notifications[0].Channel == "fake" && notifications[1].ProviderMessageId == "message-1"
+	})).Return(nil).Once()
+	mockDB.On("DeleteDeferredNotification", 10).Return(nil)
+	mockDB.On("DeleteDeferredNotification", 11).Return(nil)
+
+	err := subscriptionService.SendDeferredNotifications(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com: Weather Digest"}, notifier.sent)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSendDeferredNotifications_KeepsFailedOnes(t *testing.T) {
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{failOn: "Heat"}
+	subscriptionService := &services.SubscriptionService{DB: mockDB, Notifier: notifier}
+
+	due := []models.DeferredNotification{
+		{Id: 10, UserId: 1, SubscriptionId: 1, Body: "Rain in Kyiv"},
+		{Id: 11, UserId: 1, SubscriptionId: 2, Body: "Heat in Lviv"},
+	}
+	mockDB.On("GetDueDeferredNotifications", mock.Anything).Return(due, nil)
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Email: "test@example.com"}, nil)
+	mockDB.On("CreateNotifications", mock.Anything).Return(nil).Once()
+	mockDB.On("DeleteDeferredNotification", 10).Return(nil)
+
+	err := subscriptionService.SendDeferredNotifications(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com: Weather Update"}, notifier.sent)
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "DeleteDeferredNotification", 11)
+}
+
+func TestSendDeferredNotifications_KeepsFailedDigest(t *testing.T) {
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{failOn: "Rain"}
+	subscriptionService := &services.SubscriptionService{DB: mockDB, Notifier: notifier}
+
+	due := []models.DeferredNotification{
+		{Id: 10, UserId: 1, SubscriptionId: 1, Body: "Rain in Kyiv"},
+		{Id: 11, UserId: 1, SubscriptionId: 2, Body: "Heat in Lviv"},
+	}
+	mockDB.On("GetDueDeferredNotifications", mock.Anything).Return(due, nil)
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Email: "test@example.com", DigestMode: true}, nil)
+
+	err := subscriptionService.SendDeferredNotifications(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, notifier.sent)
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "DeleteDeferredNotification", mock.Anything)
+}
+
+func TestSendNotificationToUsers_SendsToCurrentEmail(t *testing.T) {
+	server := newWeatherServer(t)
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{}
+	subscriptionService := &services.SubscriptionService{
+		DB:       mockDB,
+		Weather:  &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}},
+		Notifier: notifier,
+	}
+
+	// The subscription still carries the address the user had when it was created
+	subscriptions := []models.Subscription{{Id: 1, UserId: 1, City: "New York", Condition: "temperature:>:20", UserEmail: "old@example.com", Active: true}}
+	mockDB.On("ExpireSubscriptions", mock.Anything).Return(nil, nil)
+	mockDB.On("ListSubscriptions", mock.Anything).Return(subscriptions, nil)
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Email: "new@example.com"}, nil)
+	mockDB.On("CreateNotifications", mock.Anything).Return(nil).Once()
+
+	err := subscriptionService.SendNotificationToUsers(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"new@example.com: Weather Update"}, notifier.sent)
+	mockDB.AssertExpectations(t)
+}
+
+// txMockDB is a MockDB that remembers whether statements run inside WithTx
+type txMockDB struct {
+	*MockDB
+	inTx bool
+}
+
+func (m *txMockDB) WithTx(ctx context.Context, fn func(tx database.IDB) error) error {
+	m.inTx = true
+	defer func() { m.inTx = false }()
+	return fn(m)
+}
+
+func TestCreateSubscription_RunsInTransaction(t *testing.T) {
+	mockDB := &txMockDB{MockDB: new(MockDB)}
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+	subscription := &models.Subscription{City: "New York", Condition: "temperature:>:30", UserEmail: "test@example.com"}
+
+	mockDB.On("GetUserByEmail", "test@example.com").Return(&models.User{Id: 1, Email: "test@example.com"}, nil).
+		Run(func(mock.Arguments) { assert.True(t, mockDB.inTx, "user lookup outside the transaction") })
+	mockDB.On("CreateSubscription", subscription).Return(7, nil).
+		Run(func(mock.Arguments) { assert.True(t, mockDB.inTx, "insert outside the transaction") })
+	expectAudit(mockDB.MockDB, models.AuditEntitySubscription, 7, models.AuditActionCreate).
+		Run(func(mock.Arguments) { assert.True(t, mockDB.inTx, "audit event outside the transaction") })
+
+	err := subscriptionService.CreateSubscription(context.Background(), subscription)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, subscription.Id)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCreateSubscription_InsertFailureReturnsError(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+	subscription := &models.Subscription{City: "New York", Condition: "temperature:>:30", UserEmail: "test@example.com"}
+
+	mockDB.On("GetUserByEmail", "test@example.com").Return(&models.User{Id: 1, Email: "test@example.com"}, nil)
+	mockDB.On("CreateSubscription", subscription).Return(0, errors.New("insert failed"))
+
+	err := subscriptionService.CreateSubscription(context.Background(), subscription)
+
+	assert.ErrorContains(t, err, "insert failed")
+	assert.Equal(t, 0, subscription.Id)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	start := time.Now()
+	mockDB.On("PurgeDeleted", mock.MatchedBy(func(before time.Time) bool {
+		cutoff := start.Add(-24 * time.Hour)
+		return !before.Before(cutoff) && before.Before(cutoff.Add(time.Minute))
+	})).Return([]int{1}, []int{2, 3}, nil)
+	expectAudit(mockDB, models.AuditEntityUser, 1, models.AuditActionPurge)
+	expectAudit(mockDB, models.AuditEntitySubscription, 2, models.AuditActionPurge)
+	expectAudit(mockDB, models.AuditEntitySubscription, 3, models.AuditActionPurge)
+
+	err := subscriptionService.PurgeDeleted(context.Background(), 24*time.Hour)
+
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPurgeDeleted_Error(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+
+	mockDB.On("PurgeDeleted", mock.Anything).Return(nil, nil, errors.New("db down"))
+
+	err := subscriptionService.PurgeDeleted(context.Background(), time.Hour)
+
+	assert.ErrorContains(t, err, "db down")
+	mockDB.AssertExpectations(t)
+}
+
+func TestListSubscriptions_NextCursor(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+	subscriptions := []models.Subscription{{Id: 3, City: "Kyiv"}, {Id: 5, City: "Kyiv"}, {Id: 8, City: "Kyiv"}}
+	mockDB.On("ListSubscriptions", models.SubscriptionFilter{City: "Kyiv", AfterId: 2, Limit: 3}).Return(subscriptions, nil)
+
+	page, err := subscriptionService.ListSubscriptions(context.Background(), models.SubscriptionFilter{City: "Kyiv", AfterId: 2, Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, page.Limit)
+	assert.Len(t, page.Subscriptions, 2)
+	afterID, err := services.DecodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, afterID)
+	mockDB.AssertExpectations(t)
+}
+
+func TestListSubscriptions_LastPage(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+	mockDB.On("ListSubscriptions", models.SubscriptionFilter{Limit: services.MaxSubscriptionPageSize + 1}).Return([]models.Subscription{{Id: 1}}, nil)
+
+	page, err := subscriptionService.ListSubscriptions(context.Background(), models.SubscriptionFilter{Limit: 1000})
+
+	assert.NoError(t, err)
+	assert.Equal(t, services.MaxSubscriptionPageSize, page.Limit)
+	assert.Len(t, page.Subscriptions, 1)
+	assert.Empty(t, page.NextCursor)
+	mockDB.AssertExpectations(t)
+}
+
+func TestListUserSubscriptions_UserNotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	subscriptionService := services.NewSubscriptionService(mockDB, nil)
+	mockDB.On("GetUserByID", 7).Return(nil, nil)
+
+	_, err := subscriptionService.ListUserSubscriptions(context.Background(), 7, models.SubscriptionFilter{})
+
+	assert.ErrorIs(t, err, services.ErrUserNotFound)
+	mockDB.AssertNotCalled(t, "ListSubscriptions", mock.Anything)
+}
+
+func TestDecodeCursor_Invalid(t *testing.T) {
+	for _, cursor := range []string{"%%%", services.EncodeCursor(0), "YWJj"} {
+		_, err := services.DecodeCursor(cursor)
+		assert.ErrorIs(t, err, services.ErrInvalidCursor, cursor)
+	}
+}
+
+func TestSendNotificationToUsers_LoadsSubscriptionsInBatches(t *testing.T) {
+	server := newWeatherServer(t)
+	mockDB := new(MockDB)
+	notifier := &fakeNotifier{}
+	subscriptionService := &services.SubscriptionService{
+		DB:        mockDB,
+		Weather:   &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}},
+		Notifier:  notifier,
+		BatchSize: 2,
+	}
+
+	batch := func(afterID int) any {
+		return mock.MatchedBy(func(f models.SubscriptionFilter) bool {
+			return f.AfterId == afterID && f.Limit == 2 && f.Active != nil && *f.Active
+		})
+	}
+	mockDB.On("ExpireSubscriptions", mock.Anything).Return(nil, nil)
+	mockDB.On("ListSubscriptions", batch(0)).Return([]models.Subscription{
+		{Id: 1, UserId: 1, City: "New York", Condition: "temperature:>:20", Active: true},
+		{Id: 2, UserId: 2, City: "New York", Condition: "temperature:>:20", Active: true},
+	}, nil).Once()
+	mockDB.On("ListSubscriptions", batch(2)).Return([]models.Subscription{
+		{Id: 4, UserId: 1, City: "New York", Condition: "humidity:>:20", Active: true},
+	}, nil).Once()
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Email: "one@example.com", DigestMode: true}, nil)
+	mockDB.On("GetUserByID", 2).Return(&models.User{Id: 2, Email: "two@example.com"}, nil)
+	mockDB.On("CreateNotifications", mock.Anything).Return(nil)
+
+	err := subscriptionService.SendNotificationToUsers(context.Background())
+
+	assert.NoError(t, err)
+	// Hits of a user are grouped across batches
+	assert.Equal(t, []string{"one@example.com: Weather Digest", "two@example.com: Weather Update"}, notifier.sent)
+	mockDB.AssertExpectations(t)
+}
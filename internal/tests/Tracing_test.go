@@ -0,0 +1,92 @@
+// internal/tests/Tracing_test.go
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/services"
+	"maxcool.com/weatherapp/internal/tracing"
+)
+
+// recordSpans installs a tracer provider that keeps the finished spans in memory for the duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestCheckCondition_Spans(t *testing.T) {
+	recorder := recordSpans(t)
+	server := newWeatherServer(t)
+	subscriptionService := &services.SubscriptionService{
+		Weather: &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second, Transport: tracing.Transport(nil)}},
+	}
+
+	met, err := subscriptionService.CheckCondition(context.Background(), "temperature:>:20", "New York")
+
+	assert.NoError(t, err)
+	assert.True(t, met)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	check, weather := spans["SubscriptionService.CheckCondition"], spans["SubscriptionService.GetWeather"]
+	assert.NotNil(t, check)
+	assert.NotNil(t, weather)
+	assert.Equal(t, check.SpanContext().SpanID(), weather.Parent().SpanID())
+
+	outbound := spans["GET "+server.Listener.Addr().String()]
+	assert.NotNil(t, outbound)
+	assert.Equal(t, weather.SpanContext().SpanID(), outbound.Parent().SpanID())
+}
+
+func TestTracingTransport_OmitsQueryFromSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	server := newWeatherServer(t)
+	client := &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second, Transport: tracing.Transport(nil)}}
+
+	// The weather server checks that the API key still reaches it
+	_, err := client.GetWeather(context.Background(), "New York")
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.NotEmpty(t, spans)
+	for _, span := range spans {
+		for _, attr := range span.Attributes() {
+			value := attr.Value.Emit()
+			assert.NotContains(t, value, "appid", "%s: %s", span.Name(), attr.Key)
+			assert.NotContains(t, value, "test-key", "%s: %s", span.Name(), attr.Key)
+		}
+	}
+}
+
+func TestCheckCondition_SpanRecordsError(t *testing.T) {
+	recorder := recordSpans(t)
+	subscriptionService := &services.SubscriptionService{}
+
+	_, err := subscriptionService.CheckCondition(context.Background(), "temperature", "New York")
+
+	assert.Error(t, err)
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "Error", spans[0].Status().Code.String())
+}
+
+func TestTracingSetup_None(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), &config.Config{TracingExporter: "none"})
+
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
@@ -2,10 +2,14 @@
 package tests
 
 import (
+	"context"
 	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"maxcool.com/weatherapp/internal/database"
 	"maxcool.com/weatherapp/internal/models"
 	"maxcool.com/weatherapp/internal/services"
 )
@@ -17,7 +21,7 @@ func TestGetUserByID(t *testing.T) {
 	expectedUser := &models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com"}
 	mockDB.On("GetUserByID", 1).Return(expectedUser, nil)
 
-	user, err := userService.GetUserByID(1)
+	user, err := userService.GetUserByID(context.Background(), 1)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedUser, user)
@@ -30,7 +34,7 @@ func TestGetUserByID_NotFound(t *testing.T) {
 
 	mockDB.On("GetUserByID", 1).Return((*models.User)(nil), nil)
 
-	user, err := userService.GetUserByID(1)
+	user, err := userService.GetUserByID(context.Background(), 1)
 
 	assert.NoError(t, err)
 	assert.Nil(t, user)
@@ -43,8 +47,9 @@ func TestCreateUser(t *testing.T) {
 
 	newUser := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
 	mockDB.On("CreateUser", newUser).Return(1, nil)
+	expectAudit(mockDB, models.AuditEntityUser, 1, models.AuditActionCreate)
 
-	err := userService.CreateUser(newUser)
+	err := userService.CreateUser(context.Background(), newUser)
 
 	assert.NoError(t, err)
 	assert.Equal(t, 1, newUser.Id)
@@ -58,7 +63,7 @@ func TestCreateUser_Error(t *testing.T) {
 	newUser := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
 	mockDB.On("CreateUser", newUser).Return(0, errors.New("database error"))
 
-	err := userService.CreateUser(newUser)
+	err := userService.CreateUser(context.Background(), newUser)
 
 	assert.Error(t, err)
 	assert.Equal(t, 0, newUser.Id)
@@ -70,9 +75,11 @@ func TestUpdateUser(t *testing.T) {
 	userService := services.NewUserService(mockDB)
 
 	updatedUser := &models.User{Id: 1, Name: "John Smith", Email: "john.smith@example.com"}
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com"}, nil)
 	mockDB.On("UpdateUser", updatedUser).Return(nil)
+	expectAudit(mockDB, models.AuditEntityUser, 1, models.AuditActionUpdate)
 
-	err := userService.UpdateUser(updatedUser)
+	err := userService.UpdateUser(context.Background(), updatedUser)
 
 	assert.NoError(t, err)
 	mockDB.AssertExpectations(t)
@@ -83,9 +90,10 @@ func TestUpdateUser_Error(t *testing.T) {
 	userService := services.NewUserService(mockDB)
 
 	updatedUser := &models.User{Id: 1, Name: "John Smith", Email: "john.smith@example.com"}
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com"}, nil)
 	mockDB.On("UpdateUser", updatedUser).Return(errors.New("database error"))
 
-	err := userService.UpdateUser(updatedUser)
+	err := userService.UpdateUser(context.Background(), updatedUser)
 
 	assert.Error(t, err)
 	mockDB.AssertExpectations(t)
@@ -95,22 +103,194 @@ func TestDeleteUser(t *testing.T) {
 	mockDB := new(MockDB)
 	userService := services.NewUserService(mockDB)
 
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com"}, nil)
 	mockDB.On("DeleteUser", 1).Return(nil)
+	expectAudit(mockDB, models.AuditEntityUser, 1, models.AuditActionDelete)
 
-	err := userService.DeleteUser(1)
+	deleted, err := userService.DeleteUser(context.Background(), 1)
 
 	assert.NoError(t, err)
+	assert.True(t, deleted)
 	mockDB.AssertExpectations(t)
 }
 
+func TestDeleteUser_NotFound(t *testing.T) {
+	mockDB := new(MockDB)
+	userService := services.NewUserService(mockDB)
+
+	mockDB.On("GetUserByID", 1).Return(nil, nil)
+
+	deleted, err := userService.DeleteUser(context.Background(), 1)
+
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	mockDB.AssertNotCalled(t, "DeleteUser", 1)
+}
+
 func TestDeleteUser_Error(t *testing.T) {
 	mockDB := new(MockDB)
 	userService := services.NewUserService(mockDB)
 
+	mockDB.On("GetUserByID", 1).Return(&models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com"}, nil)
 	mockDB.On("DeleteUser", 1).Return(errors.New("database error"))
 
-	err := userService.DeleteUser(1)
+	_, err := userService.DeleteUser(context.Background(), 1)
 
 	assert.Error(t, err)
 	mockDB.AssertExpectations(t)
 }
+
+func TestCreateUser_InvalidPreferences(t *testing.T) {
+	mockDB := new(MockDB)
+	userService := services.NewUserService(mockDB)
+
+	newUser := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Timezone: "Nowhere/Town"}
+
+	err := userService.CreateUser(context.Background(), newUser)
+
+	assert.ErrorIs(t, err, services.ErrInvalidDeliveryPreferences)
+	mockDB.AssertNotCalled(t, "CreateUser", newUser)
+}
+
+func TestUpdateDeliveryPreferences(t *testing.T) {
+	mockDB := new(MockDB)
+	userService := services.NewUserService(mockDB)
+
+	existingUser := &models.User{Id: 1, Name: "John Doe", Email: "john.doe@example.com", Timezone: "UTC"}
+	mockDB.On("GetUserByID", 1).Return(existingUser, nil)
+	mockDB.On("UpdateUser", existingUser).Return(nil)
+	expectAudit(mockDB, models.AuditEntityUser, 1, models.AuditActionUpdate)
+
+	prefs := &models.DeliveryPreferences{Timezone: "Europe/Kyiv", QuietHoursStart: "22:00", QuietHoursEnd: "07:00", QuietHoursPolicy: "drop"}
+	user, err := userService.UpdateDeliveryPreferences(context.Background(), 1, prefs)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/Kyiv", user.Timezone)
+	assert.Equal(t, "22:00", user.QuietHoursStart)
+	assert.Equal(t, models.QuietHoursPolicyDrop, user.QuietHoursPolicy)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetUsers(t *testing.T) {
+	mockDB := new(MockDB)
+	userService := services.NewUserService(mockDB)
+
+	expectedUsers := []models.User{
+		{Id: 1, Name: "John Doe", Email: "john.doe@example.com"},
+		{Id: 2, Name: "Jane Doe", Email: "jane.doe@example.com"},
+	}
+	mockDB.On("GetUsers").Return(expectedUsers, nil)
+
+	users, err := userService.GetUsers(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedUsers, users)
+	mockDB.AssertExpectations(t)
+}
+
+func TestExportUser(t *testing.T) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(db)
+	ctx := context.Background()
+
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, userService.CreateUser(ctx, user))
+	subscription := &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "temperature:>:30", UserEmail: user.Email, Active: true}
+	_, err := db.CreateSubscription(ctx, subscription)
+	require.NoError(t, err)
+	_, err = db.CreateNotification(ctx, &models.Notification{UserId: user.Id, SubscriptionId: subscription.Id, Channel: models.ChannelEmail, ProviderMessageId: "msg-1"})
+	require.NoError(t, err)
+
+	export, err := userService.ExportUser(ctx, user.Id)
+
+	require.NoError(t, err)
+	require.NotNil(t, export)
+	assert.Equal(t, user.Email, export.User.Email)
+	require.Len(t, export.Subscriptions, 1)
+	assert.Equal(t, "Kyiv", export.Subscriptions[0].City)
+	require.Len(t, export.Notifications, 1)
+	assert.Equal(t, "msg-1", export.Notifications[0].ProviderMessageId)
+	events, err := db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: user.Id, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditActionExport, events[0].Action)
+}
+
+func TestExportUser_Deleted(t *testing.T) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(db)
+	ctx := context.Background()
+
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, userService.CreateUser(ctx, user))
+	deleted := &models.Subscription{UserId: user.Id, City: "Kyiv", Condition: "main:rain", UserEmail: user.Email, Active: true}
+	_, err := db.CreateSubscription(ctx, deleted)
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteSubscription(ctx, deleted.Id))
+	kept := &models.Subscription{UserId: user.Id, City: "Lviv", Condition: "main:snow", UserEmail: user.Email, Active: true}
+	_, err = db.CreateSubscription(ctx, kept)
+	require.NoError(t, err)
+	_, err = userService.DeleteUser(ctx, user.Id)
+	require.NoError(t, err)
+
+	// The data of deleted users is held until they are purged, so it is exported too
+	export, err := userService.ExportUser(ctx, user.Id)
+
+	require.NoError(t, err)
+	require.NotNil(t, export)
+	assert.Equal(t, user.Email, export.User.Email)
+	require.Len(t, export.Subscriptions, 2)
+	assert.Equal(t, "Kyiv", export.Subscriptions[0].City)
+	assert.Equal(t, "Lviv", export.Subscriptions[1].City)
+}
+
+func TestExportUser_NotFound(t *testing.T) {
+	db := database.NewMemoryDB()
+
+	export, err := services.NewUserService(db).ExportUser(context.Background(), 1)
+
+	assert.NoError(t, err)
+	assert.Nil(t, export)
+	events, err := db.GetAuditEvents(context.Background(), models.AuditFilter{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestEraseUser(t *testing.T) {
+	db := database.NewMemoryDB()
+	userService := services.NewUserService(db)
+	ctx := context.Background()
+
+	user := &models.User{Name: "Jane Doe", Email: "jane.doe@example.com"}
+	require.NoError(t, userService.CreateUser(ctx, user))
+
+	erased, err := userService.EraseUser(ctx, user.Id)
+
+	require.NoError(t, err)
+	assert.True(t, erased)
+	events, err := db.GetAuditEvents(ctx, models.AuditFilter{EntityType: models.AuditEntityUser, EntityId: user.Id, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	for _, e := range events {
+		assert.Nil(t, e.Before)
+		assert.Nil(t, e.After)
+	}
+	assert.Equal(t, models.AuditActionErase, events[0].Action)
+	assert.Equal(t, models.AuditActionCreate, events[1].Action)
+
+	erased, err = userService.EraseUser(ctx, user.Id)
+	require.NoError(t, err)
+	assert.False(t, erased, "erasing a missing user")
+}
+
+func TestEraseUser_Error(t *testing.T) {
+	mockDB := new(MockDB)
+	userService := services.NewUserService(mockDB)
+	mockDB.On("EraseUser", 1).Return(false, errors.New("database error"))
+
+	erased, err := userService.EraseUser(context.Background(), 1)
+
+	assert.ErrorContains(t, err, "failed to erase user")
+	assert.False(t, erased)
+	mockDB.AssertNotCalled(t, "CreateAuditEvent", mock.Anything)
+}
@@ -0,0 +1,65 @@
+// internal/tests/WeatherClient_test.go
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+func newWeatherServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-key", r.URL.Query().Get("appid"))
+		assert.Equal(t, "metric", r.URL.Query().Get("units"))
+
+		if r.URL.Query().Get("q") != "New York" {
+			http.Error(w, `{"cod":"404","message":"city not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"weather":[{"id":800,"main":"Clear"}],"main":{"temp":25.5,"feels_like":26,"humidity":40}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWeatherClient_GetWeather(t *testing.T) {
+	server := newWeatherServer(t)
+	client := &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}}
+
+	weather, err := client.GetWeather(context.Background(), "New York")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 25.5, weather.Main.Temp)
+	assert.Equal(t, "Clear", weather.Weather[0].Main)
+}
+
+func TestWeatherClient_CityExists(t *testing.T) {
+	server := newWeatherServer(t)
+	client := &services.WeatherClient{BaseURL: server.URL, APIKey: "test-key", HTTP: &http.Client{Timeout: time.Second}}
+
+	exists, err := client.CityExists(context.Background(), "New York")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = client.CityExists(context.Background(), "Atlantis")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestWeatherClient_Update(t *testing.T) {
+	server := newWeatherServer(t)
+	client := &services.WeatherClient{BaseURL: "http://127.0.0.1:1", APIKey: "old-key", HTTP: &http.Client{Timeout: time.Second}}
+
+	client.Update(&config.Config{OpenWeatherMapBaseURL: server.URL, OpenWeatherMapAPIKey: "test-key", WeatherRequestTimeout: time.Second})
+	weather, err := client.GetWeather(context.Background(), "New York")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 25.5, weather.Main.Temp)
+}
@@ -0,0 +1,121 @@
+// internal/tracing/tracing.go
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"maxcool.com/weatherapp/internal/config"
+)
+
+// instrumentationName identifies the spans created by this application
+const instrumentationName = "maxcool.com/weatherapp"
+
+// Setup installs the global tracer provider with the exporter chosen in the configuration
+// The "stdout" exporter prints spans to the console, "otlp" sends them to a collector over OTLP/HTTP
+// and with "none" spans are not recorded at all
+// It returns a function that flushes pending spans and stops the exporter
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.TracingExporter) {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr)) // stdout is reserved for command output
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(cfg.TracingOTLPEndpoint), otlptracehttp.WithInsecure())
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.TracingExporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.TracingServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named after the operation, e.g. "SubscriptionService.SendNotificationToUsers"
+func Start(ctx context.Context, name string, attrs ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, attrs...)
+}
+
+// End records err on the span, if any, and ends the span
+// It is meant to be deferred with a pointer to the named error result of the traced function
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// Transport wraps an HTTP transport so every outbound request gets a client span
+// and carries the trace context to the remote service
+// The span sees the request URL without its query string, which may hold an API key
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	traced := otelhttp.NewTransport(restoreQuery{base}, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + r.URL.Host
+	}))
+	return stripQuery{traced}
+}
+
+type queryKey struct{}
+
+// stripQuery hands the request to the tracing transport without its query string,
+// which travels in the request context to restoreQuery
+type stripQuery struct {
+	next http.RoundTripper
+}
+
+func (t stripQuery) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.RawQuery == "" {
+		return t.next.RoundTrip(r)
+	}
+	stripped := r.Clone(context.WithValue(r.Context(), queryKey{}, r.URL.RawQuery))
+	stripped.URL.RawQuery = ""
+	return t.next.RoundTrip(stripped)
+}
+
+// restoreQuery puts the query string removed by stripQuery back before the request is sent
+type restoreQuery struct {
+	next http.RoundTripper
+}
+
+func (t restoreQuery) RoundTrip(r *http.Request) (*http.Response, error) {
+	query, ok := r.Context().Value(queryKey{}).(string)
+	if !ok {
+		return t.next.RoundTrip(r)
+	}
+	restored := r.Clone(r.Context())
+	restored.URL.RawQuery = query
+	return t.next.RoundTrip(restored)
+}
@@ -3,119 +3,94 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
-	"github.com/go-co-op/gocron/v2"
 	"maxcool.com/weatherapp/internal/config"
-	"maxcool.com/weatherapp/internal/database"
-	"maxcool.com/weatherapp/internal/handlers"
-	"maxcool.com/weatherapp/internal/server"
-	"maxcool.com/weatherapp/internal/services"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/tracing"
 )
 
-func main() {
-	// Load Configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+// command is a subcommand of the weatherapp binary
+type command struct {
+	name        string
+	description string
+	run         func(cfg *config.Config, args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the HTTP API (add --worker to also run the scheduler)", runServe},
+	{"worker", "run the notification scheduler only", runWorker},
+	{"notify", "run the notification job (use --once to run it immediately and exit)", runNotify},
+	{"user", "manage users: create, list, delete", runUser},
+	{"subscription", "inspect subscriptions: list, test", runSubscription},
+	{"weather", "query the weather provider: get <city>", runWeather},
+	{"migrate", "manage the database schema: up, down, goto, version, force", runMigrate},
+}
+
+func usage() string {
+	text := "usage: weatherapp [configuration flags] [command] [arguments]\n\nRun `weatherapp --help` for the configuration flags. Without a command the API and the scheduler run together.\n\ncommands:\n"
+	for _, c := range commands {
+		text += fmt.Sprintf("  %-13s %s\n", c.name, c.description)
 	}
-	log.Println("Configuration loaded successfully.")
+	return text
+}
 
-	if err = database.EnsureDatabaseExists("weatherapp", cfg.PostgresConnectionString); err != nil {
-		log.Fatalf("Failed to ensure database existence: %v", err)
+// flushTraces sends the spans that are still buffered before the process exits
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
-	log.Println("Correct database existance ensured.")
+}
 
-	// Migrate Database
-	database.MigrateUpAll(cfg)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "help" {
+		fmt.Print(usage())
+		return
+	}
 
-	// Initialize Database Connection
-	db, err := database.NewDB(cfg.PostgresConnectionString)
+	// Load Configuration; configuration flags come before the command
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Print(usage())
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	defer db.Close()
-	log.Println("Database connection established.")
-
-	// Create Handlers with Dependencies
-	appHandler := handlers.NewHandler(services.NewUserService(db), services.NewSubscriptionService(db, cfg), cfg)
-
-	// Setup Router and Server
-	router := server.NewRouter(appHandler)
-	srv := server.NewServer(":"+cfg.ServerPort, router)
-
-	// Start Server
-	go func() {
-		log.Printf("Server starting on port %s", cfg.ServerPort)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
-		}
-	}()
+	if err := logging.Setup(cfg); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	slog.Info("Configuration loaded successfully.")
 
-	// Create a new scheduler instance
-	s, err := gocron.NewScheduler()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to create scheduler: %v", err)
+		log.Fatalf("Failed to set up tracing: %v", err)
 	}
-	defer func() {
-		_ = s.Shutdown()
-	}()
 
-	// Define the task function
-	taskFunc := func() {
-		log.Println("Running user notification task...")
-		if err := appHandler.SubscriptionService.SendNotificationToUsers(); err != nil {
-			log.Printf("Error sending notifications: %v", err)
-		}
+	// Without a command behave like the original single-process deployment
+	if len(args) == 0 {
+		args = []string{"serve", "--worker"}
 	}
 
-	// Schedule the task
-	_, _ = s.NewJob(
-		gocron.DailyJob(
-			1,
-			gocron.NewAtTimes(
-				gocron.NewAtTime(12, 0, 0),
-			),
-		),
-		gocron.NewTask(
-			taskFunc,
-		),
-	)
-	// debug task
-	// _, _ = s.NewJob(
-	// 	gocron.DurationJob(time.Second*20),
-	// 	gocron.NewTask(
-	// 		func() {
-	// 			log.Print("Running scheduled task every 20 seconds...")
-	// 			taskFunc()
-	// 		},
-	// 	),
-	// )
-	// s.Start()
-
-	// Graceful Shutdown
-	// Create a channel to listen for OS signals
-	stop := make(chan os.Signal, 1)
-	// Register to receive SIGINT (Ctrl+C) and SIGTERM (termination signal)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-
-	// Block until a signal is received
-	<-stop
-	log.Println("Shutting down server...")
-
-	// Create a context with a timeout for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // 5 seconds to wait for active requests
-	defer cancel()                                                          // Release resources associated with this context
-
-	// Attempt to gracefully shut down the server
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	for _, c := range commands {
+		if c.name == args[0] {
+			err := c.run(cfg, args[1:])
+			flushTraces(shutdownTracing)
+			if err != nil {
+				log.Fatalf("%s: %v", c.name, err)
+			}
+			return
+		}
 	}
 
-	log.Println("Server exited gracefully.")
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage())
+	os.Exit(2)
 }
@@ -0,0 +1,77 @@
+// migrate.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/database"
+)
+
+const migrateUsage = `usage: weatherapp migrate <command>
+
+commands:
+  up              apply all pending migrations
+  down [N]        roll back N migrations, or all of them without N
+  goto VERSION    migrate up or down to VERSION
+  version         print the current migration version
+  force VERSION   set VERSION without migrating and clear the dirty flag`
+
+// runMigrate executes the `migrate` subcommand with the given arguments
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n%s", migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		// An SQLite database file is created on first connection
+		if !cfg.UsesSQLite() {
+			if err := database.EnsureDatabaseExists("weatherapp", cfg.PostgresConnectionString); err != nil {
+				return fmt.Errorf("failed to ensure database existence: %w", err)
+			}
+		}
+		return database.MigrateUpAll(cfg)
+	case "down":
+		if len(args) < 2 {
+			return database.MigrateDownAll(cfg)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of migrations: %s", args[1])
+		}
+		return database.MigrateSteps(cfg, -n)
+	case "goto":
+		if len(args) < 2 {
+			return fmt.Errorf("missing version\n%s", migrateUsage)
+		}
+		version, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[1])
+		}
+		return database.MigrateGoto(cfg, uint(version))
+	case "version":
+		version, dirty, err := database.MigrationVersion(cfg)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+		return nil
+	case "force":
+		if len(args) < 2 {
+			return fmt.Errorf("missing version\n%s", migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", args[1])
+		}
+		return database.MigrateForce(cfg, version)
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
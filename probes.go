@@ -0,0 +1,57 @@
+// probes.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maxcool.com/weatherapp/internal/database"
+	"maxcool.com/weatherapp/internal/health"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+const (
+	// readinessTimeout limits each readiness check
+	readinessTimeout = 2 * time.Second
+	// weatherCheckInterval is how long the result of the weather provider check is reused,
+	// so probes do not use up the provider's request quota
+	weatherCheckInterval = time.Minute
+)
+
+// newHealthChecker creates the readiness checks for the database, the schema, the weather provider and the notifier
+func newHealthChecker(a *app) *health.Checker {
+	checks := []health.Check{
+		{Name: "database", Run: a.DB.Ping},
+		{Name: "migrations", Run: func(ctx context.Context) error {
+			return checkSchemaVersion(ctx, a.DB)
+		}},
+		{Name: "weather", Run: health.Cached(weatherCheckInterval, a.SubscriptionService.Weather.Ping)},
+	}
+	if notifier, ok := a.SubscriptionService.Notifier.(*services.EmailNotifier); ok {
+		checks = append(checks, health.Check{Name: "notifier", Run: func(ctx context.Context) error {
+			return notifier.Check()
+		}})
+	}
+	return health.NewChecker(readinessTimeout, checks...)
+}
+
+// checkSchemaVersion fails if the last migration failed halfway or the schema is older than the binary
+func checkSchemaVersion(ctx context.Context, db *database.DB) error {
+	version, dirty, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d failed halfway, run `weatherapp migrate force`", version)
+	}
+
+	latest, err := database.LatestMigrationVersion()
+	if err != nil {
+		return err
+	}
+	if version < latest {
+		return fmt.Errorf("schema version %d is behind %d, run `weatherapp migrate up`", version, latest)
+	}
+	return nil
+}
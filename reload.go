@@ -0,0 +1,117 @@
+// reload.go
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloader applies configuration changes to a running process
+// It reloads on SIGHUP and whenever the config file is modified
+type reloader struct {
+	config    *config.Config
+	weather   *services.WeatherClient
+	notifier  *services.EmailNotifier
+	scheduler *scheduler // nil when the process does not run the scheduler
+	modTime   time.Time
+}
+
+// startReloader starts watching for configuration changes and returns a function that stops watching
+func startReloader(a *app, s *scheduler) func() {
+	r := &reloader{config: a.Config, weather: a.SubscriptionService.Weather, scheduler: s}
+	if notifier, ok := a.SubscriptionService.Notifier.(*services.EmailNotifier); ok {
+		r.notifier = notifier
+	}
+	r.modTime = r.configModTime()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				slog.Info("SIGHUP received, reloading configuration...")
+				r.reload()
+			case <-ticker.C:
+				if modTime := r.configModTime(); !modTime.Equal(r.modTime) {
+					r.modTime = modTime
+					slog.Info("Config file changed, reloading configuration...", "file", r.config.ConfigFile())
+					r.reload()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(hup)
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// configModTime returns the modification time of the config file, or the zero time if there is none
+func (r *reloader) configModTime() time.Time {
+	if r.config.ConfigFile() == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(r.config.ConfigFile())
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload loads the configuration again and applies the settings that can change at runtime
+// An invalid configuration is rejected as a whole and the running settings are kept
+func (r *reloader) reload() {
+	cfg, err := r.config.Reload()
+	if err != nil {
+		slog.Error("Configuration not reloaded", "error", err)
+		return
+	}
+
+	changed, restart := r.config.Diff(cfg)
+	if len(restart) > 0 {
+		slog.Warn("Ignoring changed settings that require a restart", "settings", strings.Join(restart, ", "))
+	}
+	if len(changed) == 0 {
+		slog.Info("No runtime settings changed.")
+		return
+	}
+	// The running process keeps its restart-only settings, so the warning repeats until it is restarted
+	cfg = r.config.WithReloadable(cfg)
+
+	if err := logging.Update(cfg); err != nil {
+		slog.Error("Failed to change logging settings", "error", err)
+	}
+	if r.weather != nil {
+		r.weather.Update(cfg)
+	}
+	if r.notifier != nil {
+		r.notifier.Update(cfg)
+	}
+	if r.scheduler != nil {
+		if err := r.scheduler.Reschedule(cfg); err != nil {
+			slog.Error("Failed to reschedule notification jobs", "error", err)
+		}
+	}
+
+	r.config = cfg
+	slog.Info("Configuration reloaded", "settings", strings.Join(changed, ", "))
+}
@@ -0,0 +1,256 @@
+// serve.go
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-co-op/gocron/v2"
+	"maxcool.com/weatherapp/internal/config"
+	"maxcool.com/weatherapp/internal/handlers"
+	"maxcool.com/weatherapp/internal/logging"
+	"maxcool.com/weatherapp/internal/ratelimit"
+	"maxcool.com/weatherapp/internal/server"
+	"maxcool.com/weatherapp/internal/services"
+)
+
+// waitForShutdown blocks until SIGINT (Ctrl+C) or SIGTERM is received
+func waitForShutdown() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+}
+
+// scheduler runs the notification jobs and can move them when the configuration is reloaded
+type scheduler struct {
+	gocron.Scheduler
+	subscriptionService services.ISubscriptionService
+	notifyJob           gocron.Job
+	flushJob            gocron.Job
+	purgeJob            gocron.Job
+}
+
+// newScheduler creates a started scheduler running the notification jobs at the configured times
+func newScheduler(cfg *config.Config, subscriptionService services.ISubscriptionService) (*scheduler, error) {
+	s, err := gocron.NewScheduler()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scheduler: %w", err)
+	}
+
+	sched := &scheduler{Scheduler: s, subscriptionService: subscriptionService}
+	if err := sched.Reschedule(cfg); err != nil {
+		return nil, err
+	}
+
+	s.Start()
+	return sched, nil
+}
+
+// Reschedule schedules the notification jobs at the times from the configuration,
+// replacing the jobs scheduled before
+func (s *scheduler) Reschedule(cfg *config.Config) error {
+	at, err := cfg.NotificationClock()
+	if err != nil {
+		return err
+	}
+
+	// Send notifications for met conditions once a day
+	notifyDefinition := gocron.DailyJob(
+		1,
+		gocron.NewAtTimes(
+			gocron.NewAtTime(uint(at.Hour()), uint(at.Minute()), 0),
+		),
+	)
+	notifyTask := gocron.NewTask(
+		func() {
+			ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+			slog.InfoContext(ctx, "Running user notification task...")
+			if err := s.subscriptionService.SendNotificationToUsers(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error sending notifications", "error", err)
+			}
+		},
+	)
+	if s.notifyJob, err = s.schedule(s.notifyJob, notifyDefinition, notifyTask); err != nil {
+		return fmt.Errorf("failed to schedule notification job: %w", err)
+	}
+
+	// Deliver notifications held back by quiet hours once their window opens
+	flushDefinition := gocron.DurationJob(cfg.DeferredFlushInterval)
+	flushTask := gocron.NewTask(
+		func() {
+			ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+			if err := s.subscriptionService.SendDeferredNotifications(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error sending deferred notifications", "error", err)
+			}
+		},
+	)
+	if s.flushJob, err = s.schedule(s.flushJob, flushDefinition, flushTask); err != nil {
+		return fmt.Errorf("failed to schedule deferred notification job: %w", err)
+	}
+
+	// Permanently remove users and subscriptions deleted longer than the retention period ago
+	retention := cfg.PurgeRetention
+	purgeDefinition := gocron.DurationJob(cfg.PurgeInterval)
+	purgeTask := gocron.NewTask(
+		func() {
+			ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+			if err := s.subscriptionService.PurgeDeleted(ctx, retention); err != nil {
+				slog.ErrorContext(ctx, "Error purging deleted users and subscriptions", "error", err)
+			}
+		},
+	)
+	if s.purgeJob, err = s.schedule(s.purgeJob, purgeDefinition, purgeTask); err != nil {
+		return fmt.Errorf("failed to schedule purge job: %w", err)
+	}
+	return nil
+}
+
+// schedule adds a new job, or updates the given job in place if it already exists
+func (s *scheduler) schedule(job gocron.Job, definition gocron.JobDefinition, task gocron.Task) (gocron.Job, error) {
+	if job == nil {
+		return s.NewJob(definition, task)
+	}
+	return s.Scheduler.Update(job.ID(), definition, task)
+}
+
+// runServe runs the HTTP API until SIGINT or SIGTERM, optionally together with the scheduler
+func runServe(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	withWorker := fs.Bool("worker", false, "also run the notification scheduler in this process")
+	migrate := fs.Bool("migrate", true, "ensure the database exists and apply pending migrations on start")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := newApp(cfg, *migrate)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	// Create Handlers with Dependencies
+	appHandler := handlers.NewHandler(a.UserService, a.SubscriptionService, a.NotificationService, cfg)
+	appHandler.Health = newHealthChecker(a)
+	appHandler.AuditService = a.AuditService
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimitEnabled {
+		limiter, err = ratelimit.New(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Setup Router and Server
+	router := server.NewRouter(appHandler, limiter)
+	srv := server.NewServer(cfg, router)
+
+	// Start Server
+	go func() {
+		slog.Info("Server starting", "port", cfg.ServerPort)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	var s *scheduler
+	if *withWorker {
+		s, err = newScheduler(cfg, a.SubscriptionService)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = s.Shutdown()
+		}()
+		slog.Info("Scheduler started.")
+	}
+
+	stopReloader := startReloader(a, s)
+	defer stopReloader()
+
+	waitForShutdown()
+	slog.Info("Shutting down server...")
+
+	// Create a context with a timeout for shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout) // time to wait for active requests
+	defer cancel()                                                                // Release resources associated with this context
+
+	// Attempt to gracefully shut down the server
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("server forced to shutdown: %w", err)
+	}
+
+	slog.Info("Server exited gracefully.")
+	return nil
+}
+
+// runWorker runs the notification scheduler until SIGINT or SIGTERM
+func runWorker(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	migrate := fs.Bool("migrate", true, "ensure the database exists and apply pending migrations on start")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a, err := newApp(cfg, *migrate)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	s, err := newScheduler(cfg, a.SubscriptionService)
+	if err != nil {
+		return err
+	}
+	slog.Info("Scheduler started.")
+
+	stopReloader := startReloader(a, s)
+	defer stopReloader()
+
+	waitForShutdown()
+	slog.Info("Shutting down scheduler...")
+	if err := s.Shutdown(); err != nil {
+		return fmt.Errorf("scheduler shutdown failed: %w", err)
+	}
+
+	slog.Info("Scheduler exited gracefully.")
+	return nil
+}
+
+// runNotify runs the notification job right away
+func runNotify(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("notify", flag.ContinueOnError)
+	once := fs.Bool("once", false, "run the notification job once and exit")
+	deferred := fs.Bool("deferred", false, "also deliver deferred notifications that are due")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*once {
+		return fmt.Errorf("use `notify --once` to run the job immediately, or `worker` to run it on schedule")
+	}
+
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	slog.InfoContext(ctx, "Running user notification task...")
+	if err := a.SubscriptionService.SendNotificationToUsers(ctx); err != nil {
+		return fmt.Errorf("error sending notifications: %w", err)
+	}
+	if *deferred {
+		if err := a.SubscriptionService.SendDeferredNotifications(ctx); err != nil {
+			return fmt.Errorf("error sending deferred notifications: %w", err)
+		}
+	}
+	return nil
+}